@@ -5,6 +5,7 @@ import (
 	"log"
 
 	"github.com/cimnine/netbox-dhcp/cache"
+	"github.com/cimnine/netbox-dhcp/ddns"
 	"github.com/cimnine/netbox-dhcp/dhcp/config"
 	"github.com/cimnine/netbox-dhcp/netbox"
 	"gopkg.in/yaml.v2"
@@ -15,6 +16,7 @@ type Configuration struct {
 	Cache  cache.CacheConfig
 	Daemon config.DaemonConfig
 	DHCP   config.DHCPConfig `yaml:"dhcp"`
+	DDNS   ddns.DDNSConfig   `yaml:"ddns"`
 }
 
 func ReadConfig(filename string) (conf Configuration, err error) {
@@ -30,5 +32,11 @@ func ReadConfig(filename string) (conf Configuration, err error) {
 		return conf, err
 	}
 
+	err = conf.DHCP.ValidateCustomOptions()
+	if err != nil {
+		log.Fatalln("Invalid config file.", err)
+		return conf, err
+	}
+
 	return conf, err
 }
@@ -0,0 +1,56 @@
+package ddns
+
+import (
+	"time"
+)
+
+type DDNSConfig struct {
+	Enabled bool
+	// Server is the authoritative DNS server the updates are sent to, as host:port.
+	Server   string
+	Protocol string
+	Timeout  string
+	TTL      string `yaml:"ttl"`
+	// ForwardZones and ReverseZones are the zones that are updated.
+	// A record is updated in the zone that is the longest suffix of its name.
+	ForwardZones []string `yaml:"forward_zones"`
+	ReverseZones []string `yaml:"reverse_zones"`
+	// OverrideClientUpdates updates the A record even if the client wants to update it itself.
+	OverrideClientUpdates bool `yaml:"override_client_updates"`
+	// UpdateWithoutFQDN updates the records of clients that don't send the Client FQDN option.
+	UpdateWithoutFQDN bool `yaml:"update_without_fqdn"`
+	TSIG              struct {
+		KeyName   string `yaml:"key_name"`
+		Algorithm string
+		Secret    string
+	} `yaml:"tsig"`
+}
+
+// TimeoutValue returns how long to wait for the DNS server's answer.
+func (c *DDNSConfig) TimeoutValue() time.Duration {
+	timeout, err := time.ParseDuration(c.Timeout)
+	if err != nil {
+		return 5 * time.Second
+	}
+
+	return timeout
+}
+
+// TTLValue returns the TTL of the records that are added.
+func (c *DDNSConfig) TTLValue() time.Duration {
+	ttl, err := time.ParseDuration(c.TTL)
+	if err != nil {
+		return 5 * time.Minute
+	}
+
+	return ttl
+}
+
+// ProtocolValue returns the protocol the updates are sent with, 'udp' or 'tcp'.
+func (c *DDNSConfig) ProtocolValue() string {
+	if c.Protocol == "tcp" {
+		return "tcp"
+	}
+
+	return "udp"
+}
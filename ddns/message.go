@@ -0,0 +1,204 @@
+package ddns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// This implements the DNS UPDATE message
+// https://tools.ietf.org/html/rfc2136
+
+const (
+	typeA    uint16 = 1
+	typeSOA  uint16 = 6
+	typePTR  uint16 = 12
+	typeAAAA uint16 = 28
+	typeTSIG uint16 = 250
+
+	classIN   uint16 = 1
+	classNONE uint16 = 254
+	classANY  uint16 = 255
+
+	opcodeUpdate = 5
+	headerSize   = 12
+)
+
+// rcodeNames are the response codes an UPDATE can fail with.
+// See https://tools.ietf.org/html/rfc2136#section-2.2 and https://tools.ietf.org/html/rfc8945#section-5.3
+var rcodeNames = map[int]string{
+	1:  "FORMERR",
+	2:  "SERVFAIL",
+	3:  "NXDOMAIN",
+	4:  "NOTIMP",
+	5:  "REFUSED",
+	6:  "YXDOMAIN",
+	7:  "YXRRSET",
+	8:  "NXRRSET",
+	9:  "NOTAUTH",
+	10: "NOTZONE",
+	16: "BADSIG",
+	17: "BADKEY",
+	18: "BADTIME",
+}
+
+var errShortMessage = errors.New("the DNS message is too short")
+
+// resourceRecord is an entry of the update section.
+type resourceRecord struct {
+	name  string
+	rtype uint16
+	class uint16
+	ttl   uint32
+	rdata []byte
+}
+
+// addRR adds an RR to an RRset.
+func addRR(name string, rtype uint16, ttl uint32, rdata []byte) resourceRecord {
+	return resourceRecord{name: name, rtype: rtype, class: classIN, ttl: ttl, rdata: rdata}
+}
+
+// deleteRRset deletes all RRs of the given type.
+func deleteRRset(name string, rtype uint16) resourceRecord {
+	return resourceRecord{name: name, rtype: rtype, class: classANY}
+}
+
+// deleteRR deletes a single RR from an RRset.
+func deleteRR(name string, rtype uint16, rdata []byte) resourceRecord {
+	return resourceRecord{name: name, rtype: rtype, class: classNONE, rdata: rdata}
+}
+
+// updateMessage builds an UPDATE message for the zone.
+// See https://tools.ietf.org/html/rfc2136#section-2
+func updateMessage(id uint16, zone string, updates []resourceRecord) ([]byte, error) {
+	msg := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(msg[0:2], id)
+	binary.BigEndian.PutUint16(msg[2:4], opcodeUpdate<<11)
+	binary.BigEndian.PutUint16(msg[4:6], 1)                     // ZOCOUNT
+	binary.BigEndian.PutUint16(msg[6:8], 0)                     // PRCOUNT
+	binary.BigEndian.PutUint16(msg[8:10], uint16(len(updates))) // UPCOUNT
+	binary.BigEndian.PutUint16(msg[10:12], 0)                   // ADCOUNT
+
+	zoneName, err := encodeName(zone)
+	if err != nil {
+		return nil, err
+	}
+	msg = append(msg, zoneName...)
+	msg = appendUint16(msg, typeSOA)
+	msg = appendUint16(msg, classIN)
+
+	for _, rr := range updates {
+		msg, err = rr.appendTo(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return msg, nil
+}
+
+func (rr resourceRecord) appendTo(msg []byte) ([]byte, error) {
+	name, err := encodeName(rr.name)
+	if err != nil {
+		return nil, err
+	}
+
+	msg = append(msg, name...)
+	msg = appendUint16(msg, rr.rtype)
+	msg = appendUint16(msg, rr.class)
+	msg = appendUint32(msg, rr.ttl)
+	msg = appendUint16(msg, uint16(len(rr.rdata)))
+	return append(msg, rr.rdata...), nil
+}
+
+// encodeName encodes a domain name as a sequence of labels.
+// See https://tools.ietf.org/html/rfc1035#section-3.1
+func encodeName(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+
+	var encoded []byte
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid label '%s' in the name '%s'", label, name)
+			}
+
+			encoded = append(encoded, byte(len(label)))
+			encoded = append(encoded, label...)
+		}
+	}
+	encoded = append(encoded, 0)
+
+	if len(encoded) > 255 {
+		return nil, fmt.Errorf("the name '%s' is longer than 255 bytes", name)
+	}
+
+	return encoded, nil
+}
+
+// skipName returns the offset after the name that starts at the offset, which may be compressed.
+// See https://tools.ietf.org/html/rfc1035#section-4.1.4
+func skipName(msg []byte, offset int) (int, error) {
+	for {
+		if offset >= len(msg) {
+			return 0, errShortMessage
+		}
+
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			return offset + 1, nil
+		case length&0xC0 == 0xC0:
+			return offset + 2, nil
+		default:
+			offset += 1 + length
+		}
+	}
+}
+
+// reverseName returns the name of the PTR record of the IP.
+// See https://tools.ietf.org/html/rfc1035#section-3.5 and https://tools.ietf.org/html/rfc3596#section-2.5
+func reverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	ip16 := ip.To16()
+	nibbles := make([]string, 0, 32)
+	for i := len(ip16) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, fmt.Sprintf("%x", ip16[i]&0x0F), fmt.Sprintf("%x", ip16[i]>>4))
+	}
+	return strings.Join(nibbles, ".") + ".ip6.arpa"
+}
+
+// addressRecord returns the type and the data of the address record of the IP.
+func addressRecord(ip net.IP) (uint16, []byte) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return typeA, ip4
+	}
+	return typeAAAA, ip.To16()
+}
+
+// findZone returns the zone that is the longest suffix of the name, or "" if there is none.
+func findZone(name string, zones []string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	found := ""
+	for _, zone := range zones {
+		z := strings.ToLower(strings.TrimSuffix(zone, "."))
+		if (name == z || strings.HasSuffix(name, "."+z)) && len(z) > len(found) {
+			found = z
+		}
+	}
+	return found
+}
+
+func appendUint16(data []byte, value uint16) []byte {
+	return append(data, byte(value>>8), byte(value))
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	return append(data, byte(value>>24), byte(value>>16), byte(value>>8), byte(value))
+}
@@ -0,0 +1,279 @@
+package ddns
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+	"time"
+)
+
+// This implements Secret Key Transaction Authentication for DNS (TSIG)
+// https://tools.ietf.org/html/rfc8945
+
+// fudge is the permitted difference in seconds between the clocks of this server and the DNS server.
+const fudge = 300
+
+// algorithms maps the algorithm names to their hash functions.
+// See https://tools.ietf.org/html/rfc8945#section-6
+var algorithms = map[string]func() hash.Hash{
+	"hmac-md5.sig-alg.reg.int": md5.New,
+	"hmac-sha1":                sha1.New,
+	"hmac-sha224":              sha256.New224,
+	"hmac-sha256":              sha256.New,
+	"hmac-sha384":              sha512.New384,
+	"hmac-sha512":              sha512.New,
+}
+
+var errNoSignature = errors.New("the DNS response is not signed")
+
+// errBadTime is returned for a response that was signed outside of its fudge window.
+// See https://tools.ietf.org/html/rfc8945#section-5.2.3
+var errBadTime = errors.New("the DNS response was signed outside the permitted time window (BADTIME)")
+
+// tsigKey is the shared secret the messages are signed with.
+type tsigKey struct {
+	name      string
+	algorithm string
+	secret    []byte
+}
+
+// newTSIGKey validates the key. Its name and algorithm are stored in the canonical (lower case) form.
+// The algorithm defaults to 'hmac-sha256'. The short name 'hmac-md5' is accepted as well.
+func newTSIGKey(name, algorithm, secret string) (*tsigKey, error) {
+	algorithm = strings.ToLower(strings.TrimSuffix(algorithm, "."))
+	switch algorithm {
+	case "":
+		algorithm = "hmac-sha256"
+	case "hmac-md5":
+		algorithm = "hmac-md5.sig-alg.reg.int"
+	}
+
+	if _, ok := algorithms[algorithm]; !ok {
+		return nil, fmt.Errorf("unknown TSIG algorithm '%s'", algorithm)
+	}
+
+	decodedSecret, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("the TSIG secret is not base64 encoded: %s", err)
+	}
+
+	return &tsigKey{
+		name:      strings.ToLower(strings.TrimSuffix(name, ".")),
+		algorithm: algorithm,
+		secret:    decodedSecret,
+	}, nil
+}
+
+// sign appends the TSIG record to the message and returns the signed message and its MAC.
+// See https://tools.ietf.org/html/rfc8945#section-5.1
+func (k *tsigKey) sign(msg []byte, now time.Time) ([]byte, []byte, error) {
+	if len(msg) < headerSize {
+		return nil, nil, errShortMessage
+	}
+
+	variables, err := k.variables(uint64(now.Unix()), fudge, 0, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mac := k.digest(msg, variables)
+
+	algorithmName, _ := encodeName(k.algorithm)
+	rdata := append([]byte{}, algorithmName...)
+	rdata = appendTimeSigned(rdata, uint64(now.Unix()))
+	rdata = appendUint16(rdata, fudge)
+	rdata = appendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, msg[0:2]...) // Original ID
+	rdata = appendUint16(rdata, 0)     // Error
+	rdata = appendUint16(rdata, 0)     // Other Len
+
+	signed := append([]byte{}, msg...)
+	signed, err = resourceRecord{name: k.name, rtype: typeTSIG, class: classANY, rdata: rdata}.appendTo(signed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	arcount := binary.BigEndian.Uint16(signed[10:12])
+	binary.BigEndian.PutUint16(signed[10:12], arcount+1)
+
+	return signed, mac, nil
+}
+
+// verify checks the TSIG record of the response to a request that was signed with requestMAC.
+// A response with a valid signature must also have been signed within its fudge window around now.
+// It returns the TSIG error code of the response.
+// See https://tools.ietf.org/html/rfc8945#section-5.3
+func (k *tsigKey) verify(response []byte, requestMAC []byte, now time.Time) (int, error) {
+	tsigStart, err := findTSIG(response)
+	if err != nil {
+		return 0, err
+	}
+
+	offset, err := skipName(response, tsigStart)
+	if err != nil {
+		return 0, err
+	}
+
+	// TYPE, CLASS, TTL and RDLENGTH
+	offset += 10
+	rdataStart := offset
+
+	offset, err = skipName(response, offset)
+	if err != nil {
+		return 0, err
+	}
+	algorithm := response[rdataStart:offset]
+
+	if len(response) < offset+10 {
+		return 0, errShortMessage
+	}
+	timeSigned := uint64(binary.BigEndian.Uint16(response[offset:offset+2]))<<32 | uint64(binary.BigEndian.Uint32(response[offset+2:offset+6]))
+	responseFudge := binary.BigEndian.Uint16(response[offset+6 : offset+8])
+	macSize := int(binary.BigEndian.Uint16(response[offset+8 : offset+10]))
+	offset += 10
+
+	if len(response) < offset+macSize+6 {
+		return 0, errShortMessage
+	}
+	mac := response[offset : offset+macSize]
+	originalID := response[offset+macSize : offset+macSize+2]
+	tsigError := int(binary.BigEndian.Uint16(response[offset+macSize+2 : offset+macSize+4]))
+	otherLen := int(binary.BigEndian.Uint16(response[offset+macSize+4 : offset+macSize+6]))
+	offset += macSize + 6
+
+	if len(response) < offset+otherLen {
+		return 0, errShortMessage
+	}
+	otherData := response[offset : offset+otherLen]
+
+	expectedAlgorithm, _ := encodeName(k.algorithm)
+	if !strings.EqualFold(string(algorithm), string(expectedAlgorithm)) {
+		return tsigError, fmt.Errorf("the DNS response is signed with another algorithm")
+	}
+
+	// The response is digested as it was before the TSIG record was added.
+	unsigned := append([]byte{}, response[:tsigStart]...)
+	copy(unsigned[0:2], originalID)
+	arcount := binary.BigEndian.Uint16(unsigned[10:12])
+	binary.BigEndian.PutUint16(unsigned[10:12], arcount-1)
+
+	variables, err := k.variables(timeSigned, responseFudge, tsigError, otherData)
+	if err != nil {
+		return tsigError, err
+	}
+
+	prefix := appendUint16(nil, uint16(len(requestMAC)))
+	prefix = append(prefix, requestMAC...)
+
+	if !hmac.Equal(mac, k.digest(append(prefix, unsigned...), variables)) {
+		return tsigError, fmt.Errorf("the signature of the DNS response is invalid")
+	}
+
+	// The time is checked after the signature, see RFC8945 Section 5.2.3.
+	if !withinFudge(timeSigned, responseFudge, now) {
+		return tsigError, errBadTime
+	}
+
+	return tsigError, nil
+}
+
+// withinFudge returns true if the time signed is at most fudge seconds before or after now.
+func withinFudge(timeSigned uint64, fudge uint16, now time.Time) bool {
+	nowUnix := uint64(now.Unix())
+	if timeSigned > nowUnix {
+		return timeSigned-nowUnix <= uint64(fudge)
+	}
+	return nowUnix-timeSigned <= uint64(fudge)
+}
+
+// variables returns the TSIG variables that are digested together with the message.
+// See https://tools.ietf.org/html/rfc8945#section-4.3.3
+func (k *tsigKey) variables(timeSigned uint64, fudge uint16, tsigError int, otherData []byte) ([]byte, error) {
+	name, err := encodeName(k.name)
+	if err != nil {
+		return nil, err
+	}
+	algorithmName, err := encodeName(k.algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	variables := append([]byte{}, name...)
+	variables = appendUint16(variables, classANY)
+	variables = appendUint32(variables, 0) // TTL
+	variables = append(variables, algorithmName...)
+	variables = appendTimeSigned(variables, timeSigned)
+	variables = appendUint16(variables, fudge)
+	variables = appendUint16(variables, uint16(tsigError))
+	variables = appendUint16(variables, uint16(len(otherData)))
+	return append(variables, otherData...), nil
+}
+
+func (k *tsigKey) digest(msg, variables []byte) []byte {
+	mac := hmac.New(algorithms[k.algorithm], k.secret)
+	mac.Write(msg)
+	mac.Write(variables)
+	return mac.Sum(nil)
+}
+
+// findTSIG returns the offset of the TSIG record, which must be the last record of the additional section.
+func findTSIG(msg []byte) (int, error) {
+	if len(msg) < headerSize {
+		return 0, errShortMessage
+	}
+
+	zocount := int(binary.BigEndian.Uint16(msg[4:6]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:8])) +
+		int(binary.BigEndian.Uint16(msg[8:10])) +
+		int(binary.BigEndian.Uint16(msg[10:12]))
+	if rrcount == 0 {
+		return 0, errNoSignature
+	}
+
+	offset := headerSize
+	for i := 0; i < zocount; i++ {
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return 0, err
+		}
+		offset = end + 4
+	}
+
+	var rrStart, rrType int
+	for i := 0; i < rrcount; i++ {
+		rrStart = offset
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return 0, err
+		}
+		if len(msg) < end+10 {
+			return 0, errShortMessage
+		}
+		rrType = int(binary.BigEndian.Uint16(msg[end : end+2]))
+		offset = end + 10 + int(binary.BigEndian.Uint16(msg[end+8:end+10]))
+	}
+
+	if uint16(rrType) != typeTSIG || int(binary.BigEndian.Uint16(msg[10:12])) == 0 {
+		return 0, errNoSignature
+	}
+	if offset > len(msg) {
+		return 0, errShortMessage
+	}
+
+	return rrStart, nil
+}
+
+// appendTimeSigned appends the 48 bit time stamp.
+func appendTimeSigned(data []byte, timeSigned uint64) []byte {
+	data = appendUint16(data, uint16(timeSigned>>32))
+	return appendUint32(data, uint32(timeSigned))
+}
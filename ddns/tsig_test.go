@@ -0,0 +1,225 @@
+package ddns
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+const testSecret = "c2VjcmV0IGtleSBmb3IgdGVzdHM="
+
+func newTestKey(t *testing.T, algorithm string) *tsigKey {
+	t.Helper()
+
+	key, err := newTSIGKey("DHCP-Key.", algorithm, testSecret)
+	if err != nil {
+		t.Fatalf("can't create the key: %s", err)
+	}
+	return key
+}
+
+// signResponse signs the response to a request that was signed with requestMAC, as a DNS server would.
+// See https://tools.ietf.org/html/rfc8945#section-5.3
+func signResponse(t *testing.T, k *tsigKey, response, requestMAC []byte, timeSigned uint64, tsigError int) []byte {
+	t.Helper()
+
+	variables, err := k.variables(timeSigned, fudge, tsigError, nil)
+	if err != nil {
+		t.Fatalf("can't encode the TSIG variables: %s", err)
+	}
+
+	prefix := appendUint16(nil, uint16(len(requestMAC)))
+	prefix = append(prefix, requestMAC...)
+	mac := k.digest(append(prefix, response...), variables)
+
+	algorithmName, _ := encodeName(k.algorithm)
+	rdata := append([]byte{}, algorithmName...)
+	rdata = appendTimeSigned(rdata, timeSigned)
+	rdata = appendUint16(rdata, fudge)
+	rdata = appendUint16(rdata, uint16(len(mac)))
+	rdata = append(rdata, mac...)
+	rdata = append(rdata, response[0:2]...)
+	rdata = appendUint16(rdata, uint16(tsigError))
+	rdata = appendUint16(rdata, 0)
+
+	signed, err := resourceRecord{name: k.name, rtype: typeTSIG, class: classANY, rdata: rdata}.appendTo(append([]byte{}, response...))
+	if err != nil {
+		t.Fatalf("can't append the TSIG record: %s", err)
+	}
+	binary.BigEndian.PutUint16(signed[10:12], binary.BigEndian.Uint16(signed[10:12])+1)
+	return signed
+}
+
+func testResponse(t *testing.T) []byte {
+	t.Helper()
+
+	msg, err := updateMessage(0x1234, "example.com", nil)
+	if err != nil {
+		t.Fatalf("can't create the message: %s", err)
+	}
+	msg[2] |= 0x80 // QR: response
+	return msg
+}
+
+func TestNewTSIGKey(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		want      string
+		wantErr   bool
+	}{
+		{algorithm: "", want: "hmac-sha256"},
+		{algorithm: "HMAC-SHA512.", want: "hmac-sha512"},
+		{algorithm: "hmac-md5", want: "hmac-md5.sig-alg.reg.int"},
+		{algorithm: "hmac-sha3", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		key, err := newTSIGKey("DHCP-Key.", tt.algorithm, testSecret)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expected an error for algorithm '%s'", tt.algorithm)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("unexpected error for algorithm '%s': %s", tt.algorithm, err)
+		} else if key.algorithm != tt.want || key.name != "dhcp-key" {
+			t.Errorf("got key '%s' with algorithm '%s', want 'dhcp-key' with '%s'", key.name, key.algorithm, tt.want)
+		}
+	}
+
+	if _, err := newTSIGKey("dhcp-key", "", "not base64!"); err == nil {
+		t.Error("expected an error for a secret that isn't base64 encoded")
+	}
+}
+
+func TestSign(t *testing.T) {
+	key := newTestKey(t, "hmac-sha256")
+	msg, err := updateMessage(0x1234, "example.com", nil)
+	if err != nil {
+		t.Fatalf("can't create the message: %s", err)
+	}
+
+	signed, mac, err := key.sign(msg, time.Unix(1600000000, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if len(mac) != 32 {
+		t.Errorf("got a MAC of %d bytes, want 32", len(mac))
+	}
+	if arcount := binary.BigEndian.Uint16(signed[10:12]); arcount != 1 {
+		t.Errorf("got ARCOUNT %d, want 1", arcount)
+	}
+	if tsigStart, err := findTSIG(signed); err != nil || tsigStart != len(msg) {
+		t.Errorf("expected the TSIG record at %d, got %d (%v)", len(msg), tsigStart, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	key := newTestKey(t, "hmac-sha256")
+	requestMAC := []byte("the MAC of the request")
+	now := time.Unix(1600000000, 0)
+	signedAt := uint64(now.Unix())
+
+	tests := []struct {
+		name      string
+		response  []byte
+		tsigError int
+		wantErr   error
+		anyErr    bool
+	}{
+		{
+			name:     "valid",
+			response: signResponse(t, key, testResponse(t), requestMAC, signedAt, 0),
+		},
+		{
+			name:     "within the fudge window",
+			response: signResponse(t, key, testResponse(t), requestMAC, signedAt-fudge, 0),
+		},
+		{
+			name:     "signed too long ago",
+			response: signResponse(t, key, testResponse(t), requestMAC, signedAt-fudge-1, 0),
+			wantErr:  errBadTime,
+		},
+		{
+			name:     "signed in the future",
+			response: signResponse(t, key, testResponse(t), requestMAC, signedAt+fudge+1, 0),
+			wantErr:  errBadTime,
+		},
+		{
+			name:      "TSIG error of the server",
+			response:  signResponse(t, key, testResponse(t), requestMAC, signedAt, 16),
+			tsigError: 16,
+		},
+		{
+			name:     "other request MAC",
+			response: signResponse(t, key, testResponse(t), []byte("another MAC"), signedAt, 0),
+			anyErr:   true,
+		},
+		{
+			name:     "other key",
+			response: signResponse(t, newTestKey(t, "hmac-sha512"), testResponse(t), requestMAC, signedAt, 0),
+			anyErr:   true,
+		},
+		{
+			name:     "unsigned",
+			response: testResponse(t),
+			wantErr:  errNoSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tsigError, err := key.verify(tt.response, requestMAC, now)
+
+			switch {
+			case tt.anyErr:
+				if err == nil {
+					t.Error("expected an error")
+				}
+			case err != tt.wantErr:
+				t.Errorf("got error '%v', want '%v'", err, tt.wantErr)
+			case tsigError != tt.tsigError:
+				t.Errorf("got TSIG error %d, want %d", tsigError, tt.tsigError)
+			}
+		})
+	}
+}
+
+func TestVerifyTamperedResponse(t *testing.T) {
+	key := newTestKey(t, "hmac-md5")
+	requestMAC := []byte("the MAC of the request")
+	now := time.Unix(1600000000, 0)
+
+	response := signResponse(t, key, testResponse(t), requestMAC, uint64(now.Unix()), 0)
+	response[3] |= 0x05 // RCODE: REFUSED
+
+	if _, err := key.verify(response, requestMAC, now); err == nil {
+		t.Error("expected an error for a tampered response")
+	}
+}
+
+func TestWithinFudge(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+
+	tests := []struct {
+		timeSigned uint64
+		fudge      uint16
+		want       bool
+	}{
+		{1600000000, 0, true},
+		{1600000000 - 300, 300, true},
+		{1600000000 + 300, 300, true},
+		{1600000000 - 301, 300, false},
+		{1600000000 + 301, 300, false},
+		{0, 300, false},
+	}
+
+	for _, tt := range tests {
+		if got := withinFudge(tt.timeSigned, tt.fudge, now); got != tt.want {
+			t.Errorf("withinFudge(%d, %d) = %v, want %v", tt.timeSigned, tt.fudge, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package ddns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+)
+
+// expiryInterval is how often the records of expired leases are removed.
+const expiryInterval = time.Minute
+
+// Record describes the DNS records of a lease.
+// Forward is the A or AAAA record of the FQDN, Reverse the PTR record of the IP.
+type Record struct {
+	FQDN    string
+	IP      net.IP
+	Forward bool
+	Reverse bool
+}
+
+// Equal returns true if both records result in the same DNS records.
+func (r Record) Equal(other Record) bool {
+	return strings.EqualFold(r.FQDN, other.FQDN) && r.IP.Equal(other.IP) &&
+		r.Forward == other.Forward && r.Reverse == other.Reverse
+}
+
+// A Tracker remembers the records that were added, so that they can be removed when the lease ends.
+type Tracker interface {
+	TrackDNS(record Record, expiry time.Time) error
+	// LookupDNS returns nil if no record is tracked for the IP.
+	LookupDNS(ip net.IP) (*Record, error)
+	UntrackDNS(ip net.IP) error
+	ExpiredDNS(now time.Time) ([]Record, error)
+}
+
+// Updater keeps the DNS records of the leases up to date with RFC2136 dynamic updates.
+type Updater struct {
+	Config  *DDNSConfig
+	Tracker Tracker
+	key     *tsigKey
+	stop    chan bool
+}
+
+func NewUpdater(config *DDNSConfig, tracker Tracker) (*Updater, error) {
+	u := Updater{
+		Config:  config,
+		Tracker: tracker,
+		stop:    make(chan bool),
+	}
+
+	if config.TSIG.KeyName != "" {
+		key, err := newTSIGKey(config.TSIG.KeyName, config.TSIG.Algorithm, config.TSIG.Secret)
+		if err != nil {
+			return nil, err
+		}
+		u.key = key
+	} else {
+		log.Printf("WARN: No TSIG key configured. The DNS updates are not signed.")
+	}
+
+	return &u, nil
+}
+
+// Start removes the records of expired leases periodically until Stop is called.
+func (u *Updater) Start() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-u.stop:
+			return
+		case now := <-ticker.C:
+			u.removeExpired(now)
+		}
+	}
+}
+
+func (u *Updater) Stop() {
+	close(u.stop)
+}
+
+// Register adds the records of a lease that lasts for the given duration.
+// If the same records were already added for the IP, only the expiry is updated.
+// Records that were added for the IP before with another name are removed.
+func (u *Updater) Register(record Record, lease time.Duration) error {
+	tracked, err := u.Tracker.LookupDNS(record.IP)
+	if err != nil {
+		return err
+	}
+
+	if tracked == nil || !tracked.Equal(record) {
+		if tracked != nil {
+			u.remove(*tracked)
+		}
+
+		err = u.add(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	return u.Tracker.TrackDNS(record, time.Now().Add(lease))
+}
+
+// Unregister removes the records that were added for the IP.
+func (u *Updater) Unregister(ip net.IP) error {
+	tracked, err := u.Tracker.LookupDNS(ip)
+	if err != nil || tracked == nil {
+		return err
+	}
+
+	u.remove(*tracked)
+	return u.Tracker.UntrackDNS(ip)
+}
+
+func (u *Updater) removeExpired(now time.Time) {
+	records, err := u.Tracker.ExpiredDNS(now)
+	if err != nil {
+		log.Printf("Can't receive the DNS records of expired leases: %s", err)
+		return
+	}
+
+	for _, record := range records {
+		log.Printf("The lease of '%s' ('%s') expired. Removing its DNS records.", record.IP, record.FQDN)
+		u.remove(record)
+
+		if err := u.Tracker.UntrackDNS(record.IP); err != nil {
+			log.Printf("Can't stop tracking the DNS records of '%s': %s", record.IP, err)
+		}
+	}
+}
+
+// add adds the A or AAAA record of the name, next to the records that already exist,
+// and replaces the PTR record of the IP.
+func (u *Updater) add(record Record) error {
+	ttl := uint32(u.Config.TTLValue().Seconds())
+
+	if record.Forward {
+		rtype, rdata := addressRecord(record.IP)
+		err := u.update(record.FQDN, u.Config.ForwardZones, []resourceRecord{
+			addRR(record.FQDN, rtype, ttl, rdata),
+		})
+		if err != nil {
+			log.Printf("Can't add the address record '%s' -> '%s': %s", record.FQDN, record.IP, err)
+			return err
+		}
+		log.Printf("Added the address record '%s' -> '%s'.", record.FQDN, record.IP)
+	}
+
+	if record.Reverse {
+		ptrName := reverseName(record.IP)
+		target, err := encodeName(record.FQDN)
+		if err != nil {
+			return err
+		}
+
+		err = u.update(ptrName, u.Config.ReverseZones, []resourceRecord{
+			deleteRRset(ptrName, typePTR),
+			addRR(ptrName, typePTR, ttl, target),
+		})
+		if err != nil {
+			log.Printf("Can't add the PTR record '%s' -> '%s': %s", ptrName, record.FQDN, err)
+			return err
+		}
+		log.Printf("Added the PTR record '%s' -> '%s'.", ptrName, record.FQDN)
+	}
+
+	return nil
+}
+
+// remove removes the A or AAAA record of the name, but only the one with the IP, and the PTR record of the IP.
+// Errors are logged, as the remaining records can't be removed any other way.
+func (u *Updater) remove(record Record) {
+	if record.Forward {
+		rtype, rdata := addressRecord(record.IP)
+		err := u.update(record.FQDN, u.Config.ForwardZones, []resourceRecord{
+			deleteRR(record.FQDN, rtype, rdata),
+		})
+		if err != nil {
+			log.Printf("Can't remove the address record '%s' -> '%s': %s", record.FQDN, record.IP, err)
+		} else {
+			log.Printf("Removed the address record '%s' -> '%s'.", record.FQDN, record.IP)
+		}
+	}
+
+	if record.Reverse {
+		ptrName := reverseName(record.IP)
+		err := u.update(ptrName, u.Config.ReverseZones, []resourceRecord{
+			deleteRRset(ptrName, typePTR),
+		})
+		if err != nil {
+			log.Printf("Can't remove the PTR record '%s': %s", ptrName, err)
+		} else {
+			log.Printf("Removed the PTR record '%s'.", ptrName)
+		}
+	}
+}
+
+// update sends an UPDATE for the name to the zone it belongs to.
+func (u *Updater) update(name string, zones []string, updates []resourceRecord) error {
+	zone := findZone(name, zones)
+	if zone == "" {
+		return fmt.Errorf("the name '%s' is in none of the configured zones", name)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return err
+	}
+
+	msg, err := updateMessage(id, zone, updates)
+	if err != nil {
+		return err
+	}
+
+	var requestMAC []byte
+	if u.key != nil {
+		msg, requestMAC, err = u.key.sign(msg, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	response, err := u.exchange(msg)
+	if err != nil {
+		return err
+	}
+
+	return u.checkResponse(response, id, requestMAC)
+}
+
+// exchange sends the message to the DNS server and returns its response.
+// Over TCP, messages are prefixed with their length.
+// See https://tools.ietf.org/html/rfc1035#section-4.2.2
+func (u *Updater) exchange(msg []byte) ([]byte, error) {
+	protocol := u.Config.ProtocolValue()
+
+	conn, err := net.DialTimeout(protocol, u.Config.Server, u.Config.TimeoutValue())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(u.Config.TimeoutValue()))
+	if err != nil {
+		return nil, err
+	}
+
+	if protocol == "tcp" {
+		_, err = conn.Write(append(appendUint16(nil, uint16(len(msg))), msg...))
+		if err != nil {
+			return nil, err
+		}
+
+		length := make([]byte, 2)
+		if _, err = io.ReadFull(conn, length); err != nil {
+			return nil, err
+		}
+
+		response := make([]byte, binary.BigEndian.Uint16(length))
+		_, err = io.ReadFull(conn, response)
+		return response, err
+	}
+
+	_, err = conn.Write(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	response := make([]byte, 65535)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, err
+	}
+
+	return response[:n], nil
+}
+
+// checkResponse returns an error if the update was not successful or the response is not authentic.
+func (u *Updater) checkResponse(response []byte, id uint16, requestMAC []byte) error {
+	if len(response) < headerSize {
+		return errShortMessage
+	}
+
+	if binary.BigEndian.Uint16(response[0:2]) != id || response[2]&0x80 == 0 {
+		return fmt.Errorf("the DNS response does not belong to the update")
+	}
+
+	rcode := int(response[3] & 0x0F)
+
+	if u.key != nil {
+		// Errors concerning the signature itself, and some other errors, are sent unsigned.
+		tsigError, err := u.key.verify(response, requestMAC, time.Now())
+		if tsigError != 0 {
+			rcode = tsigError
+		} else if err != nil && (rcode == 0 || err != errNoSignature) {
+			return err
+		}
+	}
+
+	if rcode != 0 {
+		name, ok := rcodeNames[rcode]
+		if !ok {
+			name = fmt.Sprintf("RCODE %d", rcode)
+		}
+		return fmt.Errorf("the DNS server answered with %s", name)
+	}
+
+	return nil
+}
+
+func randomID() (uint16, error) {
+	id := make([]byte, 2)
+	if _, err := rand.Read(id); err != nil {
+		return 0, errors.New("can't create a random message ID")
+	}
+
+	return binary.BigEndian.Uint16(id), nil
+}
@@ -6,25 +6,73 @@ import (
 	"log"
 	"net"
 	"strings"
+	"time"
 
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
 	"github.com/cimnine/netbox-dhcp/dhcp/v6/consts"
 	"github.com/satori/go.uuid"
 )
 
 type DHCPConfig struct {
-	ServerUUID          string `yaml:"server_uuid"`
-	ReservationDuration string `yaml:"reservation_duration"`
-	LeaseDuration       string `yaml:"lease_duration"`
-	T1Duration          string `yaml:"t1_duration"`
-	T2Duration          string `yaml:"t2_duration"`
+	ServerUUID          string               `yaml:"server_uuid"`
+	ReservationDuration string               `yaml:"reservation_duration"`
+	LeaseDuration       string               `yaml:"lease_duration"`
+	MinLeaseDuration    string               `yaml:"min_lease_duration"`
+	MaxLeaseDuration    string               `yaml:"max_lease_duration"`
+	T1Duration          string               `yaml:"t1_duration"`
+	T2Duration          string               `yaml:"t2_duration"`
+	QuarantineDuration  string               `yaml:"quarantine_duration"`
+	DeclineProbation    string               `yaml:"decline_probation_duration"`
+	ForceRenewInterval  string               `yaml:"force_renew_interval"`
+	VendorOptions       []VendorOptionConfig `yaml:"vendor_options"`
 	DefaultOptions      struct {
-		NextServer        string   `yaml:"next_server"`
-		BootFileName      string   `yaml:"bootfile_name"`
-		DomainName        string   `yaml:"domain_name"`
-		DomainNameServers []string `yaml:"dns_servers"`
-		NTPServers        []string `yaml:"ntp_servers"`
-		Routers           []string `yaml:"routers"`
+		NextServer        string                 `yaml:"next_server"`
+		BootFileName      string                 `yaml:"bootfile_name"`
+		DomainName        string                 `yaml:"domain_name"`
+		DomainSearch      []string               `yaml:"domain_search"`
+		DomainNameServers []string               `yaml:"dns_servers"`
+		NTPServers        []string               `yaml:"ntp_servers"`
+		TimeZone          string                 `yaml:"time_zone"`
+		Routers           []string               `yaml:"routers"`
+		Boot              map[string]BootOptions `yaml:"boot"`
+		Options           []CustomOption         `yaml:"options"`
 	} `yaml:"default_options"`
+
+	// defaultCustomOptions are the DefaultOptions.Options, once they are validated by ValidateCustomOptions.
+	defaultCustomOptions []v4.CustomOption
+}
+
+// CustomOption is an arbitrary DHCP option, see v4.NewCustomOption.
+type CustomOption struct {
+	Code  int         `yaml:"code"`
+	Type  string      `yaml:"type"`
+	Value interface{} `yaml:"value"`
+}
+
+// ValidateCustomOptions validates and encodes the custom default options once, when the configuration is read.
+func (d *DHCPConfig) ValidateCustomOptions() error {
+	d.defaultCustomOptions = nil
+	for _, option := range d.DefaultOptions.Options {
+		customOption, err := v4.NewCustomOption(option.Code, option.Type, option.Value)
+		if err != nil {
+			return fmt.Errorf("invalid default option %d: %s", option.Code, err)
+		}
+		d.defaultCustomOptions = v4.MergeCustomOptions(d.defaultCustomOptions, []v4.CustomOption{customOption})
+	}
+
+	return nil
+}
+
+// DefaultCustomOptions returns the custom default options that were validated by ValidateCustomOptions.
+func (d DHCPConfig) DefaultCustomOptions() []v4.CustomOption {
+	return d.defaultCustomOptions
+}
+
+// BootOptions describe what clients of a certain architecture boot.
+type BootOptions struct {
+	BootFileName string `yaml:"bootfile_name"`
+	NextServer   string `yaml:"next_server"`
+	HTTPURL      string `yaml:"http_url"`
 }
 
 // ServerDUID returns the server's DUID formatted according to
@@ -42,6 +90,72 @@ func (d DHCPConfig) ServerDUID() ([]byte, error) {
 	return buf, nil
 }
 
+// VendorOptionConfig is the template of the vendor sub-options of a vendor, see v4.VendorOptionTemplate.
+type VendorOptionConfig struct {
+	VendorClass string `yaml:"vendor_class"`
+	Enterprise  uint32 `yaml:"enterprise"`
+	SubOptions  []struct {
+		Code  int         `yaml:"code"`
+		Type  string      `yaml:"type"`
+		Key   string      `yaml:"key"`
+		Value interface{} `yaml:"value"`
+	} `yaml:"sub_options"`
+}
+
+// VendorOptionTemplates returns the configured vendor option templates.
+func (d DHCPConfig) VendorOptionTemplates() []v4.VendorOptionTemplate {
+	templates := make([]v4.VendorOptionTemplate, 0, len(d.VendorOptions))
+	for _, vendorOption := range d.VendorOptions {
+		template := v4.VendorOptionTemplate{
+			VendorClass: vendorOption.VendorClass,
+			Enterprise:  vendorOption.Enterprise,
+		}
+
+		for _, subOption := range vendorOption.SubOptions {
+			template.SubOptions = append(template.SubOptions, v4.VendorSubOptionTemplate{
+				Code:  subOption.Code,
+				Type:  subOption.Type,
+				Key:   subOption.Key,
+				Value: subOption.Value,
+			})
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates
+}
+
+// QuarantineDurationValue returns how long IPs that are in use by another host are not handed out.
+func (d DHCPConfig) QuarantineDurationValue() time.Duration {
+	duration, err := time.ParseDuration(d.QuarantineDuration)
+	if err != nil {
+		return 1 * time.Hour
+	}
+
+	return duration
+}
+
+// DeclineProbationValue returns how long IPs that were declined by a client are not handed out.
+func (d DHCPConfig) DeclineProbationValue() time.Duration {
+	duration, err := time.ParseDuration(d.DeclineProbation)
+	if err != nil {
+		return 24 * time.Hour
+	}
+
+	return duration
+}
+
+// ForceRenewIntervalValue returns how often the leases are compared with Netbox, or 0 if they are not.
+func (d DHCPConfig) ForceRenewIntervalValue() time.Duration {
+	duration, err := time.ParseDuration(d.ForceRenewInterval)
+	if err != nil {
+		return 0
+	}
+
+	return duration
+}
+
 type DaemonConfig struct {
 	Daemonize bool
 	Log       struct {
@@ -55,12 +169,71 @@ type DaemonConfig struct {
 type V4ListenerConfig struct {
 	ReplyFrom     string `yaml:"reply_from"`
 	ReplyHostname string `yaml:"reply_hostname"`
+	Authoritative bool   `yaml:"authoritative"`
+	// ProbeBeforeOffer checks whether an IP is already in use before it is offered,
+	// with ARP on the directly attached link and ICMP echo for relayed clients.
+	ProbeBeforeOffer bool   `yaml:"probe_before_offer"`
+	ProbeTimeout     string `yaml:"probe_timeout"`
+	// BOOTP answers BOOTREQUESTs of legacy clients that don't speak DHCP with leases that never expire.
+	BOOTP bool `yaml:"bootp"`
+	// RapidCommit answers a DHCPDISCOVER with the Rapid Commit option with a DHCPACK right away.
+	RapidCommit bool `yaml:"rapid_commit"`
+	// ForceRenew hands a nonce to clients that support it, so that they accept DHCPFORCERENEW.
+	ForceRenew bool `yaml:"force_renew"`
+	// LeaseQuery answers DHCPLEASEQUERYs, BulkLeaseQuery accepts bulk leasequeries on TCP port 67.
+	// Both are only answered for the networks in LeaseQueryAllowed, and for nobody if it's empty.
+	LeaseQuery        bool     `yaml:"leasequery"`
+	BulkLeaseQuery    bool     `yaml:"bulk_leasequery"`
+	LeaseQueryAllowed []string `yaml:"leasequery_allowed"`
+	// MinLeaseDuration and MaxLeaseDuration bound the lease time clients request on this listener,
+	// in place of the global bounds.
+	MinLeaseDuration string `yaml:"min_lease_duration"`
+	MaxLeaseDuration string `yaml:"max_lease_duration"`
 }
 
 func (v *V4ListenerConfig) ReplyFromAddress() net.IP {
 	return net.ParseIP(v.ReplyFrom)
 }
 
+// LeaseQueryAllowedNets returns the networks that may send leasequeries.
+func (v *V4ListenerConfig) LeaseQueryAllowedNets() []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(v.LeaseQueryAllowed))
+	for _, allowed := range v.LeaseQueryAllowed {
+		_, ipNet, err := net.ParseCIDR(allowed)
+		if err != nil {
+			log.Printf("Can't parse '%s' as network. Make sure it's in CIDR notation.", allowed)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+// LeaseBounds returns the bounds of the lease time on this listener, or 0 if the global bound applies.
+func (v *V4ListenerConfig) LeaseBounds() (time.Duration, time.Duration) {
+	minLease, err := time.ParseDuration(v.MinLeaseDuration)
+	if err != nil {
+		minLease = 0
+	}
+
+	maxLease, err := time.ParseDuration(v.MaxLeaseDuration)
+	if err != nil {
+		maxLease = 0
+	}
+
+	return minLease, maxLease
+}
+
+// ProbeTimeoutValue returns how long to wait for an answer to a probe.
+func (v *V4ListenerConfig) ProbeTimeoutValue() time.Duration {
+	timeout, err := time.ParseDuration(v.ProbeTimeout)
+	if err != nil {
+		return 500 * time.Millisecond
+	}
+
+	return timeout
+}
+
 type V6ListenerConfig struct {
 	AdvertiseUnicast bool     `yaml:"advertise_unicast"`
 	ListenTo         []string `yaml:"listen_to"`
@@ -5,23 +5,29 @@ import (
 	"net"
 
 	"github.com/cimnine/netbox-dhcp/configuration"
+	"github.com/cimnine/netbox-dhcp/ddns"
 	"github.com/cimnine/netbox-dhcp/resolver"
 )
 
 type Daemon struct {
 	Configuration *configuration.Configuration
 	Resolver      resolver.Resolver
+	// DNSUpdater is optional and keeps the DNS records of the leases up to date
+	DNSUpdater *ddns.Updater
 
 	dhcpv4Servers map[string]*ServerV4
 	dhcpv6Servers map[string]*ServerV6
+	stopped       chan struct{}
 }
 
-func NewDaemon(config *configuration.Configuration, res resolver.Resolver) Daemon {
+func NewDaemon(config *configuration.Configuration, res resolver.Resolver, dnsUpdater *ddns.Updater) Daemon {
 	d := Daemon{
 		Configuration: config,
 		Resolver:      res,
+		DNSUpdater:    dnsUpdater,
 		dhcpv4Servers: make(map[string]*ServerV4),
 		dhcpv6Servers: make(map[string]*ServerV6),
+		stopped:       make(chan struct{}),
 	}
 
 	d.spawnV4Servers()
@@ -39,7 +45,7 @@ func (d *Daemon) spawnV4Servers() {
 			continue
 		}
 
-		server, err := NewServerV4(&config.DHCP, d.Resolver, *iface, &ifaceConfig)
+		server, err := NewServerV4(&config.DHCP, d.Resolver, d.DNSUpdater, *iface, &ifaceConfig)
 		if err != nil {
 			log.Printf("Can't listen on iface '%s' because of %s", ifaceString, err)
 			continue
@@ -58,7 +64,7 @@ func (d *Daemon) spawnV6Servers() {
 			continue
 		}
 
-		server, err := NewServerV6(&config.DHCP, d.Resolver, *iface, &ifaceConfig)
+		server, err := NewServerV6(&config.DHCP, d.Resolver, d.DNSUpdater, *iface, &ifaceConfig)
 		if err != nil {
 			log.Printf("Can't listen on iface '%s' because of %s", ifaceString, err)
 			continue
@@ -71,12 +77,17 @@ func (d *Daemon) spawnV6Servers() {
 func (d *Daemon) Shutdown() {
 	log.Println("Stopping daemon.")
 
+	close(d.stopped)
+
 	for _, dhcpV4Server := range d.dhcpv4Servers {
 		dhcpV4Server.Stop()
 	}
 	for _, dhcpV6Server := range d.dhcpv6Servers {
 		dhcpV6Server.Stop()
 	}
+	if d.DNSUpdater != nil {
+		d.DNSUpdater.Stop()
+	}
 
 	log.Println("Stopped daemon.")
 }
@@ -90,6 +101,12 @@ func (d *Daemon) Start() {
 	for _, serverOnInterface := range d.dhcpv6Servers {
 		go serverOnInterface.Start()
 	}
+	if d.DNSUpdater != nil {
+		go d.DNSUpdater.Start()
+	}
+	if interval := d.Configuration.DHCP.ForceRenewIntervalValue(); interval > 0 {
+		go d.watchForChangesV4(interval)
+	}
 
 	log.Println("Started daemon.")
 }
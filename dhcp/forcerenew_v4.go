@@ -0,0 +1,187 @@
+package dhcp
+
+import (
+	"log"
+	"net"
+	"time"
+
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// forceRenewTransmissions is how often a DHCPFORCERENEW is sent at most, if the client does not renew.
+// The retransmissions back off exponentially, starting after forceRenewBackoff.
+// See https://tools.ietf.org/html/rfc3203#section-4 and https://tools.ietf.org/html/rfc2131#section-4.1
+const (
+	forceRenewTransmissions = 5
+	forceRenewBackoff       = 4 * time.Second
+)
+
+// ForceRenewV4 sends DHCPFORCERENEW to the clients whose lease changed in Netbox since it was handed out.
+// Only clients which received a nonce from one of the servers of this daemon are considered.
+// A client that does not renew isn't sent DHCPFORCERENEWs again, it picks up the change at T1 at the latest.
+// See https://tools.ietf.org/html/rfc3203
+func (d *Daemon) ForceRenewV4() {
+	servers := make(map[string]*ServerV4)
+	for _, server := range d.dhcpv4Servers {
+		if server.forceRenew {
+			servers[server.replyFrom.String()] = server
+		}
+	}
+	if len(servers) == 0 {
+		return
+	}
+
+	var leases []v4.LeaseV4
+	err := d.Resolver.ActiveLeasesV4(func(lease *v4.LeaseV4) bool {
+		_, ok := servers[lease.Info.Client.ServerID.String()]
+		if ok && len(lease.Info.Client.ForceRenewNonce) > 0 {
+			leases = append(leases, *lease)
+		}
+		return true
+	})
+	if err != nil {
+		log.Printf("Can't list the leases to check for changes: %s", err)
+		return
+	}
+
+	log.Printf("Checking %d leases for changes.", len(leases))
+
+	for i := range leases {
+		lease := &leases[i]
+		server := servers[lease.Info.Client.ServerID.String()]
+
+		if !lease.Info.Client.ForceRenewSent.IsZero() || !server.hasChanged(lease) {
+			continue
+		}
+
+		log.Printf("The lease of '%s' for '%s' changed. Sending DHCPFORCERENEW.", lease.Info.IPAddr, lease.Info.Client.HardwareAddr)
+
+		lease.Info.Client.ForceRenewSent = time.Now()
+		if err := d.Resolver.RecordForceRenewV4(lease); err != nil {
+			log.Printf("Can't record the DHCPFORCERENEW for '%s': %s", lease.Info.IPAddr, err)
+			continue
+		}
+
+		go server.retransmitForceRenew(*lease)
+	}
+}
+
+// retransmitForceRenew sends DHCPFORCERENEW until the client renews its lease, at most forceRenewTransmissions times.
+func (s *ServerV4) retransmitForceRenew(lease v4.LeaseV4) {
+	backoff := forceRenewBackoff
+	for i := 0; i < forceRenewTransmissions; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+
+			if s.isForceRenewed(&lease) {
+				return
+			}
+		}
+
+		if err := s.sendForceRenew(&lease); err != nil {
+			log.Printf("Can't send DHCPFORCERENEW to '%s': %s", lease.Info.IPAddr, err)
+			return
+		}
+	}
+
+	log.Printf("The client of '%s' did not renew after %d DHCPFORCERENEWs. Giving up.", lease.Info.IPAddr, forceRenewTransmissions)
+}
+
+// isForceRenewed returns true if the client renewed the lease since the DHCPFORCERENEWs were started,
+// or if the lease is gone.
+func (s *ServerV4) isForceRenewed(lease *v4.LeaseV4) bool {
+	var current v4.LeaseV4
+	if err := s.Resolver.LeaseQueryV4ByIP(&current, lease.Info.IPAddr.String()); err != nil {
+		return true
+	}
+
+	return !current.Info.Client.ForceRenewSent.Equal(lease.Info.Client.ForceRenewSent)
+}
+
+// watchForChangesV4 calls ForceRenewV4 every interval until the daemon is shut down.
+func (d *Daemon) watchForChangesV4(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.ForceRenewV4()
+		case <-d.stopped:
+			return
+		}
+	}
+}
+
+// hasChanged compares the lease with the current state of the client in Netbox.
+// It returns false if the client can't be found, e.g. because its IP is from a pool.
+func (s *ServerV4) hasChanged(lease *v4.LeaseV4) bool {
+	client := lease.Info.Client
+
+	requestInfo := &v4.RequestInfoV4{LinkAddress: s.replyFrom}
+	if len(client.RelayAgentInformation) > 0 {
+		requestInfo.RelayAgentInfo = (&v4.OptRelayAgentInformation{Data: client.RelayAgentInformation}).RelayAgentInfo()
+	}
+
+	current := lease.Info
+	mac := client.HardwareAddr.String()
+
+	var err error
+	if duid, iaid, ok := clientID(storedClientIdentifier(client.ClientID)); ok {
+		err = s.Resolver.VerifyV4ByID(&current, requestInfo, duid, iaid)
+		if err != nil {
+			err = s.Resolver.VerifyV4ByMAC(&current, requestInfo, mac)
+		}
+	} else {
+		err = s.Resolver.VerifyV4ByMAC(&current, requestInfo, mac)
+	}
+	if err != nil {
+		log.Printf("Can't compare the lease of '%s' with Netbox: %s", lease.Info.IPAddr, err)
+		return false
+	}
+
+	return lease.Info.ConfigurationDiffers(&current)
+}
+
+// storedClientIdentifier parses the client identifier stored with a lease.
+func storedClientIdentifier(data []byte) *v4.OptClientIdentifier {
+	if len(data) == 0 {
+		return nil
+	}
+
+	opt, err := v4.ParseOptClientIdentifier(append([]byte{byte(dhcpv4.OptionClientIdentifier), byte(len(data))}, data...))
+	if err != nil {
+		return nil
+	}
+
+	return opt
+}
+
+// sendForceRenew tells the client to renew its lease right away.
+// The message is authenticated with the nonce the client received in the DHCPACK.
+// See https://tools.ietf.org/html/rfc6704#section-3.3
+func (s *ServerV4) sendForceRenew(lease *v4.LeaseV4) error {
+	out, err := dhcpv4.New()
+	if err != nil {
+		return err
+	}
+
+	client := lease.Info.Client
+	out.SetOpcode(dhcpv4.OpcodeBootReply)
+	out.SetHwType(iana.HwTypeType(client.HardwareType))
+	out.SetHwAddrLen(uint8(len(client.HardwareAddr)))
+	out.SetHopCount(0)
+	out.SetClientIPAddr(lease.Info.IPAddr)
+	out.SetYourIPAddr(net.IPv4zero)
+	out.SetServerIPAddr(net.IPv4zero)
+	out.SetGatewayIPAddr(net.IPv4zero)
+	out.SetClientHwAddr(client.HardwareAddr)
+	out.AddOption(&dhcpv4.OptMessageType{MessageType: v4.MessageTypeForceRenew})
+	out.AddOption(&dhcpv4.OptServerIdentifier{ServerID: s.replyFrom})
+	out.AddOption(v4.ForceRenewDigestOption())
+
+	return s.conn.WriteToClient(*out, lease.Info.IPAddr, client.ForceRenewNonce)
+}
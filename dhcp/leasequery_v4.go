@@ -0,0 +1,406 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+	"github.com/cimnine/netbox-dhcp/resolver"
+	"github.com/cimnine/netbox-dhcp/util"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// bulkLeaseQueryTimeout is how long a bulk leasequery connection may be idle.
+// See https://tools.ietf.org/html/rfc6926#section-8
+const bulkLeaseQueryTimeout = 300 * time.Second
+
+// replyToLeaseQuery answers a DHCPLEASEQUERY of an access concentrator or relay agent.
+// See https://tools.ietf.org/html/rfc4388#section-6
+func (s *ServerV4) replyToLeaseQuery(query *dhcpv4.DHCPv4) {
+	_, xid := s.getTransactionIDAndMAC(query)
+
+	if !s.leaseQuery {
+		log.Printf("Ignoring DHCPLEASEQUERY in transaction '%s', as leasequeries are not enabled.", xid)
+		return
+	}
+
+	// RFC4388, Section 6.1: The server must not answer a DHCPLEASEQUERY with a zero 'giaddr'.
+	if !isRelayed(query) {
+		log.Printf("Ignoring DHCPLEASEQUERY in transaction '%s' without 'giaddr'.", xid)
+		return
+	}
+
+	if !s.isLeaseQueryAllowed(query.GatewayIPAddr()) {
+		log.Printf("Ignoring DHCPLEASEQUERY in transaction '%s' from '%s', which is not allowed to query.", xid, query.GatewayIPAddr())
+		return
+	}
+
+	reply, err := s.answerLeaseQuery(query)
+	if err != nil {
+		log.Printf("Can't answer DHCPLEASEQUERY in transaction '%s': %s", xid, err)
+		return
+	}
+
+	log.Printf("Answering DHCPLEASEQUERY in transaction '%s' with %s to '%s'", xid, reply.MessageType(), query.GatewayIPAddr())
+
+	err = s.sendReply(query, reply, query.GatewayIPAddr(), nil)
+	if err != nil {
+		log.Printf("Can't send the answer to the DHCPLEASEQUERY to '%s': %s", query.GatewayIPAddr(), err)
+	}
+}
+
+// answerLeaseQuery looks up the lease by the IP in 'ciaddr', the client identifier or the MAC in 'chaddr',
+// in this order, and returns a DHCPLEASEACTIVE, DHCPLEASEUNASSIGNED or DHCPLEASEUNKNOWN.
+// See https://tools.ietf.org/html/rfc4388#section-6.4
+func (s *ServerV4) answerLeaseQuery(query *dhcpv4.DHCPv4) (*dhcpv4.DHCPv4, error) {
+	var lease v4.LeaseV4
+	var err error
+
+	ciaddr := query.ClientIPAddr()
+	switch {
+	case ciaddr != nil && !ciaddr.Equal(net.IPv4zero):
+		err = s.Resolver.LeaseQueryV4ByIP(&lease, ciaddr.String())
+	case optionData(query, dhcpv4.OptionClientIdentifier) != nil:
+		err = s.leaseQueryByClientID(&lease, query)
+	case len(v4.ClientHwAddr(query)) > 0:
+		mac, _ := s.getTransactionIDAndMAC(query)
+		err = s.Resolver.LeaseQueryV4ByMAC(&lease, mac)
+	default:
+		return nil, errors.New("the query contains neither an IP, nor a client identifier, nor a MAC")
+	}
+
+	switch err {
+	case nil:
+		return s.prepareLeaseQueryReply(query, v4.MessageTypeLeaseActive, &lease)
+	case resolver.ErrNotLeased:
+		return s.prepareLeaseQueryReply(query, v4.MessageTypeLeaseUnassigned, nil)
+	case resolver.ErrNoRecord:
+		return s.prepareLeaseQueryReply(query, v4.MessageTypeLeaseUnknown, nil)
+	default:
+		return nil, err
+	}
+}
+
+// leaseQueryByClientID looks up the lease by the RFC4361 client identifier,
+// or by comparing the client identifier with the ones of all leases otherwise.
+func (s *ServerV4) leaseQueryByClientID(lease *v4.LeaseV4, query *dhcpv4.DHCPv4) error {
+	if duid, iaid, ok := s.getClientID(query); ok {
+		return s.Resolver.LeaseQueryV4ByID(lease, duid, iaid)
+	}
+
+	clientID := optionData(query, dhcpv4.OptionClientIdentifier)
+
+	found := false
+	err := s.Resolver.ActiveLeasesV4(func(active *v4.LeaseV4) bool {
+		if bytes.Equal(active.Info.Client.ClientID, clientID) {
+			*lease = *active
+			found = true
+		}
+		return !found
+	})
+	if err != nil {
+		return err
+	} else if !found {
+		return resolver.ErrNoRecord
+	}
+
+	return nil
+}
+
+// prepareLeaseQueryReply creates the answer to a leasequery.
+// Only a DHCPLEASEACTIVE describes the lease, the other answers repeat the query.
+func (s *ServerV4) prepareLeaseQueryReply(query *dhcpv4.DHCPv4, messageType dhcpv4.MessageType, lease *v4.LeaseV4) (*dhcpv4.DHCPv4, error) {
+	out, err := dhcpv4.New()
+	if err != nil {
+		log.Print("Can't create response.", err)
+		return nil, err
+	}
+
+	hwAddr := query.ClientHwAddr()
+	out.SetOpcode(dhcpv4.OpcodeBootReply)
+	out.SetHwType(query.HwType())
+	out.SetHwAddrLen(query.HwAddrLen())
+	out.SetHopCount(0)
+	out.SetTransactionID(query.TransactionID())
+	out.SetNumSeconds(0)
+	out.SetClientIPAddr(query.ClientIPAddr())
+	out.SetYourIPAddr(net.IPv4zero)
+	out.SetServerIPAddr(net.IPv4zero)
+	out.SetFlags(query.Flags())
+	out.SetGatewayIPAddr(query.GatewayIPAddr())
+	out.SetClientHwAddr(hwAddr[:])
+
+	options := v4.ReplyOptions{}
+	options.AddRequired(&dhcpv4.OptMessageType{MessageType: messageType})
+	options.AddRequired(&dhcpv4.OptServerIdentifier{ServerID: s.replyFrom})
+
+	if lease != nil {
+		client := lease.Info.Client
+		out.SetClientIPAddr(lease.Info.IPAddr)
+		out.SetHwType(iana.HwTypeType(client.HardwareType))
+		out.SetHwAddrLen(uint8(len(client.HardwareAddr)))
+		out.SetClientHwAddr(client.HardwareAddr)
+
+		options.AddRequired(&dhcpv4.OptIPAddressLeaseTime{LeaseTime: util.SafeConvertToUint32(lease.Remaining.Seconds())})
+		options.AddRequired(uint32Option(v4.OptionClientLastTransactionTime, lease.SinceLastTransaction().Seconds()))
+		// RFC6926, Section 6.2.1: The times of the answer are relative to the base time.
+		options.AddRequired(uint32Option(v4.OptionBaseTime, float64(time.Now().Unix())))
+
+		if len(client.ClientID) > 0 {
+			options.AddRequired(&dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionClientIdentifier, Data: client.ClientID})
+		}
+		if len(lease.Info.IPMask) > 0 {
+			options.Add(&dhcpv4.OptSubnetMask{SubnetMask: lease.Info.IPMask})
+		}
+		// RFC4388, Section 6.4.1: The Relay Agent Information option of the client's last request.
+		if len(client.RelayAgentInformation) > 0 {
+			options.Add(&v4.OptRelayAgentInformation{Data: client.RelayAgentInformation})
+		}
+	}
+
+	s.addOptions(query, out, &options)
+
+	return out, nil
+}
+
+func uint32Option(code dhcpv4.OptionCode, value float64) dhcpv4.Option {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, util.SafeConvertToUint32(value))
+	return &dhcpv4.OptionGeneric{OptionCode: code, Data: data}
+}
+
+// isLeaseQueryAllowed checks whether leasequeries from the IP are answered.
+// Leases reveal the clients' MACs and locations, so nobody is allowed unless networks are configured.
+func (s *ServerV4) isLeaseQueryAllowed(ip net.IP) bool {
+	for _, allowed := range s.leaseQueryAllowed {
+		if allowed.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenBulkLeaseQuery accepts bulk leasequery connections on TCP port 67.
+// See https://tools.ietf.org/html/rfc6926#section-7
+func (s *ServerV4) listenBulkLeaseQuery() {
+	address := net.JoinHostPort(s.replyFrom.String(), strconv.Itoa(dhcpv4.ServerPort))
+
+	listener, err := net.Listen("tcp4", address)
+	if err != nil {
+		log.Printf("Can't listen on '%s' for bulk leasequeries: %s", address, err)
+		return
+	}
+
+	s.bulkListener = listener
+	log.Printf("Listening on '%s' for bulk leasequeries.", address)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if s.shutdown {
+				return
+			}
+			if err != nil {
+				log.Printf("Can't accept bulk leasequery connection: %s", err)
+				continue
+			}
+
+			go s.handleBulkLeaseQueryConn(conn)
+		}
+	}()
+}
+
+// handleBulkLeaseQueryConn answers the bulk leasequeries of the connection until it is closed or idle.
+func (s *ServerV4) handleBulkLeaseQueryConn(conn net.Conn) {
+	defer conn.Close()
+
+	remoteAddr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !s.isLeaseQueryAllowed(remoteAddr.IP) {
+		log.Printf("Closing bulk leasequery connection from '%s', which is not allowed to query.", conn.RemoteAddr())
+		return
+	}
+
+	for {
+		if err := conn.SetDeadline(time.Now().Add(bulkLeaseQueryTimeout)); err != nil {
+			return
+		}
+
+		query, err := readBulkMessage(conn)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			log.Printf("Can't read bulk leasequery from '%s': %s", conn.RemoteAddr(), err)
+			return
+		}
+
+		if query.MessageType() == nil || *query.MessageType() != v4.MessageTypeBulkLeaseQuery {
+			log.Printf("Closing bulk leasequery connection from '%s', which sent a message of type %v.", conn.RemoteAddr(), query.MessageType())
+			return
+		}
+
+		if err := s.answerBulkLeaseQuery(conn, query); err != nil {
+			log.Printf("Can't answer bulk leasequery from '%s': %s", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// answerBulkLeaseQuery answers a DHCPBULKLEASEQUERY with the matching leases, followed by DHCPLEASEQUERYDONE.
+// The query names the client by IP, MAC or client identifier, or the relay agent by its relay-id or remote-id.
+// See https://tools.ietf.org/html/rfc6926#section-7.2
+func (s *ServerV4) answerBulkLeaseQuery(conn net.Conn, query *dhcpv4.DHCPv4) error {
+	_, xid := s.getTransactionIDAndMAC(query)
+
+	var relayID, remoteID []byte
+	if relayAgentInformation := relayAgentInformation(query); relayAgentInformation != nil {
+		relayID = relayAgentInformation.SubOption(v4.RelayAgentRelayID)
+		remoteID = relayAgentInformation.SubOption(v4.RelayAgentRemoteID)
+	}
+
+	ciaddr := query.ClientIPAddr()
+	queries := 0
+	for _, isSet := range []bool{
+		ciaddr != nil && !ciaddr.Equal(net.IPv4zero),
+		len(v4.ClientHwAddr(query)) > 0,
+		optionData(query, dhcpv4.OptionClientIdentifier) != nil,
+		relayID != nil,
+		remoteID != nil,
+	} {
+		if isSet {
+			queries++
+		}
+	}
+
+	if queries != 1 {
+		log.Printf("Malformed bulk leasequery in transaction '%s' with %d queries.", xid, queries)
+		return s.writeLeaseQueryStatus(conn, query, v4.LeaseQueryStatusMalformedQuery, "exactly one query is expected")
+	}
+
+	if relayID == nil && remoteID == nil {
+		reply, err := s.answerLeaseQuery(query)
+		if err != nil {
+			return s.writeLeaseQueryStatus(conn, query, v4.LeaseQueryStatusUnspecFail, err.Error())
+		}
+		if err := writeBulkMessage(conn, reply); err != nil {
+			return err
+		}
+		return s.writeLeaseQueryStatus(conn, query, v4.LeaseQueryStatusSuccess, "")
+	}
+
+	startTime, hasStartTime := uint32OptionValue(query, v4.OptionQueryStartTime)
+	endTime, hasEndTime := uint32OptionValue(query, v4.OptionQueryEndTime)
+
+	var writeErr error
+	count := 0
+	err := s.Resolver.ActiveLeasesV4(func(lease *v4.LeaseV4) bool {
+		leaseInfo := &v4.OptRelayAgentInformation{Data: lease.Info.Client.RelayAgentInformation}
+		if relayID != nil && !bytes.Equal(leaseInfo.SubOption(v4.RelayAgentRelayID), relayID) {
+			return true
+		}
+		if remoteID != nil && !bytes.Equal(leaseInfo.SubOption(v4.RelayAgentRemoteID), remoteID) {
+			return true
+		}
+
+		changed := uint32(time.Now().Add(-lease.SinceLastTransaction()).Unix())
+		if (hasStartTime && changed < startTime) || (hasEndTime && changed > endTime) {
+			return true
+		}
+
+		reply, err := s.prepareLeaseQueryReply(query, v4.MessageTypeLeaseActive, lease)
+		if err != nil {
+			return true
+		}
+
+		writeErr = writeBulkMessage(conn, reply)
+		count++
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		return writeErr
+	} else if err != nil {
+		return s.writeLeaseQueryStatus(conn, query, v4.LeaseQueryStatusUnspecFail, err.Error())
+	}
+
+	log.Printf("Answered bulk leasequery in transaction '%s' with %d leases.", xid, count)
+
+	return s.writeLeaseQueryStatus(conn, query, v4.LeaseQueryStatusSuccess, "")
+}
+
+// writeLeaseQueryStatus ends the answer to a bulk leasequery with DHCPLEASEQUERYDONE if it succeeded,
+// and with DHCPLEASEQUERYSTATUS otherwise.
+// See https://tools.ietf.org/html/rfc6926#section-7.4
+func (s *ServerV4) writeLeaseQueryStatus(conn net.Conn, query *dhcpv4.DHCPv4, status v4.LeaseQueryStatus, message string) error {
+	messageType := v4.MessageTypeLeaseQueryDone
+	if status != v4.LeaseQueryStatusSuccess {
+		messageType = v4.MessageTypeLeaseQueryStatus
+	}
+
+	out, err := dhcpv4.New()
+	if err != nil {
+		return err
+	}
+
+	out.SetOpcode(dhcpv4.OpcodeBootReply)
+	out.SetTransactionID(query.TransactionID())
+	out.AddOption(&dhcpv4.OptMessageType{MessageType: messageType})
+	out.AddOption(&dhcpv4.OptServerIdentifier{ServerID: s.replyFrom})
+	if status != v4.LeaseQueryStatusSuccess {
+		out.AddOption(v4.StatusCodeOption(status, message))
+	}
+
+	return writeBulkMessage(conn, out)
+}
+
+// uint32OptionValue returns the value of an option that holds a 32 bit number.
+func uint32OptionValue(in *dhcpv4.DHCPv4, code dhcpv4.OptionCode) (uint32, bool) {
+	data := optionData(in, code)
+	if len(data) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data), true
+}
+
+// readBulkMessage reads a message, which is preceded by its length on a bulk leasequery connection.
+// See https://tools.ietf.org/html/rfc6926#section-6.1
+func readBulkMessage(conn net.Conn) (*dhcpv4.DHCPv4, error) {
+	message, err := readBulkFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return dhcpv4.FromBytes(message)
+}
+
+func writeBulkMessage(conn net.Conn, message *dhcpv4.DHCPv4) error {
+	_, err := conn.Write(bulkFrame(message.ToBytes()))
+	return err
+}
+
+// readBulkFrame reads the data of a message out of its length-prefixed frame.
+func readBulkFrame(r io.Reader) ([]byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, message); err != nil {
+		return nil, err
+	}
+
+	return message, nil
+}
+
+// bulkFrame prefixes the data of a message with its length.
+func bulkFrame(data []byte) []byte {
+	framed := make([]byte, 2, 2+len(data))
+	binary.BigEndian.PutUint16(framed, uint16(len(data)))
+	return append(framed, data...)
+}
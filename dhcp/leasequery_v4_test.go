@@ -0,0 +1,88 @@
+package dhcp
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIsLeaseQueryAllowed(t *testing.T) {
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+
+	tests := []struct {
+		name    string
+		allowed []*net.IPNet
+		ip      net.IP
+		want    bool
+	}{
+		{name: "allowed", allowed: []*net.IPNet{allowed}, ip: net.IPv4(10, 1, 2, 3), want: true},
+		{name: "other network", allowed: []*net.IPNet{allowed}, ip: net.IPv4(192, 0, 2, 1)},
+		{name: "nothing allowed", ip: net.IPv4(10, 1, 2, 3)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := ServerV4{leaseQueryAllowed: tt.allowed}
+			if got := s.isLeaseQueryAllowed(tt.ip); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkFrame(t *testing.T) {
+	first, second := []byte("first message"), bytes.Repeat([]byte{0xff}, 300)
+
+	stream := bytes.NewReader(append(bulkFrame(first), bulkFrame(second)...))
+	for _, want := range [][]byte{first, second} {
+		got, err := readBulkFrame(stream)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	if _, err := readBulkFrame(stream); err != io.EOF {
+		t.Errorf("got error '%v' at the end of the stream, want EOF", err)
+	}
+
+	truncated := bulkFrame(first)
+	if _, err := readBulkFrame(bytes.NewReader(truncated[:len(truncated)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("got error '%v' for a truncated message, want '%v'", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestBulkLeaseQueryConnNotAllowed(t *testing.T) {
+	listener, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen on the loopback interface: %s", err)
+	}
+	defer listener.Close()
+
+	_, allowed, _ := net.ParseCIDR("10.0.0.0/8")
+	s := ServerV4{bulkLeaseQuery: true, leaseQueryAllowed: []*net.IPNet{allowed}}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			s.handleBulkLeaseQueryConn(conn)
+		}
+	}()
+
+	conn, err := net.Dial("tcp4", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("can't connect: %s", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("can't set the deadline: %s", err)
+	}
+	if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("got error '%v', want the connection of a client that isn't allowed to be closed", err)
+	}
+}
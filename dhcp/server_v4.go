@@ -5,7 +5,10 @@ import (
 	"log"
 	"net"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/cimnine/netbox-dhcp/ddns"
 	"github.com/cimnine/netbox-dhcp/dhcp/config"
 	"github.com/cimnine/netbox-dhcp/dhcp/v4"
 	"github.com/cimnine/netbox-dhcp/resolver"
@@ -22,15 +25,44 @@ type ServerV4 struct {
 	shutdown          bool
 	replyFrom         net.IP
 	replyFromHostname string
+	authoritative     bool
+	probeBeforeOffer  bool
+	probeTimeout      time.Duration
+	bootp             bool
+	rapidCommit       bool
+	forceRenew        bool
+	leaseQuery        bool
+	bulkLeaseQuery    bool
+	leaseQueryAllowed []*net.IPNet
+	minLease          time.Duration
+	maxLease          time.Duration
+	bulkListener      net.Listener
+	vendorOptions     []v4.VendorOptionTemplate
+	dnsUpdater        *ddns.Updater
 }
 
-func NewServerV4(dhcpConfig *config.DHCPConfig, resolver resolver.Resolver, iface net.Interface, listenerConfig *config.V4ListenerConfig) (s ServerV4, err error) {
+// maxOfferAttempts limits how many IPs are probed for a single DHCPDISCOVER.
+const maxOfferAttempts = 3
+
+func NewServerV4(dhcpConfig *config.DHCPConfig, resolver resolver.Resolver, dnsUpdater *ddns.Updater, iface net.Interface, listenerConfig *config.V4ListenerConfig) (s ServerV4, err error) {
 	s = ServerV4{
 		Resolver:          resolver,
+		dnsUpdater:        dnsUpdater,
 		dhcpConfig:        dhcpConfig,
 		iface:             iface,
 		replyFromHostname: listenerConfig.ReplyHostname,
+		authoritative:     listenerConfig.Authoritative,
+		probeBeforeOffer:  listenerConfig.ProbeBeforeOffer,
+		probeTimeout:      listenerConfig.ProbeTimeoutValue(),
+		bootp:             listenerConfig.BOOTP,
+		rapidCommit:       listenerConfig.RapidCommit,
+		forceRenew:        listenerConfig.ForceRenew,
+		leaseQuery:        listenerConfig.LeaseQuery,
+		bulkLeaseQuery:    listenerConfig.BulkLeaseQuery,
+		leaseQueryAllowed: listenerConfig.LeaseQueryAllowedNets(),
+		vendorOptions:     dhcpConfig.VendorOptionTemplates(),
 	}
+	s.minLease, s.maxLease = listenerConfig.LeaseBounds()
 
 	replyFromAddress := listenerConfig.ReplyFromAddress()
 	if net.IPv4zero.Equal(replyFromAddress) || net.IPv4bcast.Equal(replyFromAddress) {
@@ -78,9 +110,15 @@ func NewServerV4(dhcpConfig *config.DHCPConfig, resolver resolver.Resolver, ifac
 }
 
 func (s *ServerV4) Start() {
+	if (s.leaseQuery || s.bulkLeaseQuery) && len(s.leaseQueryAllowed) == 0 {
+		log.Printf("Leasequeries are enabled on iface '%s', but no network is allowed to send them.", s.iface.Name)
+	} else if s.bulkLeaseQuery {
+		s.listenBulkLeaseQuery()
+	}
+
 	log.Printf("Listening on on iface '%s' for DHCPv4 packets.", s.iface.Name)
 	for {
-		dhcpPack, sourceIP, sourceMAC, err := s.conn.ReadFrom()
+		dhcpPack, sourceIP, destinationIP, sourceMAC, err := s.conn.ReadFrom()
 
 		if s.shutdown {
 			break
@@ -91,29 +129,50 @@ func (s *ServerV4) Start() {
 			continue
 		}
 
-		go s.handlePacket(dhcpPack, sourceIP, sourceMAC)
+		go s.handlePacket(dhcpPack, sourceIP, destinationIP, sourceMAC)
 	}
 }
 
 func (s *ServerV4) Stop() {
 	s.shutdown = true
 	_ = s.conn.Close()
+	if s.bulkListener != nil {
+		_ = s.bulkListener.Close()
+	}
 }
 
-func (s *ServerV4) handlePacket(dhcp dhcpv4.DHCPv4, srcIP net.IP, srcMAC net.HardwareAddr) {
+func (s *ServerV4) handlePacket(dhcp dhcpv4.DHCPv4, srcIP, dstIP net.IP, srcMAC net.HardwareAddr) {
+	if lacksClientIdentity(&dhcp) {
+		log.Printf("Ignoring message from hardware type '%v' without client identifier (sourceMAC: %s sourceIP: %s)", dhcp.HwType(), srcMAC, srcIP)
+		return
+	}
+
+	if dhcp.MessageType() == nil {
+		if dhcp.Opcode() != dhcpv4.OpcodeBootRequest {
+			log.Printf("Ignoring BOOTP message with opcode '%v' (sourceMAC: %s sourceIP: %s)", dhcp.Opcode(), srcMAC, srcIP)
+		} else if !s.bootp {
+			log.Printf("Ignoring BOOTREQUEST, as BOOTP is not enabled (sourceMAC: %s sourceIP: %s)", srcMAC, srcIP)
+		} else {
+			s.replyToBootRequest(&dhcp, &srcIP, &srcMAC)
+		}
+		return
+	}
+
 	log.Printf("DHCP message type: %v (sourceMAC: %s sourceIP: %s)", dhcp.MessageType(), srcMAC, srcIP)
 
 	switch *dhcp.MessageType() {
 	case dhcpv4.MessageTypeDiscover:
 		s.replyToDiscover(&dhcp, &srcIP, &srcMAC)
 	case dhcpv4.MessageTypeRequest:
-		s.replyToRequest(&dhcp, &srcIP, &srcMAC)
+		s.replyToRequest(&dhcp, &srcIP, &dstIP, &srcMAC)
 	case dhcpv4.MessageTypeDecline:
 		s.handleDecline(&dhcp, &srcIP, &srcMAC)
 	case dhcpv4.MessageTypeRelease:
 		s.handleRelease(&dhcp, &srcIP, &srcMAC)
 	case dhcpv4.MessageTypeInform:
 		s.replyToInform(&dhcp, &srcIP, &srcMAC)
+	case v4.MessageTypeLeaseQuery:
+		s.replyToLeaseQuery(&dhcp)
 	default:
 		log.Printf("Unknown message type: '%v'", dhcp.MessageType())
 	}
@@ -131,13 +190,32 @@ func (s *ServerV4) handleDecline(dhcpDecline *dhcpv4.DHCPv4, srcIP *net.IP, srcM
 	optRequestedIPAddress, err := dhcpv4.ParseOptRequestedIPAddress(requestedIPOptions[0].ToBytes())
 	if err != nil {
 		log.Printf("Can't decypher the requested IPv4 from '%s'", requestedIPOptions[0].String())
+		return
 	}
 
 	requestedIP := optRequestedIPAddress.RequestedAddr.String()
 
 	log.Printf("DHCPDECLINE from MAC '%s' and IPv4 '%s' in transaction '%s'", mac, requestedIP, xid)
 
-	_ = s.Resolver.DeclineV4ByMAC(xid, mac, requestedIP)
+	// RFC2131, Section 4.3.3: The server MUST check the server identifier, as a DHCPDECLINE is broadcast.
+	serverIdentifier, ok := dhcpDecline.GetOneOption(dhcpv4.OptionServerIdentifier).(*dhcpv4.OptServerIdentifier)
+	if !ok {
+		log.Printf("Can't decypher the server identifier of the DHCPDECLINE in transaction '%s'", xid)
+		return
+	}
+
+	if !serverIdentifier.ServerID.Equal(s.replyFrom) {
+		log.Printf("DHCPDECLINE is not for us but for '%s'.", serverIdentifier.ServerID)
+		return
+	}
+
+	if duid, iaid, ok := s.getClientID(dhcpDecline); ok {
+		_ = s.Resolver.DeclineV4ByID(xid, duid, iaid, requestedIP)
+	} else {
+		_ = s.Resolver.DeclineV4ByMAC(xid, mac, requestedIP)
+	}
+
+	s.removeDNS(optRequestedIPAddress.RequestedAddr)
 }
 
 func (s *ServerV4) handleRelease(dhcpRelease *dhcpv4.DHCPv4, srcIP *net.IP, srcMAC *net.HardwareAddr) {
@@ -157,98 +235,705 @@ func (s *ServerV4) handleRelease(dhcpRelease *dhcpv4.DHCPv4, srcIP *net.IP, srcM
 
 	log.Printf("DHCPRELEASE from MAC '%s' and IPv4 '%s' in transaction '%s'", mac, ip4, xid)
 
-	_ = s.Resolver.ReleaseV4ByMAC(xid, mac, ip4.String())
+	s.release(dhcpRelease, ip4.String())
+	s.removeDNS(ip4)
+}
+
+// release removes the lease of the client by its RFC4361 client identifier, if it sent one, and by its MAC otherwise.
+func (s *ServerV4) release(in *dhcpv4.DHCPv4, ip string) {
+	mac, xid := s.getTransactionIDAndMAC(in)
+
+	if duid, iaid, ok := s.getClientID(in); ok {
+		_ = s.Resolver.ReleaseV4ByID(xid, duid, iaid, ip)
+	} else {
+		_ = s.Resolver.ReleaseV4ByMAC(xid, mac, ip)
+	}
 }
 
 func (s *ServerV4) replyToInform(dhcpInform *dhcpv4.DHCPv4, srcIP *net.IP, srcMAC *net.HardwareAddr) {
-	mac := dhcpInform.ClientHwAddrToString()
-	log.Printf("DHCPINFORM for MAC '%s'", mac)
+	mac, xid := s.getTransactionIDAndMAC(dhcpInform)
+
+	ciaddr := dhcpInform.ClientIPAddr().To4()
+	if ciaddr == nil || net.IPv4zero.Equal(ciaddr) || net.IPv4bcast.Equal(ciaddr) {
+		log.Printf("DHCPINFORM from MAC '%s' with invalid client IPv4 '%s'", mac, dhcpInform.ClientIPAddr())
+		return
+	}
+
+	log.Printf("DHCPINFORM from MAC '%s' and IPv4 '%s' in transaction '%s'", mac, ciaddr, xid)
+
+	clientInfo := s.newClientInfo()
+
+	err := s.Resolver.InformV4ByIP(clientInfo, xid, ciaddr.String())
+	if err != nil {
+		log.Printf("No Device found for IPv4 '%s', answering with the default options: %s", ciaddr, err)
+	}
+
+	// According to RFC2131, Section 4.3.5, the DHCPACK to a DHCPINFORM
+	// must not contain a 'yiaddr' nor any lease time parameters.
+	clientInfo.IPAddr = net.IPv4zero
+	clientInfo.Timeouts.Lease = 0
+	clientInfo.Timeouts.T1RenewalTime = 0
+	clientInfo.Timeouts.T2RebindingTime = 0
+
+	dhcpACK, err := s.prepareAnswer(dhcpInform, clientInfo, dhcpv4.MessageTypeAck)
+	if err != nil {
+		return
+	}
+
+	dhcpACK.SetClientIPAddr(ciaddr)
 
-	// TODO implement
+	dstIP, dstMAC := ciaddr, s.clientMAC(ciaddr, *srcMAC)
+
+	log.Printf("Sending DHCPACK to '%s' ('%s') from '%s'", dstIP, dstMAC, s.replyFrom)
+
+	err = s.sendReply(dhcpInform, dhcpACK, dstIP, dstMAC)
+	if err != nil {
+		log.Printf("Can't send DHCPACK to '%s' ('%s'): %s", dstIP, dstMAC, err)
+	}
 }
 
 func (s *ServerV4) replyToDiscover(dhcpDiscover *dhcpv4.DHCPv4, srcIP *net.IP, srcMAC *net.HardwareAddr) {
 	mac, xid := s.getTransactionIDAndMAC(dhcpDiscover)
 	log.Printf("DHCPDISCOVER for MAC '%s' in transaction '%s'", mac, xid)
 
-	clientInfo := resolver.NewClientInfoV4(s.dhcpConfig)
+	requestInfo := s.requestInfo(dhcpDiscover)
+
+	if relayAgentInfo := requestInfo.RelayAgentInfo; relayAgentInfo != nil {
+		log.Printf("DHCPDISCOVER for MAC '%s' relayed with circuit ID '%x' and remote ID '%x'",
+			mac, relayAgentInfo.CircuitID, relayAgentInfo.RemoteID)
+	}
+
+	// RFC4039, Section 4: A DHCPDISCOVER with the Rapid Commit option is answered with a DHCPACK right away.
+	rapidCommit := s.rapidCommit && dhcpDiscover.GetOneOption(dhcpv4.OptionRapidCommit) != nil
+	allocate, messageType := s.offer, dhcpv4.MessageTypeOffer
+	if rapidCommit {
+		log.Printf("DHCPDISCOVER for MAC '%s' requests a rapid commit.", mac)
+		allocate, messageType = s.commit, dhcpv4.MessageTypeAck
+	}
 
-	err := s.Resolver.OfferV4ByMAC(clientInfo, xid, mac)
+	var clientInfo *v4.ClientInfoV4
+	for attempt := 1; ; attempt++ {
+		clientInfo = s.newClientInfo()
+
+		err := allocate(dhcpDiscover, clientInfo, requestInfo)
+		if err != nil {
+			log.Printf("Error finding IPv4 for MAC '%s': %s", mac, err)
+			return
+		}
+
+		if !s.isOnLink(dhcpDiscover, clientInfo) {
+			log.Printf("The IPv4 '%s' for MAC '%s' is not in the subnet of the relay agent '%s'. Not offering it.",
+				clientInfo.IPAddr, mac, s.linkAddress(dhcpDiscover))
+			if rapidCommit {
+				s.release(dhcpDiscover, clientInfo.IPAddr.String())
+			}
+			return
+		}
+
+		if !s.probeBeforeOffer || !s.isInUse(dhcpDiscover, clientInfo.IPAddr) {
+			break
+		}
+
+		// The quarantined IP is never offered again, so the next attempt yields another IP, if there is one.
+		log.Printf("The IPv4 '%s' for MAC '%s' is already in use by another host. Not offering it.", clientInfo.IPAddr, mac)
+		_ = s.Resolver.QuarantineV4(clientInfo.IPAddr.String(), s.dhcpConfig.QuarantineDurationValue(), "in use by another host")
+		if rapidCommit {
+			s.release(dhcpDiscover, clientInfo.IPAddr.String())
+		}
+
+		if attempt == maxOfferAttempts {
+			log.Printf("Giving up to find an unused IPv4 for MAC '%s' after %d attempts.", mac, attempt)
+			return
+		}
+	}
+
+	dhcpOffer, err := s.prepareAnswer(dhcpDiscover, clientInfo, messageType)
+	if err != nil {
+		return
+	}
+
+	dstIP, dstMAC := s.determineDstAddr(dhcpDiscover, dhcpOffer, srcMAC)
+
+	log.Printf("Sending DHCP%s to '%s' ('%s') from '%s'", messageType, dstIP.String(), dstMAC, s.replyFrom)
+
+	err = s.sendReply(dhcpDiscover, dhcpOffer, dstIP, dstMAC)
+	if err != nil {
+		log.Printf("Can't send DHCP%s to '%s' ('%s'): %s", messageType, dstIP.String(), srcMAC, err)
+		return
+	}
+
+	if rapidCommit {
+		s.updateDNS(dhcpDiscover, clientInfo)
+	}
+}
+
+// replyToBootRequest answers a BOOTREQUEST of a client that does not speak DHCP.
+// The client gets the same IP and options as a DHCP client would, but the lease never expires,
+// as BOOTP has no way to renew or release it.
+// See https://tools.ietf.org/html/rfc951 and https://tools.ietf.org/html/rfc1542
+func (s *ServerV4) replyToBootRequest(bootRequest *dhcpv4.DHCPv4, srcIP *net.IP, srcMAC *net.HardwareAddr) {
+	mac, xid := s.getTransactionIDAndMAC(bootRequest)
+	log.Printf("BOOTREQUEST for MAC '%s' in transaction '%s'", mac, xid)
+
+	// RFC951, Section 7.1: The client may ask for a particular server by its name.
+	if serverName := strings.TrimRight(bootRequest.ServerHostNameToString(), "\x00"); serverName != "" && serverName != s.replyFromHostname {
+		log.Printf("BOOTREQUEST is not for us but for '%s'.", serverName)
+		return
+	}
+
+	requestInfo := s.requestInfo(bootRequest)
+	requestInfo.BOOTP = true
+
+	clientInfo := s.newClientInfo()
+
+	// RFC951 servers remain silent if they don't know the client.
+	err := s.offer(bootRequest, clientInfo, requestInfo)
 	if err != nil {
 		log.Printf("Error finding IPv4 for MAC '%s': %s", mac, err)
 		return
 	}
 
-	dhcpOffer, err := s.prepareAnswer(dhcpDiscover, clientInfo, dhcpv4.MessageTypeOffer)
+	if !s.isOnLink(bootRequest, clientInfo) {
+		log.Printf("The IPv4 '%s' for MAC '%s' is not in the subnet of the relay agent '%s'. Not answering.",
+			clientInfo.IPAddr, mac, s.linkAddress(bootRequest))
+		return
+	}
+
+	// There's no DHCPREQUEST in BOOTP, so the offer becomes the lease right away.
+	err = s.acknowledge(bootRequest, clientInfo, clientInfo.IPAddr.String())
 	if err != nil {
+		log.Printf("Error leasing IPv4 '%s' to MAC '%s': %s", clientInfo.IPAddr, mac, err)
 		return
 	}
 
-	dstIP, dstMAC := s.determineDstAddr(dhcpDiscover, dhcpOffer, srcMAC)
+	bootReply, err := s.prepareBootReply(bootRequest, clientInfo)
+	if err != nil {
+		return
+	}
+
+	dstIP, dstMAC := s.determineDstAddr(bootRequest, bootReply, srcMAC)
 
-	log.Printf("Sending DHCPOFFER to '%s' ('%s') from '%s'", dstIP.String(), srcMAC, s.replyFrom)
+	log.Printf("Sending BOOTREPLY to '%s' ('%s') from '%s'", dstIP, dstMAC, s.replyFrom)
 
-	err = s.conn.WriteTo(*dhcpOffer, dstIP, dstMAC)
+	err = s.sendReply(bootRequest, bootReply, dstIP, dstMAC)
 	if err != nil {
-		log.Printf("Can't send DHCPOFFER to '%s' ('%s'): %s", dstIP.String(), srcMAC, err)
+		log.Printf("Can't send BOOTREPLY to '%s' ('%s'): %s", dstIP, dstMAC, err)
+		return
 	}
 
-	return
+	s.updateDNS(bootRequest, clientInfo)
 }
 
-func (s *ServerV4) replyToRequest(dhcpRequest *dhcpv4.DHCPv4, srcIP *net.IP, srcMAC *net.HardwareAddr) {
+func (s *ServerV4) replyToRequest(dhcpRequest *dhcpv4.DHCPv4, srcIP *net.IP, requestDstIP *net.IP, srcMAC *net.HardwareAddr) {
 	mac, xid := s.getTransactionIDAndMAC(dhcpRequest)
+	state := v4.DetermineRequestState(dhcpRequest, *requestDstIP)
+
+	var requestedIP net.IP
+	switch state {
+	case v4.RequestStateSelecting, v4.RequestStateInitReboot:
+		requestedIPOptions := dhcpRequest.GetOption(dhcpv4.OptionRequestedIPAddress)
+		if len(requestedIPOptions) != 1 {
+			log.Printf("%d IPv4s requested instead of one", len(requestedIPOptions))
+			return
+		}
 
-	requestedIPOptions := dhcpRequest.GetOption(dhcpv4.OptionRequestedIPAddress)
-	if len(requestedIPOptions) != 1 {
-		log.Printf("%d IPv4s requested instead of one", len(requestedIPOptions))
+		optRequestedIPAddress, err := dhcpv4.ParseOptRequestedIPAddress(requestedIPOptions[0].ToBytes())
+		if err != nil {
+			log.Printf("Can't decypher the requested IPv4 from '%s'", requestedIPOptions[0].String())
+			return
+		}
+
+		requestedIP = optRequestedIPAddress.RequestedAddr
+	case v4.RequestStateRenewing, v4.RequestStateRebinding:
+		requestedIP = dhcpRequest.ClientIPAddr()
+	}
+
+	log.Printf("DHCPREQUEST in state %s requesting IPv4 '%s' for MAC '%s' and transaction '%s'", state, requestedIP, mac, xid)
+
+	if state == v4.RequestStateSelecting {
+		serverIdentifier, ok := dhcpRequest.GetOneOption(dhcpv4.OptionServerIdentifier).(*dhcpv4.OptServerIdentifier)
+		if !ok {
+			log.Printf("Can't decypher the server identifier of the DHCPREQUEST in transaction '%s'", xid)
+			return
+		}
+
+		if !serverIdentifier.ServerID.Equal(s.replyFrom) {
+			log.Printf("DHCPREQUEST is not for us but for '%s'.", serverIdentifier.ServerID)
+			return
+		}
+	}
+
+	clientInfo := s.newClientInfo()
+
+	err := s.acknowledge(dhcpRequest, clientInfo, requestedIP.String())
+	if err != nil {
+		if message := nakMessage(err, state, s.authoritative); message != "" {
+			log.Printf("Rejecting IPv4 '%s' for MAC '%s' in state %s: %s", requestedIP, mac, state, err)
+			s.sendNak(dhcpRequest, srcMAC, message)
+		} else {
+			log.Printf("Can't acknowledge IPv4 '%s' for MAC '%s' in state %s. Remaining silent: %s", requestedIP, mac, state, err)
+		}
 		return
 	}
 
-	optRequestedIPAddress, err := dhcpv4.ParseOptRequestedIPAddress(requestedIPOptions[0].ToBytes())
+	if !s.isOnLink(dhcpRequest, clientInfo) {
+		log.Printf("The IPv4 '%s' for MAC '%s' is not in the subnet of the relay agent '%s'.",
+			clientInfo.IPAddr, mac, s.linkAddress(dhcpRequest))
+		s.sendNak(dhcpRequest, srcMAC, nakMessageWrongNetwork)
+		return
+	}
+
+	dhcpACK, err := s.prepareAnswer(dhcpRequest, clientInfo, dhcpv4.MessageTypeAck)
 	if err != nil {
-		log.Printf("Can't decypher the requested IPv4 from '%s'", requestedIPOptions[0].String())
+		return
 	}
 
-	requestedIP := optRequestedIPAddress.RequestedAddr.String()
-	log.Printf("DHCPREQUEST requesting IPv4 '%s' for MAC '%s' and transaction '%s'", requestedIP, mac, xid)
-
-	serverIPAddr := dhcpRequest.ServerIPAddr()
-	if serverIPAddr != nil &&
-		!serverIPAddr.Equal(net.IPv4zero) &&
-		!serverIPAddr.Equal(net.IPv4bcast) &&
-		!serverIPAddr.Equal(s.replyFrom) {
-		log.Printf("DHCPREQUEST is not for us but for '%s'.", serverIPAddr.String())
+	dstIP, dstMAC := s.determineDstAddr(dhcpRequest, dhcpACK, srcMAC)
+
+	log.Printf("Sending DHCPACK to '%s' from '%s'", dstIP.String(), s.replyFrom)
+
+	err = s.sendReply(dhcpRequest, dhcpACK, dstIP, dstMAC)
+	if err != nil {
+		log.Printf("Can't send DHCPACK to '%s' ('%s'): %s", dstIP.String(), srcMAC, err)
 		return
 	}
 
+	s.updateDNS(dhcpRequest, clientInfo)
+}
+
+// The messages of DHCPNAKs (option 56), which don't reveal the internal reason to the client.
+const (
+	nakMessageAddressMismatch = "requested address not available"
+	nakMessageNoRecord        = "no lease for this client"
+	nakMessageWrongNetwork    = "wrong network"
+)
+
+// nakMessage returns the message of the DHCPNAK for a DHCPREQUEST that could not be acknowledged,
+// or an empty string if the server remains silent.
+// See https://tools.ietf.org/html/rfc2131#section-4.3.2
+func nakMessage(err error, state v4.RequestState, authoritative bool) string {
+	switch {
+	case err == resolver.ErrAddressMismatch:
+		return nakMessageAddressMismatch
+	case err == resolver.ErrNoRecord && (state == v4.RequestStateSelecting || authoritative):
+		// The selected server, or a server that is authoritative for the network,
+		// rejects a request it can't satisfy, e.g. because the offer expired.
+		return nakMessageNoRecord
+	default:
+		// If the DHCP server has no record of this client, then it MUST remain silent.
+		// (Unless it is authoritative for the network.)
+		// On other errors, the client retransmits its request and keeps its lease if the cache or the source recover in time.
+		return ""
+	}
+}
+
+// sendNak rejects a DHCPREQUEST.
+// See https://tools.ietf.org/html/rfc2131#section-4.3.2
+func (s *ServerV4) sendNak(dhcpRequest *dhcpv4.DHCPv4, srcMAC *net.HardwareAddr, message string) {
+	mac, xid := s.getTransactionIDAndMAC(dhcpRequest)
+
+	dhcpNAK, err := s.prepareNak(dhcpRequest, message)
+	if err != nil {
+		return
+	}
+
+	dstIP, dstMAC := s.determineNakDstAddr(dhcpRequest, dhcpNAK, srcMAC)
+
+	log.Printf("Sending DHCPNAK for MAC '%s' in transaction '%s' to '%s' from '%s'", mac, xid, dstIP, s.replyFrom)
+
+	err = s.sendReply(dhcpRequest, dhcpNAK, dstIP, dstMAC)
+	if err != nil {
+		log.Printf("Can't send DHCPNAK to '%s' ('%s'): %s", dstIP, dstMAC, err)
+	}
+}
+
+// sendReply sends the reply to the relay agent, if the request was relayed.
+// Otherwise it is sent to the given destination directly.
+func (s *ServerV4) sendReply(in *dhcpv4.DHCPv4, out *dhcpv4.DHCPv4, dstIP net.IP, dstMAC net.HardwareAddr) error {
+	if isRelayed(in) {
+		return s.conn.WriteToRelay(*out, in.GatewayIPAddr())
+	}
+
+	return s.conn.WriteTo(*out, dstIP, dstMAC)
+}
+
+// isRelayed returns true if the message was forwarded by a relay agent, i.e. if 'giaddr' is non-zero.
+func isRelayed(in *dhcpv4.DHCPv4) bool {
+	return in.GatewayIPAddr() != nil && !in.GatewayIPAddr().Equal(net.IPv4zero)
+}
+
+// newClientInfo returns the info with the defaults of the configuration,
+// bounding the lease time by the bounds of the listener, if it has any.
+func (s *ServerV4) newClientInfo() *v4.ClientInfoV4 {
 	clientInfo := resolver.NewClientInfoV4(s.dhcpConfig)
+	if s.minLease > 0 {
+		clientInfo.Timeouts.MinLease = s.minLease
+	}
+	if s.maxLease > 0 {
+		clientInfo.Timeouts.MaxLease = s.maxLease
+	}
+
+	return clientInfo
+}
+
+// requestInfo collects the details of the request that are relevant for the resolver.
+func (s *ServerV4) requestInfo(in *dhcpv4.DHCPv4) *v4.RequestInfoV4 {
+	requestInfo := v4.RequestInfoV4{
+		LinkAddress: s.linkAddress(in),
+		MAC:         v4.ClientHwAddr(in).String(),
+	}
+
+	if requestInfo.LinkAddress == nil {
+		requestInfo.LinkAddress = s.replyFrom
+	}
+
+	if relayAgentInformation := relayAgentInformation(in); relayAgentInformation != nil {
+		requestInfo.RelayAgentInfo = relayAgentInformation.RelayAgentInfo()
+	}
+
+	requestInfo.Architectures = v4.ParseArchitectures(optionData(in, dhcpv4.OptionClientSystemArchitectureType))
+	requestInfo.VendorClass = string(optionData(in, dhcpv4.OptionClassIdentifier))
+	requestInfo.UserClasses = v4.ParseUserClasses(optionData(in, dhcpv4.OptionUserClassInformation))
+	requestInfo.VendorEnterprises = v4.ParseVendorEnterprises(optionData(in, v4.OptionVendorIdentifyingVendorClass))
+
+	if leaseTime, ok := uint32OptionValue(in, dhcpv4.OptionIPAddressLeaseTime); ok {
+		requestInfo.RequestedLeaseTime = time.Duration(leaseTime) * time.Second
+	}
+
+	return &requestInfo
+}
+
+// optionData returns the data of the option with the given code, or nil if the message has no such option.
+func optionData(in *dhcpv4.DHCPv4, code dhcpv4.OptionCode) []byte {
+	opt := in.GetOneOption(code)
+	if opt == nil {
+		return nil
+	}
+
+	data := opt.ToBytes()
+	if len(data) < 2 {
+		return nil
+	}
+
+	return data[2:]
+}
+
+// selectBootEntry chooses the boot file and the next server of network booting clients
+// according to their architecture out of the client's boot map.
+func (s *ServerV4) selectBootEntry(in *dhcpv4.DHCPv4, requestInfo *v4.RequestInfoV4, clientInfo *v4.ClientInfoV4) {
+	if len(clientInfo.BootMap) == 0 || !requestInfo.IsNetworkBoot() {
+		return
+	}
+
+	mac, xid := s.getTransactionIDAndMAC(in)
+
+	entry, key, ok := v4.SelectBootEntry(clientInfo.BootMap, requestInfo)
+	if !ok {
+		log.Printf("No boot entry for MAC '%s' with architectures %v in transaction '%s'.", mac, requestInfo.Architectures, xid)
+		return
+	}
+
+	log.Printf("Booting MAC '%s' with the boot entry '%s' in transaction '%s'.", mac, key, xid)
+
+	if entry.BootFileName != "" {
+		clientInfo.BootFileName = entry.BootFileName
+	}
+	if entry.NextServer != nil {
+		clientInfo.NextServer = entry.NextServer
+	}
+}
+
+// dnsRecord decides which DNS records are updated for the lease of the client, following the flags of
+// its Client FQDN option, and returns the Client FQDN option of the reply, if the client sent one.
+// The records are named after the client's host name and domain name, i.e. the name of the Device in Netbox.
+// See https://tools.ietf.org/html/rfc4702#section-4
+func (s *ServerV4) dnsRecord(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4) (*ddns.Record, *v4.OptClientFQDN) {
+	if s.dnsUpdater == nil || clientInfo.Timeouts.Lease == 0 {
+		return nil, nil
+	}
+
+	fqdn := clientInfo.Options.HostName
+	if fqdn == "" {
+		return nil, nil
+	} else if !strings.Contains(fqdn, ".") {
+		if clientInfo.Options.DomainName == "" {
+			return nil, nil
+		}
+		fqdn += "." + clientInfo.Options.DomainName
+	}
+
+	record := ddns.Record{FQDN: fqdn, IP: clientInfo.IPAddr}
+
+	optClientFQDN := clientFQDN(in)
+	if optClientFQDN == nil {
+		if !s.dnsUpdater.Config.UpdateWithoutFQDN {
+			return nil, nil
+		}
+
+		record.Forward, record.Reverse = true, true
+		return &record, nil
+	}
+
+	// The reply uses the same encoding as the client.
+	reply := &v4.OptClientFQDN{Flags: optClientFQDN.Flags & v4.FQDNFlagE, DomainName: fqdn}
+
+	switch {
+	case optClientFQDN.Flags&v4.FQDNFlagN != 0:
+		reply.Flags |= v4.FQDNFlagN
+		return nil, reply
+	case optClientFQDN.Flags&v4.FQDNFlagS != 0:
+		record.Forward, record.Reverse = true, true
+		reply.Flags |= v4.FQDNFlagS
+	case s.dnsUpdater.Config.OverrideClientUpdates:
+		record.Forward, record.Reverse = true, true
+		reply.Flags |= v4.FQDNFlagS | v4.FQDNFlagO
+	default:
+		record.Reverse = true
+	}
+
+	return &record, reply
+}
 
-	err = s.Resolver.AcknowledgeV4ByMAC(clientInfo, xid, mac, requestedIP)
+// updateDNS adds the DNS records of the acknowledged lease in the background.
+func (s *ServerV4) updateDNS(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4) {
+	record, _ := s.dnsRecord(in, clientInfo)
+	if record == nil {
+		return
+	}
+
+	lease := clientInfo.Timeouts.Lease
+	go func() {
+		if err := s.dnsUpdater.Register(*record, lease); err != nil {
+			log.Printf("Can't update the DNS records of '%s' ('%s'): %s", record.IP, record.FQDN, err)
+		}
+	}()
+}
+
+// removeDNS removes the DNS records of the lease of the IP in the background.
+func (s *ServerV4) removeDNS(ip net.IP) {
+	if s.dnsUpdater == nil {
+		return
+	}
+
+	go func() {
+		if err := s.dnsUpdater.Unregister(ip); err != nil {
+			log.Printf("Can't remove the DNS records of '%s': %s", ip, err)
+		}
+	}()
+}
+
+// clientFQDN returns the Client FQDN option of the message,
+// or nil if there is none or it could not be parsed.
+func clientFQDN(in *dhcpv4.DHCPv4) *v4.OptClientFQDN {
+	opt := in.GetOneOption(v4.OptionClientFQDN)
+	if opt == nil {
+		return nil
+	}
+
+	optClientFQDN, err := v4.ParseOptClientFQDN(opt.ToBytes())
 	if err != nil {
-		log.Printf("Error finding IPv4 for MAC '%s': %s", mac, err)
+		log.Printf("Can't decypher the Client FQDN option '%s': %s", opt.String(), err)
+		return nil
+	}
+
+	return optClientFQDN
+}
+
+// relayAgentInformation returns the Relay Agent Information option of the message,
+// or nil if there is none or it could not be parsed.
+func relayAgentInformation(in *dhcpv4.DHCPv4) *v4.OptRelayAgentInformation {
+	opt := in.GetOneOption(dhcpv4.OptionRelayAgentInformation)
+	if opt == nil {
+		return nil
+	}
+
+	relayAgentInformation, err := v4.ParseOptRelayAgentInformation(opt.ToBytes())
+	if err != nil {
+		log.Printf("Can't decypher the Relay Agent Information option '%s': %s", opt.String(), err)
+		return nil
+	}
+
+	return relayAgentInformation
+}
+
+// linkAddress returns the address that selects the subnet the client is on,
+// or nil, if the client is on the same link as this server.
+// A link selection sub-option takes precedence over the 'giaddr'.
+// See https://tools.ietf.org/html/rfc3527#section-2
+func (s *ServerV4) linkAddress(in *dhcpv4.DHCPv4) net.IP {
+	if !isRelayed(in) {
+		return nil
+	}
+
+	if relayAgentInformation := relayAgentInformation(in); relayAgentInformation != nil {
+		if linkSelection := relayAgentInformation.RelayAgentInfo().LinkSelection; linkSelection != nil {
+			return linkSelection
+		}
+	}
+
+	return in.GatewayIPAddr()
+}
+
+// isOnLink checks whether the IPv4 designated for the client is in the subnet selected by the link address.
+// The IPv4 of clients on the same link as this server is not checked.
+func (s *ServerV4) isOnLink(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4) bool {
+	linkAddress := s.linkAddress(in)
+	if linkAddress == nil {
+		return true
+	}
+
+	subnet := net.IPNet{IP: clientInfo.IPAddr.Mask(clientInfo.IPMask), Mask: clientInfo.IPMask}
+	return subnet.Contains(linkAddress)
+}
+
+// isInUse probes whether another host already uses the IP.
+// Clients on the same link as this server are probed with ARP, relayed clients with ICMP echo.
+// If the probe fails, the IP is assumed to be unused.
+func (s *ServerV4) isInUse(in *dhcpv4.DHCPv4, ip net.IP) bool {
+	var inUse bool
+	var err error
+	if isRelayed(in) {
+		inUse, err = v4.ProbeICMP(ip, s.probeTimeout)
+	} else {
+		inUse, err = v4.ProbeARP(s.iface, ip, s.probeTimeout)
+	}
+
+	if err != nil {
+		log.Printf("Can't probe IPv4 '%s', assuming it's unused: %s", ip, err)
+		return false
+	}
+
+	return inUse
+}
+
+// offer looks up the client by its RFC4361 client identifier, if it sent one, and by its MAC otherwise.
+// If the lookup by the client identifier fails, the resolver tries the MAC as well.
+func (s *ServerV4) offer(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4) error {
+	mac, xid := s.getTransactionIDAndMAC(in)
+
+	setClient(in, clientInfo)
+	s.negotiateForceRenew(in, clientInfo)
+
+	if duid, iaid, ok := s.getClientID(in); ok {
+		return s.Resolver.OfferV4ByID(clientInfo, requestInfo, xid, duid, iaid)
+	}
+
+	return s.Resolver.OfferV4ByMAC(clientInfo, requestInfo, xid, mac)
+}
+
+// commit looks up the client like offer, but leases the IP right away.
+// See https://tools.ietf.org/html/rfc4039#section-4
+func (s *ServerV4) commit(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4) error {
+	mac, xid := s.getTransactionIDAndMAC(in)
+
+	setClient(in, clientInfo)
+	s.negotiateForceRenew(in, clientInfo)
+
+	if duid, iaid, ok := s.getClientID(in); ok {
+		return s.Resolver.CommitV4ByID(clientInfo, requestInfo, xid, duid, iaid)
+	}
+
+	return s.Resolver.CommitV4ByMAC(clientInfo, requestInfo, xid, mac)
+}
+
+// setClient remembers who the client is, so that leasequeries can be answered.
+func setClient(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4) {
+	clientInfo.Client.HardwareType = uint8(in.HwType())
+	clientInfo.Client.HardwareAddr = v4.ClientHwAddr(in)
+	clientInfo.Client.ClientID = optionData(in, dhcpv4.OptionClientIdentifier)
+	clientInfo.Client.RelayAgentInformation = optionData(in, dhcpv4.OptionRelayAgentInformation)
+}
+
+// negotiateForceRenew remembers this server as the one that hands out the IP,
+// and creates a nonce if the client supports the Forcerenew Nonce Authentication.
+// The nonce is stored with the offer and sent in the DHCPACK.
+// See https://tools.ietf.org/html/rfc6704#section-3.1
+func (s *ServerV4) negotiateForceRenew(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4) {
+	clientInfo.Client.ServerID = s.replyFrom
+
+	if !s.forceRenew || !v4.IsForceRenewNonceCapable(optionData(in, v4.OptionForcerenewNonceCapable)) {
 		return
 	}
 
-	dhcpACK, err := s.prepareAnswer(dhcpRequest, clientInfo, dhcpv4.MessageTypeAck)
+	nonce, err := v4.NewForceRenewNonce()
 	if err != nil {
+		log.Printf("Can't create a nonce for DHCPFORCERENEW: %s", err)
 		return
 	}
 
-	dstIP, dstMAC := s.determineDstAddr(dhcpRequest, dhcpACK, srcMAC)
+	clientInfo.Client.ForceRenewNonce = nonce
+}
 
-	log.Printf("Sending DHCPACK to '%s' from '%s'", dstIP.String(), s.replyFrom)
+// acknowledge acknowledges the lease by the client's RFC4361 client identifier, if it sent one, and by its MAC otherwise.
+// The lease time is the one the client requested, if it requested one.
+func (s *ServerV4) acknowledge(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4, ip string) error {
+	mac, xid := s.getTransactionIDAndMAC(in)
+	requestInfo := s.requestInfo(in)
+
+	if duid, iaid, ok := s.getClientID(in); ok {
+		return s.Resolver.AcknowledgeV4ByID(clientInfo, requestInfo, xid, duid, iaid, ip)
+	}
+
+	return s.Resolver.AcknowledgeV4ByMAC(clientInfo, requestInfo, xid, mac, ip)
+}
 
-	err = s.conn.WriteTo(*dhcpACK, dstIP, dstMAC)
+// getClientID returns the DUID and the IAID of the client, if it sent a client identifier according to RFC4361.
+func (s *ServerV4) getClientID(dhcpMsg *dhcpv4.DHCPv4) (string, string, bool) {
+	return clientID(clientIdentifier(dhcpMsg))
+}
+
+// clientID returns the DUID and the IAID of the client identifier, if it is one according to RFC4361.
+func clientID(optClientIdentifier *v4.OptClientIdentifier) (string, string, bool) {
+	if optClientIdentifier == nil || !optClientIdentifier.IsIAIDDUID() {
+		return "", "", false
+	}
+
+	duid, err := parseClientDUID(optClientIdentifier.DUID())
 	if err != nil {
-		log.Printf("Can't send DHCPOFFER to '%s' ('%s'): %s", dstIP.String(), srcMAC, err)
+		log.Printf("WARN: The client's DUID was not correctly parsed: %s", err)
 	}
 
-	return
+	return duid, optClientIdentifier.IAID(), true
+}
+
+// lacksClientIdentity returns true if the client can't be told apart from others, because the message
+// carries neither a hardware address nor a client identifier according to RFC4361.
+// This is the case for InfiniBand clients, which must send such a client identifier.
+// DHCPINFORMs and leasequeries are answered by IP and therefore need neither.
+// See https://tools.ietf.org/html/rfc4390#section-2.1
+func lacksClientIdentity(in *dhcpv4.DHCPv4) bool {
+	if messageType := in.MessageType(); messageType != nil &&
+		(*messageType == dhcpv4.MessageTypeInform || *messageType == v4.MessageTypeLeaseQuery) {
+		return false
+	}
+
+	if len(v4.ClientHwAddr(in)) > 0 {
+		return false
+	}
+
+	optClientIdentifier := clientIdentifier(in)
+	return optClientIdentifier == nil || !optClientIdentifier.IsIAIDDUID()
+}
+
+// clientIdentifier returns the Client-identifier option of the message,
+// or nil if there is none or it could not be parsed.
+func clientIdentifier(in *dhcpv4.DHCPv4) *v4.OptClientIdentifier {
+	opt := in.GetOneOption(dhcpv4.OptionClientIdentifier)
+	if opt == nil {
+		return nil
+	}
+
+	optClientIdentifier, err := v4.ParseOptClientIdentifier(opt.ToBytes())
+	if err != nil {
+		log.Printf("Can't decypher the Client-identifier option '%s': %s", opt.String(), err)
+		return nil
+	}
+
+	return optClientIdentifier
 }
 
 func (s *ServerV4) getTransactionIDAndMAC(dhcpMsg *dhcpv4.DHCPv4) (string, string) {
-	mac := dhcpMsg.ClientHwAddrToString()
+	mac := v4.ClientHwAddr(dhcpMsg).String()
 	xid := strconv.FormatUint(uint64(dhcpMsg.TransactionID()), 16)
 	return mac, xid
 }
@@ -271,33 +956,170 @@ func (s *ServerV4) determineDstAddr(in *dhcpv4.DHCPv4, out *dhcpv4.DHCPv4, srcMA
 		   messages to 0xffffffff.
 	*/
 
-	if in.GatewayIPAddr() != nil && // 'giaddr' is non-zero
-		!in.GatewayIPAddr().Equal(net.IPv4zero) {
+	// RFC4390, Section 2.2: Clients without an Ethernet address in 'chaddr', like the ones on InfiniBand,
+	// can't be reached before they have an IP. The broadcast bit tells relay agents to broadcast as well.
+	if !v4.CanUnicastToHwAddr(in) {
 		out.SetBroadcast()
+	}
 
-		// TODO srcMAC should be resolved using ARP
-		return in.GatewayIPAddr(), *srcMAC
+	if isRelayed(in) { // 'giaddr' is non-zero
+		// The reply is routed by the kernel, see sendReply()
+		return in.GatewayIPAddr(), nil
 	} else if in.ClientIPAddr() != nil && // 'giaddr' is zero, 'ciaddr' is non-zero
 		!in.ClientIPAddr().Equal(net.IPv4zero) {
-
-		// TODO srcMAC should be resolved using ARP
-		return in.ClientIPAddr(), *srcMAC
-	} else if in.IsBroadcast() { // 'giaddr' and 'ciaddr' are zero, but broadcast flag
+		return in.ClientIPAddr(), s.clientMAC(in.ClientIPAddr(), *srcMAC)
+	} else if out.IsBroadcast() { // 'giaddr' and 'ciaddr' are zero, but broadcast flag
 		return net.IPv4bcast, net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
 	} else {
 		// Must avoid ARP (because client does not have IP yet)
-		addr := out.ClientHwAddr()
-		return out.YourIPAddr(), net.HardwareAddr(addr[:6])
+		return out.YourIPAddr(), v4.ClientHwAddr(out)
+	}
+}
+
+// clientMAC returns the MAC of the next hop towards the client's IP, which is resolved with ARP.
+// The request might have been forwarded by a router, so the MAC it came from is only used if that fails.
+func (s *ServerV4) clientMAC(ip net.IP, srcMAC net.HardwareAddr) net.HardwareAddr {
+	dstMAC, err := s.conn.ResolveMAC(ip)
+	if err != nil {
+		log.Printf("Using the source MAC '%s' for '%s': %s", srcMAC, ip, err)
+		return srcMAC
 	}
+
+	return dstMAC
+}
+
+func (s *ServerV4) determineNakDstAddr(in *dhcpv4.DHCPv4, out *dhcpv4.DHCPv4, srcMAC *net.HardwareAddr) (net.IP, net.HardwareAddr) {
+	/*
+	 From the RFC2131, Page 23:
+
+	 If 'giaddr' is set in the DHCPREQUEST message, the client is on a
+	 different subnet.  The server MUST set the broadcast bit in the
+	 DHCPNAK, so that the relay agent will broadcast the DHCPNAK to the
+	 client, because the client may not have a correct network address
+	 or subnet mask, and the client may not be answering ARP requests.
+	 Otherwise, the server MUST send the DHCPNAK message to the IP
+	 broadcast address (0xffffffff) using the IP broadcast address.
+	*/
+
+	if isRelayed(in) { // 'giaddr' is non-zero
+		out.SetBroadcast()
+
+		// The reply is routed by the kernel, see sendReply()
+		return in.GatewayIPAddr(), nil
+	}
+
+	return net.IPv4bcast, net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+}
+
+// prepareNak creates a DHCPNAK according to Table 3 of RFC2131.
+// It contains no addresses and only the message type, the server identifier and a message.
+func (s *ServerV4) prepareNak(in *dhcpv4.DHCPv4, message string) (*dhcpv4.DHCPv4, error) {
+	out, err := dhcpv4.New()
+	if err != nil {
+		log.Print("Can't create response.", err)
+		return nil, err
+	}
+
+	hwAddr := in.ClientHwAddr()
+	out.SetOpcode(dhcpv4.OpcodeBootReply)
+	out.SetHwType(in.HwType())
+	out.SetHwAddrLen(in.HwAddrLen())
+	out.SetHopCount(0)
+	out.SetTransactionID(in.TransactionID())
+	out.SetNumSeconds(0)
+	out.SetClientIPAddr(net.IPv4zero)
+	out.SetYourIPAddr(net.IPv4zero)
+	out.SetServerIPAddr(net.IPv4zero)
+	out.SetFlags(in.Flags())
+	out.SetGatewayIPAddr(in.GatewayIPAddr())
+	out.SetClientHwAddr(hwAddr[:])
+
+	options := v4.ReplyOptions{}
+	options.AddRequired(&dhcpv4.OptMessageType{MessageType: dhcpv4.MessageTypeNak})
+	options.AddRequired(&dhcpv4.OptServerIdentifier{ServerID: s.replyFrom})
+	if message != "" {
+		options.Add(&v4.OptMessage{Message: message})
+	}
+
+	s.addEchoedOptions(in, &options)
+	s.addOptions(in, out, &options)
+
+	return out, nil
 }
 
 func (s *ServerV4) prepareAnswer(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4, messageType dhcpv4.MessageType) (*dhcpv4.DHCPv4, error) {
+	requestInfo := s.requestInfo(in)
+
+	out, err := s.newReply(in, requestInfo, clientInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	options := v4.ReplyOptions{}
+	options.AddRequired(&dhcpv4.OptMessageType{MessageType: messageType})
+	options.AddRequired(&dhcpv4.OptServerIdentifier{ServerID: s.replyFrom})
+
+	// UEFI HTTP boot clients ignore replies without 'HTTPClient' in the vendor class identifier.
+	if requestInfo.IsHTTPClient() {
+		options.AddRequired(&dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionClassIdentifier, Data: []byte("HTTPClient")})
+	}
+
+	// RFC2131, Table 3: The lease time must be sent in every DHCPOFFER and DHCPACK to a DHCPREQUEST.
+	if clientInfo.Timeouts.Lease > 0 {
+		leaseTime := util.SafeConvertToUint32(clientInfo.Timeouts.Lease.Seconds())
+		log.Printf("Lease Time: %s -> %d", clientInfo.Timeouts.Lease.String(), leaseTime)
+		options.AddRequired(&dhcpv4.OptIPAddressLeaseTime{LeaseTime: leaseTime})
+	}
+
+	// RFC6704, Section 3.2: The nonce is handed to clients that announced to support it.
+	nonce := clientInfo.Client.ForceRenewNonce
+	if messageType == dhcpv4.MessageTypeAck && len(nonce) > 0 &&
+		v4.IsForceRenewNonceCapable(optionData(in, v4.OptionForcerenewNonceCapable)) {
+		options.AddRequired(v4.ForceRenewNonceOption(nonce))
+	}
+
+	// RFC4039, Section 4: A DHCPACK to a DHCPDISCOVER must carry the Rapid Commit option.
+	if messageType == dhcpv4.MessageTypeAck && in.MessageType() != nil && *in.MessageType() == dhcpv4.MessageTypeDiscover {
+		options.AddRequired(&dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionRapidCommit})
+	}
+
+	s.addClientOptions(in, requestInfo, clientInfo, &options)
+	s.addEchoedOptions(in, &options)
+	s.addOptions(in, out, &options)
+
+	return out, nil
+}
+
+// prepareBootReply creates a BOOTREPLY according to RFC951 and RFC1542.
+// It carries the options of the client as vendor extensions, but none of the DHCP-only options,
+// and the 'file' and 'sname' fields are never overloaded.
+// See https://tools.ietf.org/html/rfc1497 and https://tools.ietf.org/html/rfc1533
+func (s *ServerV4) prepareBootReply(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4) (*dhcpv4.DHCPv4, error) {
+	requestInfo := s.requestInfo(in)
+
+	out, err := s.newReply(in, requestInfo, clientInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	options := v4.ReplyOptions{NoOverload: true}
+	s.addClientOptions(in, requestInfo, clientInfo, &options)
+	s.addEchoedOptions(in, &options)
+	s.addOptions(in, out, &options)
+
+	return out, nil
+}
+
+// newReply creates a reply with the header fields for the client, but without options.
+func (s *ServerV4) newReply(in *dhcpv4.DHCPv4, requestInfo *v4.RequestInfoV4, clientInfo *v4.ClientInfoV4) (*dhcpv4.DHCPv4, error) {
 	out, err := dhcpv4.New()
 	if err != nil {
 		log.Print("Can't create response.", err)
 		return nil, err
 	}
 
+	s.selectBootEntry(in, requestInfo, clientInfo)
+
 	siaddr := net.IPv4zero
 	if clientInfo.NextServer != nil {
 		siaddr = clientInfo.NextServer
@@ -305,6 +1127,8 @@ func (s *ServerV4) prepareAnswer(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4,
 
 	hwAddr := in.ClientHwAddr()
 	out.SetOpcode(dhcpv4.OpcodeBootReply)
+	out.SetHwType(in.HwType())
+	out.SetHwAddrLen(in.HwAddrLen())
 	out.SetHopCount(0)
 	out.SetTransactionID(in.TransactionID())
 	out.SetNumSeconds(0)
@@ -316,43 +1140,134 @@ func (s *ServerV4) prepareAnswer(in *dhcpv4.DHCPv4, clientInfo *v4.ClientInfoV4,
 	out.SetClientHwAddr(hwAddr[:])
 	out.SetServerHostName([]byte(s.replyFromHostname))
 
-	out.AddOption(&dhcpv4.OptMessageType{MessageType: messageType})
-	out.AddOption(&dhcpv4.OptServerIdentifier{ServerID: s.replyFrom})
-	out.AddOption(&dhcpv4.OptSubnetMask{SubnetMask: clientInfo.IPMask})
+	if bootFileInFileField(requestInfo, clientInfo) {
+		out.SetBootFileName([]byte(clientInfo.BootFileName))
+	}
 
-	if clientInfo.Timeouts.Lease > 0 {
-		leaseTime := util.SafeConvertToUint32(clientInfo.Timeouts.Lease.Seconds())
-		log.Printf("Lease Time: %s -> %d", clientInfo.Timeouts.Lease.String(), leaseTime)
-		out.AddOption(&dhcpv4.OptIPAddressLeaseTime{LeaseTime: leaseTime})
+	return out, nil
+}
+
+// addClientOptions adds the options that describe the client's configuration.
+func (s *ServerV4) addClientOptions(in *dhcpv4.DHCPv4, requestInfo *v4.RequestInfoV4, clientInfo *v4.ClientInfoV4, options *v4.ReplyOptions) {
+	if len(clientInfo.IPMask) > 0 {
+		options.Add(&dhcpv4.OptSubnetMask{SubnetMask: clientInfo.IPMask})
 	}
 	if clientInfo.Timeouts.T1RenewalTime > 0 {
 		renewalTime := util.SafeConvertToUint32(clientInfo.Timeouts.T1RenewalTime.Seconds())
 		log.Printf("Renewal T1 Time: %s -> %d", clientInfo.Timeouts.T1RenewalTime.String(), renewalTime)
-		out.AddOption(&v4.OptRenewalTime{RenewalTime: renewalTime})
+		options.Add(&v4.OptRenewalTime{RenewalTime: renewalTime})
 	}
 	if clientInfo.Timeouts.T2RebindingTime > 0 {
 		rebindingTime := util.SafeConvertToUint32(clientInfo.Timeouts.T2RebindingTime.Seconds())
 		log.Printf("Rebinding T2 Time: %s -> %d", clientInfo.Timeouts.T2RebindingTime.String(), rebindingTime)
-		out.AddOption(&v4.OptRebindingTime{RebindingTime: rebindingTime})
+		options.Add(&v4.OptRebindingTime{RebindingTime: rebindingTime})
 	}
 	if clientInfo.Options.HostName != "" {
-		out.AddOption(&dhcpv4.OptHostName{HostName: clientInfo.Options.HostName})
+		options.Add(&dhcpv4.OptHostName{HostName: clientInfo.Options.HostName})
 	}
 	if clientInfo.Options.DomainName != "" {
-		out.AddOption(&dhcpv4.OptDomainName{DomainName: clientInfo.Options.DomainName})
+		options.Add(&dhcpv4.OptDomainName{DomainName: clientInfo.Options.DomainName})
+	}
+	if len(clientInfo.Options.DomainSearch) > 0 {
+		options.Add(&v4.OptDomainSearch{DomainSearch: clientInfo.Options.DomainSearch})
 	}
 	if len(clientInfo.Options.DomainNameServers) > 0 {
-		out.AddOption(&dhcpv4.OptDomainNameServer{NameServers: clientInfo.Options.DomainNameServers})
+		options.Add(&dhcpv4.OptDomainNameServer{NameServers: clientInfo.Options.DomainNameServers})
 	}
 	if len(clientInfo.Options.Routers) > 0 {
-		out.AddOption(&dhcpv4.OptRouter{Routers: clientInfo.Options.Routers})
+		options.Add(&dhcpv4.OptRouter{Routers: clientInfo.Options.Routers})
 	}
 	if len(clientInfo.Options.NTPServers) > 0 {
-		out.AddOption(&dhcpv4.OptNTPServers{NTPServers: clientInfo.Options.NTPServers})
+		options.Add(&dhcpv4.OptNTPServers{NTPServers: clientInfo.Options.NTPServers})
+	}
+	if clientInfo.Options.TimeZone != "" {
+		for _, timeZoneOption := range v4.TimeZoneOptions(clientInfo.Options.TimeZone) {
+			options.Add(timeZoneOption)
+		}
+	}
+	if len(clientInfo.Options.ClasslessStaticRoutes) > 0 {
+		routes := v4.WithDefaultRoute(clientInfo.Options.ClasslessStaticRoutes, clientInfo.Options.Routers)
+		options.Add(&v4.OptClasslessStaticRoute{OptionCode: v4.OptionClasslessStaticRoute, Routes: routes})
+		options.Add(&v4.OptClasslessStaticRoute{OptionCode: v4.OptionMSClasslessStaticRoute, Routes: routes})
 	}
-	if clientInfo.BootFileName != "" {
-		out.AddOption(&dhcpv4.OptBootfileName{BootfileName: []byte(clientInfo.BootFileName)})
+	if clientInfo.BootFileName != "" && !bootFileInFileField(requestInfo, clientInfo) {
+		options.Add(&dhcpv4.OptBootfileName{BootfileName: []byte(clientInfo.BootFileName)})
+	}
+	if _, optClientFQDN := s.dnsRecord(in, clientInfo); optClientFQDN != nil {
+		options.Add(optClientFQDN)
 	}
 
-	return out, nil
+	for _, vendorOption := range v4.VendorOptions(s.vendorOptions, requestInfo, clientInfo.VendorValues) {
+		options.Add(vendorOption)
+	}
+
+	// Custom options take precedence over the options above.
+	for _, customOption := range clientInfo.Options.Custom {
+		options.Set(customOption.Option())
+	}
+}
+
+// bootFileInFileField returns true if the boot file name is sent in the 'file' field rather than as option 67.
+// The name goes into only one of them, so that clients reading option 67 leave the 'file' field free for overload.
+func bootFileInFileField(requestInfo *v4.RequestInfoV4, clientInfo *v4.ClientInfoV4) bool {
+	return clientInfo.BootFileName != "" && len(clientInfo.BootFileName) < 128 && requestInfo.ReadsFileField()
+}
+
+// addEchoedOptions adds the options of the request that must be echoed in every reply.
+func (s *ServerV4) addEchoedOptions(in *dhcpv4.DHCPv4, options *v4.ReplyOptions) {
+	// RFC6842, Section 3: The option must be echoed in all replies.
+	if optClientIdentifier := clientIdentifier(in); optClientIdentifier != nil {
+		options.AddRequired(optClientIdentifier)
+	}
+
+	// RFC3046, Section 2.2: The option must be echoed in all replies.
+	if relayAgentInformation := relayAgentInformation(in); relayAgentInformation != nil {
+		options.AddLast(relayAgentInformation)
+	}
+}
+
+// addOptions adds the options to the reply in the order of the client's Parameter Request List.
+// If they exceed the client's Maximum DHCP Message Size, the 'file' and 'sname' fields are overloaded,
+// unless they carry the boot file name or the server's host name, and options that still don't fit are dropped.
+func (s *ServerV4) addOptions(in *dhcpv4.DHCPv4, out *dhcpv4.DHCPv4, options *v4.ReplyOptions) {
+	_, xid := s.getTransactionIDAndMAC(in)
+
+	options.FileInUse = out.BootFileNameToString() != ""
+	options.SNameInUse = out.ServerHostNameToString() != ""
+
+	layout := options.Layout(parameterRequestList(in), maxMessageSize(in))
+
+	for _, opt := range layout.Options {
+		out.AddOption(opt)
+	}
+	if layout.File != nil {
+		out.SetBootFileName(layout.File)
+	}
+	if layout.SName != nil {
+		out.SetServerHostName(layout.SName)
+	}
+
+	for _, opt := range layout.Dropped {
+		log.Printf("The option '%s' does not fit into the reply in transaction '%s'. Dropping it.", opt.String(), xid)
+	}
+}
+
+// parameterRequestList returns the codes of the options the client requested, or nil if it did not say.
+func parameterRequestList(in *dhcpv4.DHCPv4) []dhcpv4.OptionCode {
+	optParameterRequestList, ok := in.GetOneOption(dhcpv4.OptionParameterRequestList).(*dhcpv4.OptParameterRequestList)
+	if !ok {
+		return nil
+	}
+
+	return optParameterRequestList.RequestedOpts
+}
+
+// maxMessageSize returns the size of the largest message the client accepts.
+func maxMessageSize(in *dhcpv4.DHCPv4) int {
+	optMaximumDHCPMessageSize, ok := in.GetOneOption(dhcpv4.OptionMaximumDHCPMessageSize).(*dhcpv4.OptMaximumDHCPMessageSize)
+	if !ok {
+		return v4.MinMessageSize
+	}
+
+	return int(optMaximumDHCPMessageSize.Size)
 }
@@ -0,0 +1,196 @@
+package dhcp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cimnine/netbox-dhcp/ddns"
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+	"github.com/cimnine/netbox-dhcp/resolver"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func TestNakMessage(t *testing.T) {
+	errCache := errors.New("the cache is unavailable")
+
+	tests := []struct {
+		name          string
+		err           error
+		state         v4.RequestState
+		authoritative bool
+		want          string
+	}{
+		{"SELECTING, moved", resolver.ErrAddressMismatch, v4.RequestStateSelecting, false, nakMessageAddressMismatch},
+		{"SELECTING, offer expired", resolver.ErrNoRecord, v4.RequestStateSelecting, false, nakMessageNoRecord},
+		{"SELECTING, cache error", errCache, v4.RequestStateSelecting, true, ""},
+
+		{"INIT-REBOOT, moved", resolver.ErrAddressMismatch, v4.RequestStateInitReboot, false, nakMessageAddressMismatch},
+		{"INIT-REBOOT, unknown", resolver.ErrNoRecord, v4.RequestStateInitReboot, false, ""},
+		{"INIT-REBOOT, unknown to the authority", resolver.ErrNoRecord, v4.RequestStateInitReboot, true, nakMessageNoRecord},
+		{"INIT-REBOOT, cache error", errCache, v4.RequestStateInitReboot, true, ""},
+
+		{"RENEWING, moved", resolver.ErrAddressMismatch, v4.RequestStateRenewing, false, nakMessageAddressMismatch},
+		{"RENEWING, unknown", resolver.ErrNoRecord, v4.RequestStateRenewing, false, ""},
+		{"RENEWING, unknown to the authority", resolver.ErrNoRecord, v4.RequestStateRenewing, true, nakMessageNoRecord},
+		{"RENEWING, cache error", errCache, v4.RequestStateRenewing, true, ""},
+
+		{"REBINDING, moved", resolver.ErrAddressMismatch, v4.RequestStateRebinding, false, nakMessageAddressMismatch},
+		{"REBINDING, unknown", resolver.ErrNoRecord, v4.RequestStateRebinding, false, ""},
+		{"REBINDING, unknown to the authority", resolver.ErrNoRecord, v4.RequestStateRebinding, true, nakMessageNoRecord},
+		{"REBINDING, cache error", errCache, v4.RequestStateRebinding, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nakMessage(tt.err, tt.state, tt.authoritative); got != tt.want {
+				t.Errorf("got '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientID(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      *v4.OptClientIdentifier
+		wantDUID string
+		wantIAID string
+		wantOK   bool
+	}{
+		{
+			name:     "DUID-LL",
+			opt:      &v4.OptClientIdentifier{Type: 255, Identifier: []byte{0, 0, 0, 7, 0, 3, 0, 1, 0, 0x11, 0x22, 0x33, 0x44, 0x55}},
+			wantDUID: "0001001122334455",
+			wantIAID: "00000007",
+			wantOK:   true,
+		},
+		{
+			name:     "DUID-UUID",
+			opt:      &v4.OptClientIdentifier{Type: 255, Identifier: append([]byte{0, 0, 0, 0, 0, 4}, 0xc7, 0x92, 0xbc, 0xaa, 0x2f, 0x4e, 0x4c, 0x3b, 0x8e, 0x1c, 0x6f, 0x59, 0x30, 0x5a, 0x12, 0x34)},
+			wantDUID: "c792bcaa-2f4e-4c3b-8e1c-6f59305a1234",
+			wantIAID: "00000000",
+			wantOK:   true,
+		},
+		{name: "hardware address", opt: &v4.OptClientIdentifier{Type: 1, Identifier: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}}},
+		{name: "none"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duid, iaid, ok := clientID(tt.opt)
+			if duid != tt.wantDUID || iaid != tt.wantIAID || ok != tt.wantOK {
+				t.Errorf("got '%s', '%s' (%v), want '%s', '%s' (%v)", duid, iaid, ok, tt.wantDUID, tt.wantIAID, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestLacksClientIdentity(t *testing.T) {
+	rfc4361ID := []byte{255, 0, 0, 0, 1, 0, 3, 0, 32, 0xaa, 0xbb}
+	hwAddrID := []byte{32, 0xaa, 0xbb}
+
+	tests := []struct {
+		name        string
+		hwType      iana.HwTypeType
+		messageType dhcpv4.MessageType
+		clientID    []byte
+		want        bool
+	}{
+		{name: "Ethernet", hwType: iana.HwTypeEthernet, messageType: dhcpv4.MessageTypeDiscover},
+		{name: "InfiniBand with RFC4361 client identifier", hwType: iana.HwTypeInfiniband,
+			messageType: dhcpv4.MessageTypeDiscover, clientID: rfc4361ID},
+		{name: "InfiniBand with another client identifier", hwType: iana.HwTypeInfiniband,
+			messageType: dhcpv4.MessageTypeRequest, clientID: hwAddrID, want: true},
+		{name: "InfiniBand without client identifier", hwType: iana.HwTypeInfiniband,
+			messageType: dhcpv4.MessageTypeDiscover, want: true},
+		{name: "InfiniBand DHCPINFORM", hwType: iana.HwTypeInfiniband, messageType: dhcpv4.MessageTypeInform},
+		{name: "InfiniBand DHCPLEASEQUERY", hwType: iana.HwTypeInfiniband, messageType: v4.MessageTypeLeaseQuery},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in, err := dhcpv4.New()
+			if err != nil {
+				t.Fatalf("can't create the message: %s", err)
+			}
+			in.SetHwType(tt.hwType)
+			if tt.hwType == iana.HwTypeInfiniband {
+				in.SetHwAddrLen(0)
+			} else {
+				in.SetHwAddrLen(6)
+				in.SetClientHwAddr([]byte{0, 0x11, 0x22, 0x33, 0x44, 0x55})
+			}
+			in.AddOption(&dhcpv4.OptMessageType{MessageType: tt.messageType})
+			if tt.clientID != nil {
+				in.AddOption(&dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionClientIdentifier, Data: tt.clientID})
+			}
+
+			if got := lacksClientIdentity(in); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      ddns.DDNSConfig
+		fqdn        *v4.OptClientFQDN
+		wantForward bool
+		wantReverse bool
+		wantFlags   uint8
+		wantReply   bool
+	}{
+		{name: "N flag", fqdn: &v4.OptClientFQDN{Flags: v4.FQDNFlagN | v4.FQDNFlagE},
+			wantFlags: v4.FQDNFlagN | v4.FQDNFlagE, wantReply: true},
+		{name: "S flag", fqdn: &v4.OptClientFQDN{Flags: v4.FQDNFlagS},
+			wantForward: true, wantReverse: true, wantFlags: v4.FQDNFlagS, wantReply: true},
+		{name: "client updates", fqdn: &v4.OptClientFQDN{},
+			wantReverse: true, wantReply: true},
+		{name: "client updates overridden", config: ddns.DDNSConfig{OverrideClientUpdates: true}, fqdn: &v4.OptClientFQDN{},
+			wantForward: true, wantReverse: true, wantFlags: v4.FQDNFlagS | v4.FQDNFlagO, wantReply: true},
+		{name: "without FQDN option"},
+		{name: "without FQDN option, but updated", config: ddns.DDNSConfig{UpdateWithoutFQDN: true},
+			wantForward: true, wantReverse: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			s := ServerV4{dnsUpdater: &ddns.Updater{Config: &config}}
+
+			in, err := dhcpv4.New()
+			if err != nil {
+				t.Fatalf("can't create the message: %s", err)
+			}
+			if tt.fqdn != nil {
+				in.AddOption(tt.fqdn)
+			}
+
+			clientInfo := &v4.ClientInfoV4{IPAddr: net.IPv4(10, 0, 0, 1)}
+			clientInfo.Timeouts.Lease = time.Hour
+			clientInfo.Options.HostName = "host"
+			clientInfo.Options.DomainName = "example.com"
+
+			record, reply := s.dnsRecord(in, clientInfo)
+
+			forward, reverse := record != nil && record.Forward, record != nil && record.Reverse
+			if forward != tt.wantForward || reverse != tt.wantReverse {
+				t.Errorf("got forward %v and reverse %v, want %v and %v", forward, reverse, tt.wantForward, tt.wantReverse)
+			}
+			if record != nil && record.FQDN != "host.example.com" {
+				t.Errorf("got the record '%s', want 'host.example.com'", record.FQDN)
+			}
+			if (reply != nil) != tt.wantReply {
+				t.Fatalf("got reply %v, want one: %v", reply, tt.wantReply)
+			}
+			if reply != nil && (reply.Flags != tt.wantFlags || reply.DomainName != "host.example.com") {
+				t.Errorf("got the reply '%s' with flags %04b, want 'host.example.com' with %04b", reply.DomainName, reply.Flags, tt.wantFlags)
+			}
+		})
+	}
+}
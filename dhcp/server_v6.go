@@ -1,14 +1,18 @@
 package dhcp
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
+	"strings"
+	"time"
 
 	"github.com/google/gopacket/layers"
 	"github.com/satori/go.uuid"
 
+	"github.com/cimnine/netbox-dhcp/ddns"
 	"github.com/cimnine/netbox-dhcp/dhcp/config"
 	"github.com/cimnine/netbox-dhcp/dhcp/v6"
 	"github.com/cimnine/netbox-dhcp/dhcp/v6/consts"
@@ -17,6 +21,7 @@ import (
 
 type ServerV6 struct {
 	Resolver         resolver.Resolver
+	dnsUpdater       *ddns.Updater
 	dhcpConfig       *config.DHCPConfig
 	listenerConfig   *config.V6ListenerConfig
 	conn             *v6.DHCPV6Conn
@@ -27,7 +32,7 @@ type ServerV6 struct {
 
 type dhcpv6OptMap map[layers.DHCPv6Opt]layers.DHCPv6Options
 
-func NewServerV6(dhcpConfig *config.DHCPConfig, resolver resolver.Resolver, iface net.Interface, listenerConfig *config.V6ListenerConfig) (s ServerV6, err error) {
+func NewServerV6(dhcpConfig *config.DHCPConfig, resolver resolver.Resolver, dnsUpdater *ddns.Updater, iface net.Interface, listenerConfig *config.V6ListenerConfig) (s ServerV6, err error) {
 	conn, err := v6.ListenDHCPv6(iface, listenerConfig.ListenToAddresses(), listenerConfig.ReplyFromAddress())
 	if err != nil {
 		return s, err
@@ -40,6 +45,7 @@ func NewServerV6(dhcpConfig *config.DHCPConfig, resolver resolver.Resolver, ifac
 		listenerConfig:   listenerConfig,
 		iface:            iface,
 		Resolver:         resolver,
+		dnsUpdater:       dnsUpdater,
 	}
 
 	return s, nil
@@ -89,7 +95,7 @@ func (s *ServerV6) handlePacket(dhcp layers.DHCPv6, srcIP net.IP, srcMAC net.Har
 	case layers.DHCPv6MsgTypeInformationRequest:
 		//s.replyToInformation(dhcp, srcIP, srcMAC)
 	case layers.DHCPv6MsgTypeRelayForward:
-		//s.replyToRelayForward(dhcp, srcIP, srcMAC)
+		log.Printf("Ignoring the DHCPv6 RELAY-FORW from '%s', as relay agents are not supported yet.", srcIP)
 	case layers.DHCPv6MsgTypeUnspecified:
 		log.Printf("DHCPv6 Unspecified message type: '%s'", dhcp.MsgType.String())
 	default:
@@ -116,15 +122,7 @@ func (s *ServerV6) replyToSolicit(solicit layers.DHCPv6, srcIP net.IP, srcMAC ne
 
 	log.Printf("DHCPv6 SOLICIT message from '%s' with client ID '%s'.", srcIP, clientDUID)
 
-	var clientMAC net.HardwareAddr
-
-	if clientLLAddrOpt, found := optMap[layers.DHCPv6OptClientLinkLayerAddress]; found {
-		firstClientMAC := s.getClientLLAddr(clientLLAddrOpt)
-
-		clientMAC = firstClientMAC
-	} else {
-		clientMAC = srcMAC
-	}
+	clientMAC := s.getClientMAC(optMap, srcMAC)
 
 	if _, rapidCommitRequested := optMap[layers.DHCPv6OptRapidCommit]; rapidCommitRequested {
 		log.Printf("DHCPv6 RAPID_COMMIT option detected for client DUID '%s' / MAC '%s'", clientDUID, clientMAC)
@@ -150,11 +148,16 @@ func (s *ServerV6) replyToSolicit(solicit layers.DHCPv6, srcIP net.IP, srcMAC ne
 
 	inIANAOpts, hasIANA := optMap[layers.DHCPv6OptIANA]
 	outIANAOpts := make(layers.DHCPv6Options, len(inIANAOpts))
+	var leased []v6.ClientInfoV6
 	if hasIANA {
 		for _, inIanaOpt := range inIANAOpts {
 			clientInfo := resolver.NewClientInfoV6(s.dhcpConfig)
 
-			iana := v6.ParseIANAOption(inIanaOpt)
+			iana, err := v6.ParseIANAOption(inIanaOpt)
+			if err != nil {
+				log.Printf("Ignoring a malformed IA_NA of the client ID '%s' / MAC '%s': %s", clientDUID, clientMAC, err)
+				continue
+			}
 			iaid := iana.IAID.String()
 
 			ok, err := s.Resolver.SolicitationV6(&clientInfo, clientDUID, clientMAC.String(), iaid)
@@ -193,6 +196,7 @@ func (s *ServerV6) replyToSolicit(solicit layers.DHCPv6, srcIP net.IP, srcMAC ne
 			}
 
 			outIANAOpts = append(outIANAOpts, outIanaOpt)
+			leased = append(leased, clientInfo)
 		}
 	}
 
@@ -210,9 +214,15 @@ func (s *ServerV6) replyToSolicit(solicit layers.DHCPv6, srcIP net.IP, srcMAC ne
 		return
 	}
 
+	// The server tells the client in advance whether it will update the DNS records, see RFC4704 Section 6.1.
+	if _, fqdnOpt := s.dnsRecords(optMap, leased); fqdnOpt != nil {
+		outIANAOpts = append(outIANAOpts, *fqdnOpt)
+	}
+
 	successOption := statusOption(layers.DHCPv6StatusCodeSuccess, "")
 
-	err = s.sendAdvertise(rawClientDUID, append(outIANAOpts, successOption), solicit.TransactionID, dstIP, dstMAC)
+	outOpts := append(append(outIANAOpts, clientOptions(leased)...), successOption)
+	err = s.sendAdvertise(rawClientDUID, outOpts, solicit.TransactionID, dstIP, dstMAC)
 	if err != nil {
 		log.Printf("Can't send DHCPv6 ADVERTISE for client ID '%s' / MAC '%s' to '%s' ('%s'): %s",
 			clientDUID, clientMAC, dstIP, dstMAC, err)
@@ -246,7 +256,7 @@ func (s *ServerV6) handleIANA(iana v6.IANontemporaryAddress, clientInfo v6.Clien
 	if err != nil {
 		log.Printf(
 			"DHCPv6 SOLICITAION failed for the client ID '%s' / MAC '%s' because of an error while building the response: %s",
-			clientDUID, clientMAC)
+			clientDUID, clientMAC, err)
 		return outIanaOpt, outStatusOpt, err
 	}
 
@@ -259,6 +269,20 @@ func (s *ServerV6) handleIANA(iana v6.IANontemporaryAddress, clientInfo v6.Clien
 	return outIanaOpt, outStatusOpt, nil
 }
 
+// clientOptions returns the options that configure the client, like the domain search list.
+// The options occur once per message, so they are taken from the first IA_NA that IPs were leased to.
+func clientOptions(leased []v6.ClientInfoV6) layers.DHCPv6Options {
+	if len(leased) == 0 {
+		return nil
+	}
+
+	var options layers.DHCPv6Options
+	if len(leased[0].Options.DomainSearch) > 0 {
+		options = append(options, v6.DomainListOption(leased[0].Options.DomainSearch))
+	}
+	return options
+}
+
 func (s *ServerV6) sendAdvertise(rawClientDUID []byte, incomingOpts layers.DHCPv6Options, transactionID []byte, dstIP net.IP, dstMAC net.HardwareAddr) error {
 	options, err := s.serverAndClientIDOptions(rawClientDUID)
 	if err != nil {
@@ -295,6 +319,16 @@ func (s *ServerV6) sendAdvertise(rawClientDUID []byte, incomingOpts layers.DHCPv
 	return nil
 }
 
+// getClientMAC returns the MAC of the Client Link-Layer Address option, or the MAC the message came from.
+// See https://tools.ietf.org/html/rfc6939
+func (s *ServerV6) getClientMAC(optMap dhcpv6OptMap, srcMAC net.HardwareAddr) net.HardwareAddr {
+	if clientLLAddrOpt, found := optMap[layers.DHCPv6OptClientLinkLayerAddress]; found {
+		return s.getClientLLAddr(clientLLAddrOpt)
+	}
+
+	return srcMAC
+}
+
 func (s *ServerV6) getClientLLAddr(clientLLAddrOpt layers.DHCPv6Options) net.HardwareAddr {
 	if len(clientLLAddrOpt) == 0 {
 		log.Printf(
@@ -323,6 +357,14 @@ func (s *ServerV6) replyToRequest(request layers.DHCPv6, srcIP net.IP, srcMAC ne
 		return
 	}
 
+	// Servers discard Requests without a Server Identifier or with the Server Identifier of another server.
+	// A Solicit with the Rapid Commit option has no Server Identifier.
+	// See https://tools.ietf.org/html/rfc8415#section-16.4
+	if !rapidCommit && !s.isForUs(optMap) {
+		log.Printf("DHCPv6 REQUEST from client ID '%s' is not for us.", clientDUID)
+		return
+	}
+
 	// When the server receives a Request message via unicast from a client
 	// to which the server has not sent a unicast option, the server
 	// discards the Request message and responds with a Reply message
@@ -330,7 +372,7 @@ func (s *ServerV6) replyToRequest(request layers.DHCPv6, srcIP net.IP, srcMAC ne
 	// Identifier option containing the server's DUID, the Client Identifier
 	// option from the client message, and no other options.
 	// https://tools.ietf.org/html/rfc3315#section-18.2.1
-	if !s.listenerConfig.AdvertiseUnicast && srcIP.IsGlobalUnicast() {
+	if !rapidCommit && !s.listenerConfig.AdvertiseUnicast && srcIP.IsGlobalUnicast() {
 		options, err := s.serverAndClientIDOptions(rawClientDUID)
 		if err != nil {
 			log.Printf("Error constructing server DUID and/or client DUID: %s", err)
@@ -349,8 +391,14 @@ func (s *ServerV6) replyToRequest(request layers.DHCPv6, srcIP net.IP, srcMAC ne
 		var dstIP net.IP
 		var dstMAC net.HardwareAddr
 
-		dstIP = srcIP   // TODO handle relay case
-		dstMAC = srcMAC // TODO handle relay case
+		dstIP = srcIP // TODO handle relay case
+
+		// The client might be behind a router, as it sent the request to a unicast address.
+		dstMAC, err = s.conn.ResolveMAC(dstIP)
+		if err != nil {
+			log.Printf("Using the source MAC '%s' for '%s': %s", srcMAC, dstIP, err)
+			dstMAC = srcMAC
+		}
 
 		err = s.conn.WriteTo(reply, dstIP, dstMAC)
 		if err != nil {
@@ -364,6 +412,202 @@ func (s *ServerV6) replyToRequest(request layers.DHCPv6, srcIP net.IP, srcMAC ne
 
 		return
 	}
+
+	// Relay-forward messages are not handled, so the request came from the client directly.
+	dstIP := srcIP
+	dstMAC := srcMAC
+
+	clientMAC := s.getClientMAC(optMap, srcMAC)
+
+	log.Printf("DHCPv6 REQUEST message from '%s' with client ID '%s' / MAC '%s'.", srcIP, clientDUID, clientMAC)
+
+	var ianaOpts layers.DHCPv6Options
+	var leased []v6.ClientInfoV6
+	for _, inIanaOpt := range optMap[layers.DHCPv6OptIANA] {
+		iana, err := v6.ParseIANAOption(inIanaOpt)
+		if err != nil {
+			log.Printf("Ignoring a malformed IA_NA of the client ID '%s' / MAC '%s': %s", clientDUID, clientMAC, err)
+			continue
+		}
+
+		outIanaOpt, clientInfo, err := s.leaseIANA(iana, clientDUID, clientMAC)
+		if err != nil {
+			log.Printf("Can't lease IPs for the IA_NA with IAID '%s' of the client ID '%s' / MAC '%s': %s",
+				iana.IAID, clientDUID, clientMAC, err)
+
+			// RFC8415, Section 18.3.2: IAs without addresses are returned with the status NoAddrsAvail.
+			outIanaOpt, err = v6.NoAddrsAvailOption(iana.IAID, "No addresses found for your machine.")
+			if err != nil {
+				log.Printf("Can't encode the IA_NA with IAID '%s': %s", iana.IAID, err)
+				continue
+			}
+		} else {
+			leased = append(leased, clientInfo)
+		}
+
+		ianaOpts = append(ianaOpts, outIanaOpt)
+	}
+
+	options, err := s.serverAndClientIDOptions(rawClientDUID)
+	if err != nil {
+		log.Printf("Error constructing server DUID and/or client DUID: %s", err)
+		return
+	}
+
+	options = append(options, ianaOpts...)
+	options = append(options, clientOptions(leased)...)
+
+	var records []ddns.Record
+	if len(leased) > 0 {
+		var fqdnOpt *layers.DHCPv6Option
+		records, fqdnOpt = s.dnsRecords(optMap, leased)
+		if fqdnOpt != nil {
+			options = append(options, *fqdnOpt)
+		}
+	}
+
+	// RFC8415, Section 18.3.1: The Reply to a Solicit contains the Rapid Commit option.
+	if rapidCommit {
+		options = append(options, layers.DHCPv6Option{Code: layers.DHCPv6OptRapidCommit})
+	}
+
+	options = append(options, statusOption(layers.DHCPv6StatusCodeSuccess, ""))
+
+	reply := layers.DHCPv6{
+		MsgType:       layers.DHCPv6MsgTypeReply,
+		TransactionID: request.TransactionID,
+		HopCount:      0,
+		Options:       options,
+	}
+
+	err = s.conn.WriteTo(reply, dstIP, dstMAC)
+	if err != nil {
+		log.Printf("Can't send DHCPv6 REPLY for client ID '%s' / MAC '%s' to '%s' ('%s'): %s",
+			clientDUID, clientMAC, dstIP, dstMAC, err)
+		return
+	}
+
+	log.Printf("Sent a DHCPv6 REPLY with %d leased IA_NA for client ID '%s' / MAC '%s' to '%s' ('%s')",
+		len(leased), clientDUID, clientMAC, dstIP, dstMAC)
+
+	if len(leased) > 0 {
+		s.updateDNS(records, leased[0].Timeouts.ValidLifetime)
+	}
+}
+
+// leaseIANA leases the IPs of the client to the IA_NA and returns the IA_NA option of the reply.
+// Addresses the client asked for, but that are not designated for it, are returned with lifetimes of 0.
+// See https://tools.ietf.org/html/rfc8415#section-18.3.2
+func (s *ServerV6) leaseIANA(iana v6.IANontemporaryAddress, clientDUID string, clientMAC net.HardwareAddr) (layers.DHCPv6Option, v6.ClientInfoV6, error) {
+	clientInfo := resolver.NewClientInfoV6(s.dhcpConfig)
+	iaid := iana.IAID.String()
+
+	ok, err := s.Resolver.RequestV6(&clientInfo, clientDUID, clientMAC.String(), iaid)
+	if err != nil {
+		return layers.DHCPv6Option{}, clientInfo, err
+	} else if !ok || len(clientInfo.IPAddrs) == 0 {
+		return layers.DHCPv6Option{}, clientInfo, fmt.Errorf("no IPs for the IAID '%s'", iaid)
+	}
+
+	if invalid := v6.InvalidAddresses(iana, clientInfo); len(invalid) > 0 {
+		log.Printf("The IPv6s %v are not designated for the IAID '%s' of the client ID '%s' / MAC '%s', returning them with lifetimes of 0.",
+			invalid, iaid, clientDUID, clientMAC)
+	}
+
+	outIanaOpt, err := v6.LeaseOption(iana, clientInfo)
+	return outIanaOpt, clientInfo, err
+}
+
+// isForUs returns true if the message contains the Server Identifier option with this server's DUID.
+func (s *ServerV6) isForUs(optMap dhcpv6OptMap) bool {
+	serverIDs, found := optMap[layers.DHCPv6OptServerID]
+	if !found || len(serverIDs) != 1 {
+		return false
+	}
+
+	serverDUID, err := s.dhcpConfig.ServerDUID()
+	if err != nil {
+		log.Printf("Can't create the server DUID: %s", err)
+		return false
+	}
+
+	return bytes.Equal(serverIDs[0].Data, serverDUID)
+}
+
+// dnsRecords decides which DNS records are updated for the IPs of the client, following the flags of
+// its Client FQDN option, and returns the Client FQDN option of the reply, if the client sent one.
+// The records are named after the client's host name and domain name, i.e. the name of the Device in Netbox.
+// See https://tools.ietf.org/html/rfc4704#section-4
+func (s *ServerV6) dnsRecords(optMap dhcpv6OptMap, leased []v6.ClientInfoV6) ([]ddns.Record, *layers.DHCPv6Option) {
+	if s.dnsUpdater == nil || len(leased) == 0 {
+		return nil, nil
+	}
+
+	fqdn := leased[0].Options.HostName
+	if fqdn == "" {
+		return nil, nil
+	} else if !strings.Contains(fqdn, ".") {
+		if leased[0].Options.DomainName == "" {
+			return nil, nil
+		}
+		fqdn += "." + leased[0].Options.DomainName
+	}
+
+	forward, reverse := true, true
+	var reply *layers.DHCPv6Option
+
+	if fqdnOpts, found := optMap[layers.DHCPv6OptClientFQDN]; !found {
+		if !s.dnsUpdater.Config.UpdateWithoutFQDN {
+			return nil, nil
+		}
+	} else {
+		clientFQDN, err := v6.ParseClientFQDN(fqdnOpts[0].Data)
+		if err != nil {
+			log.Printf("Can't decypher the Client FQDN option: %s", err)
+			return nil, nil
+		}
+
+		replyFQDN := v6.ClientFQDN{DomainName: fqdn}
+		switch {
+		case clientFQDN.Flags&v6.FQDNFlagN != 0:
+			forward, reverse = false, false
+			replyFQDN.Flags = v6.FQDNFlagN
+		case clientFQDN.Flags&v6.FQDNFlagS != 0:
+			replyFQDN.Flags = v6.FQDNFlagS
+		case s.dnsUpdater.Config.OverrideClientUpdates:
+			replyFQDN.Flags = v6.FQDNFlagS | v6.FQDNFlagO
+		default:
+			forward = false
+		}
+
+		option := replyFQDN.Option()
+		reply = &option
+	}
+
+	if !forward && !reverse {
+		return nil, reply
+	}
+
+	var records []ddns.Record
+	for _, clientInfo := range leased {
+		for _, ip := range clientInfo.IPAddrs {
+			records = append(records, ddns.Record{FQDN: fqdn, IP: ip, Forward: forward, Reverse: reverse})
+		}
+	}
+
+	return records, reply
+}
+
+// updateDNS registers the DNS records of the leased IPs in the background, once they are bound to the client.
+func (s *ServerV6) updateDNS(records []ddns.Record, lease time.Duration) {
+	for _, record := range records {
+		record := record
+		go func() {
+			if err := s.dnsUpdater.Register(record, lease); err != nil {
+				log.Printf("Can't update the DNS records of '%s' ('%s'): %s", record.IP, record.FQDN, err)
+			}
+		}()
+	}
 }
 
 // extractClientDUID returns the rawClientDUID for use in the response, and the clientDUID for use in a lookup
@@ -456,9 +700,17 @@ func (s *ServerV6) serverAndClientIDOptions(rawClientDUID []byte) (layers.DHCPv6
 // It will always return a string based on the content after the DUID type code.
 // It will return an error if the content should be interpreted, but this was unsuccessful.
 func parseClientDUID(duid []byte) (string, error) {
+	if len(duid) < 2 {
+		return fmt.Sprintf("%x", duid), fmt.Errorf("'%x' is too short to be a DUID", duid)
+	}
+
 	duidTypeCode := consts.DHCPv6DUIDTypeCode(binary.BigEndian.Uint16(duid[:2]))
 	switch duidTypeCode {
 	case consts.DHCPv6DUIDTypeUUID:
+		if len(duid) < 18 {
+			return fmt.Sprintf("%x", duid[2:]),
+				fmt.Errorf("'%x' was expected to be an UUID, but it's too short", duid[2:])
+		}
 		u, err := uuid.FromBytes(duid[2:18])
 		if err != nil {
 			return fmt.Sprintf("%x", duid[2:]),
@@ -0,0 +1,134 @@
+package v4
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+)
+
+// BootEntry describes what a client boots.
+type BootEntry struct {
+	BootFileName string
+	NextServer   net.IP
+	// HTTPURL is the boot file for UEFI HTTP boot clients.
+	HTTPURL string
+}
+
+const (
+	// BootKeyIPXE selects the boot entry for clients that are already running iPXE,
+	// so that they are not told to chain-load iPXE over and over again.
+	BootKeyIPXE = "ipxe"
+	// BootKeyDefault selects the boot entry for clients that match no other entry.
+	BootKeyDefault = "default"
+
+	vendorClassPXEClient  = "PXEClient"
+	vendorClassHTTPClient = "HTTPClient"
+	userClassIPXE         = "iPXE"
+)
+
+// architectureNames maps the client system architecture types to the keys of the boot map.
+// See https://www.iana.org/assignments/dhcpv6-parameters/dhcpv6-parameters.xhtml#processor-architecture
+var architectureNames = map[uint16]string{
+	0:  "bios",
+	6:  "efi-ia32",
+	7:  "efi-x64",
+	9:  "efi-x64", // officially EFI byte code, but used by many x64 firmwares
+	10: "efi-arm32",
+	11: "efi-arm64",
+	15: "efi-ia32-http",
+	16: "efi-x64-http",
+	18: "efi-arm32-http",
+	19: "efi-arm64-http",
+}
+
+// ParseArchitectures parses the data of the Client System Architecture Type option.
+// See https://tools.ietf.org/html/rfc4578#section-2.1
+func ParseArchitectures(data []byte) []uint16 {
+	architectures := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		architectures = append(architectures, binary.BigEndian.Uint16(data[i:i+2]))
+	}
+	return architectures
+}
+
+// ParseUserClasses parses the data of the User Class option.
+// Some clients, like iPXE, send a plain string instead of the length-prefixed classes of RFC3004.
+// See https://tools.ietf.org/html/rfc3004#section-4
+func ParseUserClasses(data []byte) []string {
+	var userClasses []string
+	for rest := data; len(rest) > 0; {
+		length := int(rest[0])
+		if length == 0 || len(rest) < 1+length {
+			return []string{string(data)}
+		}
+
+		userClasses = append(userClasses, string(rest[1:1+length]))
+		rest = rest[1+length:]
+	}
+	return userClasses
+}
+
+// IsPXEClient returns true if the client identified itself as PXE client in the vendor class.
+func (r *RequestInfoV4) IsPXEClient() bool {
+	return strings.HasPrefix(r.VendorClass, vendorClassPXEClient)
+}
+
+// IsHTTPClient returns true if the client identified itself as UEFI HTTP boot client in the vendor class.
+func (r *RequestInfoV4) IsHTTPClient() bool {
+	return strings.HasPrefix(r.VendorClass, vendorClassHTTPClient)
+}
+
+// IsIPXE returns true if the client is iPXE.
+func (r *RequestInfoV4) IsIPXE() bool {
+	for _, userClass := range r.UserClasses {
+		if userClass == userClassIPXE {
+			return true
+		}
+	}
+	return false
+}
+
+// IsNetworkBoot returns true if the client is a PXE, UEFI HTTP boot, iPXE or BOOTP client.
+func (r *RequestInfoV4) IsNetworkBoot() bool {
+	return r.IsPXEClient() || r.IsHTTPClient() || r.IsIPXE() || r.BOOTP
+}
+
+// ReadsFileField returns true if the client reads its boot file name from the 'file' field instead of option 67.
+// Legacy PXE ROMs and BOOTP clients do, while iPXE and UEFI HTTP boot clients read option 67,
+// which leaves the 'file' field free for Option Overload.
+// See https://tools.ietf.org/html/rfc2132#section-9.5
+func (r *RequestInfoV4) ReadsFileField() bool {
+	return r.BOOTP || (r.IsPXEClient() && !r.IsIPXE())
+}
+
+// SelectBootEntry chooses the boot entry for the client out of the boot map.
+// Clients running iPXE get the 'ipxe' entry, all others the entry of their architecture.
+// If there's no such entry, the 'default' entry is chosen.
+// UEFI HTTP boot clients get the HTTP URL as their boot file.
+func SelectBootEntry(bootMap map[string]BootEntry, requestInfo *RequestInfoV4) (BootEntry, string, bool) {
+	keys := make([]string, 0, len(requestInfo.Architectures)+2)
+	if requestInfo.IsIPXE() {
+		keys = append(keys, BootKeyIPXE)
+	}
+	for _, architecture := range requestInfo.Architectures {
+		if name, ok := architectureNames[architecture]; ok {
+			keys = append(keys, name)
+		}
+	}
+	keys = append(keys, BootKeyDefault)
+
+	for _, key := range keys {
+		entry, ok := bootMap[key]
+		if !ok {
+			continue
+		}
+
+		if requestInfo.IsHTTPClient() && entry.HTTPURL != "" {
+			entry.BootFileName = entry.HTTPURL
+		}
+
+		return entry, key, true
+	}
+
+	return BootEntry{}, "", false
+}
@@ -0,0 +1,135 @@
+package v4
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestParseArchitectures(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []uint16
+	}{
+		{data: []byte{0, 7}, want: []uint16{7}},
+		{data: []byte{0, 0, 0, 16}, want: []uint16{0, 16}},
+		{data: []byte{0, 7, 0}, want: []uint16{7}},
+		{data: nil, want: []uint16{}},
+	}
+
+	for _, tt := range tests {
+		if got := ParseArchitectures(tt.data); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseArchitectures(%v) = %v, want %v", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestParseUserClasses(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{name: "RFC3004", data: []byte("\x04iPXE\x03foo"), want: []string{"iPXE", "foo"}},
+		{name: "plain string", data: []byte("iPXE"), want: []string{"iPXE"}},
+		{name: "truncated class", data: []byte("\x05iPXE"), want: []string{"\x05iPXE"}},
+		{name: "empty class", data: []byte("\x00iPXE"), want: []string{"\x00iPXE"}},
+		{name: "empty", data: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseUserClasses(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectBootEntry(t *testing.T) {
+	bootMap := map[string]BootEntry{
+		"bios":    {BootFileName: "undionly.kpxe"},
+		"efi-x64": {BootFileName: "ipxe.efi", NextServer: net.IPv4(172, 24, 0, 3), HTTPURL: "http://172.24.0.3/ipxe.efi"},
+		"ipxe":    {BootFileName: "http://172.24.0.3/boot.ipxe"},
+	}
+	withDefault := map[string]BootEntry{
+		"default": {BootFileName: "pxelinux.0"},
+	}
+
+	tests := []struct {
+		name        string
+		bootMap     map[string]BootEntry
+		requestInfo RequestInfoV4
+		wantKey     string
+		wantFile    string
+		wantOK      bool
+	}{
+		{
+			name:        "BIOS",
+			bootMap:     bootMap,
+			requestInfo: RequestInfoV4{VendorClass: "PXEClient:Arch:00000:UNDI:002001", Architectures: []uint16{0}},
+			wantKey:     "bios", wantFile: "undionly.kpxe", wantOK: true,
+		},
+		{
+			name:        "EFI byte code",
+			bootMap:     bootMap,
+			requestInfo: RequestInfoV4{VendorClass: "PXEClient:Arch:00009:UNDI:003016", Architectures: []uint16{9}},
+			wantKey:     "efi-x64", wantFile: "ipxe.efi", wantOK: true,
+		},
+		{
+			name:        "UEFI HTTP boot",
+			bootMap:     bootMap,
+			requestInfo: RequestInfoV4{VendorClass: "HTTPClient:Arch:00016:UNDI:003001", Architectures: []uint16{16, 7}},
+			wantKey:     "efi-x64", wantFile: "http://172.24.0.3/ipxe.efi", wantOK: true,
+		},
+		{
+			name:    "iPXE doesn't chain-load iPXE again",
+			bootMap: bootMap,
+			requestInfo: RequestInfoV4{VendorClass: "PXEClient:Arch:00000:UNDI:002001", Architectures: []uint16{0},
+				UserClasses: []string{"iPXE"}},
+			wantKey: "ipxe", wantFile: "http://172.24.0.3/boot.ipxe", wantOK: true,
+		},
+		{
+			name:        "unknown architecture",
+			bootMap:     withDefault,
+			requestInfo: RequestInfoV4{VendorClass: "PXEClient", Architectures: []uint16{11}},
+			wantKey:     "default", wantFile: "pxelinux.0", wantOK: true,
+		},
+		{
+			name:        "no entry",
+			bootMap:     bootMap,
+			requestInfo: RequestInfoV4{VendorClass: "PXEClient", Architectures: []uint16{11}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry, key, ok := SelectBootEntry(tt.bootMap, &tt.requestInfo)
+			if ok != tt.wantOK || key != tt.wantKey || entry.BootFileName != tt.wantFile {
+				t.Errorf("got '%s' with '%s' (%v), want '%s' with '%s' (%v)", key, entry.BootFileName, ok, tt.wantKey, tt.wantFile, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReadsFileField(t *testing.T) {
+	tests := []struct {
+		name        string
+		requestInfo RequestInfoV4
+		want        bool
+	}{
+		{name: "PXE", requestInfo: RequestInfoV4{VendorClass: "PXEClient:Arch:00000:UNDI:002001"}, want: true},
+		{name: "BOOTP", requestInfo: RequestInfoV4{BOOTP: true}, want: true},
+		{name: "iPXE", requestInfo: RequestInfoV4{VendorClass: "PXEClient:Arch:00000:UNDI:002001", UserClasses: []string{"iPXE"}}},
+		{name: "UEFI HTTP boot", requestInfo: RequestInfoV4{VendorClass: "HTTPClient:Arch:00016:UNDI:003001"}},
+		{name: "no network boot", requestInfo: RequestInfoV4{VendorClass: "MSFT 5.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.requestInfo.ReadsFileField(); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,26 +1,129 @@
 package v4
 
 import (
+	"bytes"
+	"math"
 	"net"
 	"time"
 )
 
+// InfiniteLease is the lease time of leases that never expire.
+// See https://tools.ietf.org/html/rfc2131#section-3.3
+const InfiniteLease = time.Duration(math.MaxUint32) * time.Second
+
 type ClientInfoV4 struct {
 	IPAddr       net.IP
 	IPMask       net.IPMask
 	NextServer   net.IP
 	BootFileName string
+	BootMap      map[string]BootEntry
+	// VendorValues fill the vendor sub-options, see VendorOptions
+	VendorValues map[string]interface{}
 	Timeouts     struct {
 		Reservation     time.Duration
 		Lease           time.Duration
 		T1RenewalTime   time.Duration
 		T2RebindingTime time.Duration
+		// MinLease and MaxLease bound the lease time, see ApplyRequestedLease. Zero means unbounded.
+		MinLease time.Duration
+		MaxLease time.Duration
+	}
+	// Client identifies the client the IP is handed out to, to answer leasequeries.
+	Client struct {
+		HardwareType          uint8
+		HardwareAddr          net.HardwareAddr
+		ClientID              []byte
+		RelayAgentInformation []byte
+		// ServerID is the server that handed out the IP, ForceRenewNonce authenticates its DHCPFORCERENEWs.
+		ServerID        net.IP
+		ForceRenewNonce []byte
+		// ForceRenewSent is when the DHCPFORCERENEWs for a change of the configuration were started,
+		// or zero if the client renewed since.
+		ForceRenewSent time.Time
 	}
 	Options struct {
-		HostName          string
-		DomainName        string
-		Routers           []net.IP
-		DomainNameServers []net.IP
-		NTPServers        []net.IP
+		HostName              string
+		DomainName            string
+		DomainSearch          []string
+		Routers               []net.IP
+		DomainNameServers     []net.IP
+		NTPServers            []net.IP
+		ClasslessStaticRoutes []Route
+		Custom                []CustomOption
+		// TimeZone is the name of the time zone in the tz database, e.g. 'Europe/Zurich'
+		TimeZone string
+	}
+}
+
+// ConfigurationDiffers returns true if the IP, the gateways, the name and time servers, the domain search list
+// or the time zone differ, i.e. if a client with this info needs to renew its lease to get the other info.
+func (c *ClientInfoV4) ConfigurationDiffers(other *ClientInfoV4) bool {
+	return !c.IPAddr.Equal(other.IPAddr) ||
+		!bytes.Equal(c.IPMask, other.IPMask) ||
+		c.Options.DomainName != other.Options.DomainName ||
+		c.Options.TimeZone != other.Options.TimeZone ||
+		!equalStrings(c.Options.DomainSearch, other.Options.DomainSearch) ||
+		!equalIPs(c.Options.Routers, other.Options.Routers) ||
+		!equalIPs(c.Options.DomainNameServers, other.Options.DomainNameServers) ||
+		!equalIPs(c.Options.NTPServers, other.Options.NTPServers)
+}
+
+// ApplyRequestedLease sets the lease time to the one the client requested, if it requested one,
+// and bounds it by MinLease and MaxLease.
+// See https://tools.ietf.org/html/rfc2131#section-4.3.1
+func (c *ClientInfoV4) ApplyRequestedLease(requested time.Duration) {
+	lease := c.Timeouts.Lease
+	if lease == InfiniteLease || lease == 0 {
+		return
+	}
+
+	if requested > 0 {
+		lease = requested
+	}
+	if c.Timeouts.MinLease > 0 && lease < c.Timeouts.MinLease {
+		lease = c.Timeouts.MinLease
+	}
+	if c.Timeouts.MaxLease > 0 && lease > c.Timeouts.MaxLease {
+		lease = c.Timeouts.MaxLease
+	}
+
+	c.SetLease(lease)
+}
+
+// SetLease sets the lease time. T1 and T2 keep their proportion to the lease time.
+func (c *ClientInfoV4) SetLease(lease time.Duration) {
+	if c.Timeouts.Lease == lease {
+		return
+	}
+
+	if c.Timeouts.Lease > 0 && c.Timeouts.Lease != InfiniteLease {
+		ratio := float64(lease) / float64(c.Timeouts.Lease)
+		c.Timeouts.T1RenewalTime = time.Duration(float64(c.Timeouts.T1RenewalTime) * ratio)
+		c.Timeouts.T2RebindingTime = time.Duration(float64(c.Timeouts.T2RebindingTime) * ratio)
+	}
+	c.Timeouts.Lease = lease
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalIPs(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
 	}
+	return true
 }
@@ -0,0 +1,203 @@
+package v4
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// CustomOption is an option that is configured by the user instead of being derived from Netbox.
+type CustomOption struct {
+	Code uint8
+	Data []byte
+}
+
+// reservedCodes are the options that are managed by the server and can't be configured.
+var reservedCodes = map[dhcpv4.OptionCode]bool{
+	dhcpv4.OptionPad:                    true,
+	dhcpv4.OptionRequestedIPAddress:     true,
+	dhcpv4.OptionIPAddressLeaseTime:     true,
+	dhcpv4.OptionOptionOverload:         true,
+	dhcpv4.OptionDHCPMessageType:        true,
+	dhcpv4.OptionServerIdentifier:       true,
+	dhcpv4.OptionParameterRequestList:   true,
+	dhcpv4.OptionMaximumDHCPMessageSize: true,
+	dhcpv4.OptionRenewTimeValue:         true,
+	dhcpv4.OptionRebindingTimeValue:     true,
+	dhcpv4.OptionClientIdentifier:       true,
+	OptionClientFQDN:                    true,
+	dhcpv4.OptionRelayAgentInformation:  true,
+	dhcpv4.OptionAuthentication:         true,
+	OptionForcerenewNonceCapable:        true,
+	dhcpv4.OptionEnd:                    true,
+}
+
+// NewCustomOption validates and encodes a custom option.
+// The type is one of 'ip', 'ip-list', 'uint8', 'uint16', 'uint32', 'string', 'hex' and 'bool'.
+// Lists are either given as list or as comma separated string.
+func NewCustomOption(code int, optionType string, value interface{}) (CustomOption, error) {
+	if code < 1 || code > 254 || reservedCodes[dhcpv4.OptionCode(code)] {
+		return CustomOption{}, fmt.Errorf("option code %d can't be configured", code)
+	}
+
+	data, err := encodeOptionValue(optionType, value)
+	if err != nil {
+		return CustomOption{}, fmt.Errorf("option %d: %s", code, err)
+	}
+
+	if len(data) > 255 {
+		return CustomOption{}, fmt.Errorf("option %d: value is longer than 255 bytes", code)
+	}
+
+	return CustomOption{Code: uint8(code), Data: data}, nil
+}
+
+// Option returns the option to add to a reply.
+func (c CustomOption) Option() dhcpv4.Option {
+	return &dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionCode(c.Code), Data: c.Data}
+}
+
+// MergeCustomOptions returns the options, where the additional options replace options with the same code.
+func MergeCustomOptions(options, additional []CustomOption) []CustomOption {
+	merged := make([]CustomOption, 0, len(options)+len(additional))
+	for _, option := range options {
+		replaced := false
+		for _, a := range additional {
+			if a.Code == option.Code {
+				replaced = true
+				break
+			}
+		}
+
+		if !replaced {
+			merged = append(merged, option)
+		}
+	}
+
+	return append(merged, additional...)
+}
+
+func encodeOptionValue(optionType string, value interface{}) ([]byte, error) {
+	switch optionType {
+	case "ip":
+		return encodeIP(value)
+	case "ip-list":
+		var data []byte
+		for _, v := range listOf(value) {
+			ip, err := encodeIP(v)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, ip...)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("empty ip-list")
+		}
+		return data, nil
+	case "uint8":
+		n, err := toUint(value, math.MaxUint8)
+		return []byte{byte(n)}, err
+	case "uint16":
+		n, err := toUint(value, math.MaxUint16)
+		data := make([]byte, 2)
+		binary.BigEndian.PutUint16(data, uint16(n))
+		return data, err
+	case "uint32":
+		n, err := toUint(value, math.MaxUint32)
+		data := make([]byte, 4)
+		binary.BigEndian.PutUint32(data, uint32(n))
+		return data, err
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("'%v' is not a string", value)
+		}
+		return []byte(s), nil
+	case "hex":
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("'%v' is not a hex string", value)
+		}
+		return hex.DecodeString(strings.NewReplacer(":", "", " ", "").Replace(s))
+	case "bool":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("'%v' is not a bool", value)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", optionType)
+	}
+}
+
+func encodeIP(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("'%v' is not an IPv4", value)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(s)).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("'%s' is not an IPv4", s)
+	}
+
+	return ip, nil
+}
+
+// listOf returns the value as list. Strings are split at commas.
+func listOf(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		return v
+	case string:
+		var list []interface{}
+		for _, s := range strings.Split(v, ",") {
+			list = append(list, s)
+		}
+		return list
+	default:
+		return []interface{}{value}
+	}
+}
+
+// toUint converts numbers as they are decoded from YAML and JSON, and numeric strings.
+func toUint(value interface{}, max uint64) (uint64, error) {
+	var n uint64
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			return 0, fmt.Errorf("'%d' is negative", v)
+		}
+		n = uint64(v)
+	case uint64:
+		n = v
+	case float64:
+		if v < 0 || v != math.Trunc(v) {
+			return 0, fmt.Errorf("'%v' is not an unsigned integer", v)
+		}
+		n = uint64(v)
+	case string:
+		parsed, err := strconv.ParseUint(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' is not an unsigned integer", v)
+		}
+		n = parsed
+	default:
+		return 0, fmt.Errorf("'%v' is not an unsigned integer", value)
+	}
+
+	if n > max {
+		return 0, fmt.Errorf("'%d' is larger than %d", n, max)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,87 @@
+package v4
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNewCustomOption(t *testing.T) {
+	tests := []struct {
+		name       string
+		code       int
+		optionType string
+		value      interface{}
+		data       []byte
+		wantErr    bool
+	}{
+		{name: "ip", code: 150, optionType: "ip", value: "10.0.0.1", data: []byte{10, 0, 0, 1}},
+		{name: "ip-list from string", code: 150, optionType: "ip-list", value: "10.0.0.1, 10.0.0.2",
+			data: []byte{10, 0, 0, 1, 10, 0, 0, 2}},
+		{name: "ip-list from list", code: 150, optionType: "ip-list", value: []interface{}{"10.0.0.1", "10.0.0.2"},
+			data: []byte{10, 0, 0, 1, 10, 0, 0, 2}},
+		{name: "uint8", code: 19, optionType: "uint8", value: 1, data: []byte{1}},
+		{name: "uint16 from string", code: 26, optionType: "uint16", value: "0x5dc", data: []byte{0x05, 0xdc}},
+		{name: "uint32 from float", code: 24, optionType: "uint32", value: float64(600), data: []byte{0, 0, 2, 0x58}},
+		{name: "string", code: 66, optionType: "string", value: "tftp.example.com", data: []byte("tftp.example.com")},
+		{name: "hex with colons", code: 43, optionType: "hex", value: "01:04:c0:a8:00:01", data: []byte{1, 4, 0xc0, 0xa8, 0, 1}},
+		{name: "bool", code: 19, optionType: "bool", value: true, data: []byte{1}},
+
+		{name: "IPv6 as ip", code: 150, optionType: "ip", value: "2001:db8::1", wantErr: true},
+		{name: "empty ip-list", code: 150, optionType: "ip-list", value: []interface{}{}, wantErr: true},
+		{name: "uint8 too large", code: 19, optionType: "uint8", value: 256, wantErr: true},
+		{name: "negative", code: 26, optionType: "uint16", value: -1, wantErr: true},
+		{name: "fraction", code: 24, optionType: "uint32", value: 1.5, wantErr: true},
+		{name: "invalid hex", code: 43, optionType: "hex", value: "xyz", wantErr: true},
+		{name: "unknown type", code: 150, optionType: "float", value: 1.5, wantErr: true},
+		{name: "too long", code: 150, optionType: "string", value: strings.Repeat("a", 256), wantErr: true},
+		{name: "code too small", code: 0, optionType: "uint8", value: 1, wantErr: true},
+		{name: "code too large", code: 255, optionType: "uint8", value: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := NewCustomOption(tt.code, tt.optionType, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got % x", opt.Data)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if int(opt.Code) != tt.code || !bytes.Equal(opt.Data, tt.data) {
+				t.Errorf("got option %d with % x, want option %d with % x", opt.Code, opt.Data, tt.code, tt.data)
+			}
+		})
+	}
+}
+
+func TestNewCustomOptionReservedCodes(t *testing.T) {
+	// The lease times, the Client FQDN, the Authentication and the Forcerenew Nonce Capable options among others
+	// are managed by the server.
+	for _, code := range []int{50, 51, 52, 53, 54, 55, 57, 58, 59, 61, 81, 82, 90, 145} {
+		if _, err := NewCustomOption(code, "uint8", 1); err == nil {
+			t.Errorf("expected option %d to be reserved", code)
+		}
+	}
+}
+
+func TestMergeCustomOptions(t *testing.T) {
+	options := []CustomOption{{Code: 1, Data: []byte{1}}, {Code: 2, Data: []byte{2}}}
+	additional := []CustomOption{{Code: 2, Data: []byte{3}}, {Code: 4, Data: []byte{4}}}
+
+	merged := MergeCustomOptions(options, additional)
+
+	want := []CustomOption{{Code: 1, Data: []byte{1}}, {Code: 2, Data: []byte{3}}, {Code: 4, Data: []byte{4}}}
+	if len(merged) != len(want) {
+		t.Fatalf("got %v, want %v", merged, want)
+	}
+	for i := range want {
+		if merged[i].Code != want[i].Code || !bytes.Equal(merged[i].Data, want[i].Data) {
+			t.Errorf("got %v, want %v", merged, want)
+		}
+	}
+}
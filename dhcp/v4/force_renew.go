@@ -0,0 +1,132 @@
+package v4
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"errors"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This implements the DHCP reconfigure extension
+// https://tools.ietf.org/html/rfc3203
+// authenticated with the Forcerenew Nonce
+// https://tools.ietf.org/html/rfc6704
+
+const MessageTypeForceRenew dhcpv4.MessageType = 9
+
+const OptionForcerenewNonceCapable dhcpv4.OptionCode = 145
+
+const (
+	// AuthProtocolForcerenewNonce is the Forcerenew Nonce Authentication protocol.
+	AuthProtocolForcerenewNonce uint8 = 3
+	// AuthAlgorithmHMACMD5 signs the message with HMAC-MD5, keyed with the nonce.
+	AuthAlgorithmHMACMD5 uint8 = 1
+	// AuthRDMMonotonic requires the replay detection to increase monotonically.
+	AuthRDMMonotonic uint8 = 0
+
+	// authInfoNonceValue is the type of the authentication information that carries the nonce.
+	authInfoNonceValue uint8 = 1
+	// authInfoHMACMD5Digest is the type of the authentication information that carries the digest.
+	authInfoHMACMD5Digest uint8 = 2
+)
+
+// ForceRenewNonceSize is the size of the nonce and of the HMAC-MD5 digest.
+const ForceRenewNonceSize = md5.Size
+
+// magicCookieEnd is where the options of a serialized message start.
+const magicCookieEnd = 240
+
+// IsForceRenewNonceCapable returns true if the data of the client's Forcerenew Nonce Capable option
+// lists HMAC-MD5, which is the only algorithm this server supports.
+// See https://tools.ietf.org/html/rfc6704#section-3.1
+func IsForceRenewNonceCapable(data []byte) bool {
+	for _, algorithm := range data {
+		if algorithm == AuthAlgorithmHMACMD5 {
+			return true
+		}
+	}
+	return false
+}
+
+// NewForceRenewNonce returns a random nonce, which is sent to the client in the DHCPACK.
+func NewForceRenewNonce() ([]byte, error) {
+	nonce := make([]byte, ForceRenewNonceSize)
+	_, err := rand.Read(nonce)
+	return nonce, err
+}
+
+// ForceRenewNonceOption returns the Authentication option that hands the nonce to the client.
+// See https://tools.ietf.org/html/rfc6704#section-3.2
+func ForceRenewNonceOption(nonce []byte) *OptAuthentication {
+	return forceRenewAuthentication(authInfoNonceValue, nonce)
+}
+
+// ForceRenewDigestOption returns the Authentication option of a DHCPFORCERENEW.
+// The digest is left empty, it is calculated by SignForceRenew once the message is serialized.
+// See https://tools.ietf.org/html/rfc6704#section-3.3
+func ForceRenewDigestOption() *OptAuthentication {
+	return forceRenewAuthentication(authInfoHMACMD5Digest, make([]byte, ForceRenewNonceSize))
+}
+
+func forceRenewAuthentication(infoType uint8, value []byte) *OptAuthentication {
+	return &OptAuthentication{
+		Protocol:  AuthProtocolForcerenewNonce,
+		Algorithm: AuthAlgorithmHMACMD5,
+		RDM:       AuthRDMMonotonic,
+		// The time increases monotonically, even across restarts of the server.
+		ReplayDetection: uint64(time.Now().UnixNano()),
+		Info:            append([]byte{infoType}, value...),
+	}
+}
+
+// SignForceRenew fills the digest of the Authentication option of the serialized message with the HMAC-MD5
+// of the whole message, keyed with the nonce. The digest must still be zero and 'hops' and 'giaddr' are zeroed.
+// See https://tools.ietf.org/html/rfc3118#section-5.1
+func SignForceRenew(p []byte, nonce []byte) error {
+	digest, err := findDigest(p)
+	if err != nil {
+		return err
+	}
+
+	signed := make([]byte, len(p))
+	copy(signed, p)
+	signed[3] = 0                           // hops
+	copy(signed[24:28], []byte{0, 0, 0, 0}) // giaddr
+
+	mac := hmac.New(md5.New, nonce)
+	mac.Write(signed)
+	copy(p[digest:digest+ForceRenewNonceSize], mac.Sum(nil))
+
+	return nil
+}
+
+// findDigest returns the offset of the digest in the Authentication option of the serialized message.
+func findDigest(p []byte) (int, error) {
+	for offset := magicCookieEnd; offset < len(p); {
+		code := dhcpv4.OptionCode(p[offset])
+		if code == dhcpv4.OptionPad {
+			offset++
+			continue
+		}
+		if code == dhcpv4.OptionEnd || offset+1 >= len(p) {
+			break
+		}
+
+		length := int(p[offset+1])
+		if code == dhcpv4.OptionAuthentication {
+			// code, length, protocol, algorithm, RDM, replay detection and the type of the information
+			digest := offset + 14
+			if length != 12+ForceRenewNonceSize || digest+ForceRenewNonceSize > len(p) || p[digest-1] != authInfoHMACMD5Digest {
+				break
+			}
+			return digest, nil
+		}
+
+		offset += 2 + length
+	}
+
+	return 0, errors.New("the message has no Authentication option for the digest")
+}
@@ -0,0 +1,81 @@
+package v4
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// forceRenewMessage returns a serialized message with the given options, relayed by a relay agent.
+func forceRenewMessage(options ...dhcpv4.Option) []byte {
+	p := make([]byte, magicCookieEnd)
+	p[0] = 2                            // op: BOOTREPLY
+	p[3] = 1                            // hops
+	copy(p[24:28], []byte{10, 0, 0, 1}) // giaddr
+	copy(p[236:240], []byte{99, 130, 83, 99})
+
+	for _, opt := range options {
+		p = append(p, opt.ToBytes()...)
+	}
+	return append(p, byte(dhcpv4.OptionEnd))
+}
+
+func TestSignForceRenew(t *testing.T) {
+	nonce := []byte("0123456789abcdef")
+	p := forceRenewMessage(genericOption(dhcpv4.OptionDHCPMessageType, 1), ForceRenewDigestOption())
+	unsigned := append([]byte{}, p...)
+
+	if err := SignForceRenew(p, nonce); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// RFC3118, Section 5.1: The digest covers the message with a zero digest, 'hops' and 'giaddr'.
+	digested := append([]byte{}, unsigned...)
+	digested[3] = 0
+	copy(digested[24:28], []byte{0, 0, 0, 0})
+	mac := hmac.New(md5.New, nonce)
+	mac.Write(digested)
+	want := mac.Sum(nil)
+
+	// The digest follows the message type (3 bytes) and the header of the Authentication option (14 bytes).
+	digest := magicCookieEnd + 3 + 14
+	if got := p[digest : digest+ForceRenewNonceSize]; !bytes.Equal(got, want) {
+		t.Errorf("got digest % x, want % x", got, want)
+	}
+
+	// Apart from the digest, the message is sent as it is.
+	if !bytes.Equal(p[:digest], unsigned[:digest]) || !bytes.Equal(p[digest+ForceRenewNonceSize:], unsigned[digest+ForceRenewNonceSize:]) {
+		t.Error("expected only the digest to change")
+	}
+}
+
+func TestSignForceRenewWithoutDigest(t *testing.T) {
+	tests := []struct {
+		name string
+		p    []byte
+	}{
+		{name: "no Authentication option", p: forceRenewMessage(genericOption(dhcpv4.OptionDHCPMessageType, 1))},
+		{name: "nonce instead of digest", p: forceRenewMessage(ForceRenewNonceOption(make([]byte, ForceRenewNonceSize)))},
+		{name: "truncated", p: forceRenewMessage(ForceRenewDigestOption())[:magicCookieEnd+10]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SignForceRenew(tt.p, []byte("0123456789abcdef")); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestIsForceRenewNonceCapable(t *testing.T) {
+	if !IsForceRenewNonceCapable([]byte{2, AuthAlgorithmHMACMD5}) {
+		t.Error("expected a client listing HMAC-MD5 to be capable")
+	}
+	if IsForceRenewNonceCapable([]byte{2}) || IsForceRenewNonceCapable(nil) {
+		t.Error("expected a client not listing HMAC-MD5 not to be capable")
+	}
+}
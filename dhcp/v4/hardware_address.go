@@ -0,0 +1,45 @@
+package v4
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// This implements the handling of hardware types other than Ethernet,
+// in particular of IP over InfiniBand
+// https://tools.ietf.org/html/rfc4390
+
+// maxHwAddrLen is the size of the 'chaddr' field.
+const maxHwAddrLen = 16
+
+// ClientHwAddr returns the client hardware address of the message, i.e. the first 'hlen' bytes of 'chaddr'.
+// It is empty for InfiniBand, as the 20 byte link-layer address does not fit into 'chaddr'.
+// See https://tools.ietf.org/html/rfc4390#section-2.1
+func ClientHwAddr(in *dhcpv4.DHCPv4) net.HardwareAddr {
+	if IsInfiniBand(in) {
+		return net.HardwareAddr{}
+	}
+
+	hwAddr := in.ClientHwAddr()
+	hwAddrLen := int(in.HwAddrLen())
+	if hwAddrLen > maxHwAddrLen {
+		hwAddrLen = maxHwAddrLen
+	}
+
+	return net.HardwareAddr(hwAddr[:hwAddrLen])
+}
+
+// IsInfiniBand returns true if the client is attached to an InfiniBand link.
+func IsInfiniBand(in *dhcpv4.DHCPv4) bool {
+	return in.HwType() == iana.HwTypeInfiniband
+}
+
+// CanUnicastToHwAddr returns true if a reply can be sent to the client's hardware address
+// before the client has an IP, which is only the case on Ethernet links.
+// All other clients, in particular the ones on InfiniBand links, are answered by broadcast.
+// See https://tools.ietf.org/html/rfc4390#section-2.2
+func CanUnicastToHwAddr(in *dhcpv4.DHCPv4) bool {
+	return in.HwType() == iana.HwTypeEthernet && in.HwAddrLen() == 6
+}
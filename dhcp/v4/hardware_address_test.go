@@ -0,0 +1,74 @@
+package v4
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+func newMessage(t *testing.T, hwType iana.HwTypeType, hwAddrLen uint8, hwAddr []byte) *dhcpv4.DHCPv4 {
+	t.Helper()
+
+	msg, err := dhcpv4.New()
+	if err != nil {
+		t.Fatalf("can't create the message: %s", err)
+	}
+	msg.SetHwType(hwType)
+	msg.SetHwAddrLen(hwAddrLen)
+	msg.SetClientHwAddr(hwAddr)
+	return msg
+}
+
+func TestHardwareAddress(t *testing.T) {
+	ethernetAddr := []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	longAddr := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	tests := []struct {
+		name           string
+		hwType         iana.HwTypeType
+		hwAddrLen      uint8
+		hwAddr         []byte
+		wantHwAddr     string
+		wantInfiniBand bool
+		wantUnicast    bool
+	}{
+		{
+			name: "Ethernet", hwType: iana.HwTypeEthernet, hwAddrLen: 6, hwAddr: ethernetAddr,
+			wantHwAddr: "00:11:22:33:44:55", wantUnicast: true,
+		},
+		{
+			// RFC4390, Section 2.1: 'hlen' is 0 and 'chaddr' is zeroed.
+			name: "InfiniBand", hwType: iana.HwTypeInfiniband, hwAddrLen: 0,
+			wantInfiniBand: true,
+		},
+		{
+			name: "InfiniBand with a wrong 'hlen'", hwType: iana.HwTypeInfiniband, hwAddrLen: 20, hwAddr: longAddr,
+			wantInfiniBand: true,
+		},
+		{
+			name: "'hlen' longer than 'chaddr'", hwType: 6, hwAddrLen: 20, hwAddr: longAddr,
+			wantHwAddr: "01:02:03:04:05:06:07:08:09:0a:0b:0c:0d:0e:0f:10",
+		},
+		{
+			name: "Ethernet type with another 'hlen'", hwType: iana.HwTypeEthernet, hwAddrLen: 8, hwAddr: ethernetAddr,
+			wantHwAddr: "00:11:22:33:44:55:00:00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newMessage(t, tt.hwType, tt.hwAddrLen, tt.hwAddr)
+
+			if got := ClientHwAddr(msg).String(); got != tt.wantHwAddr {
+				t.Errorf("got hardware address '%s', want '%s'", got, tt.wantHwAddr)
+			}
+			if got := IsInfiniBand(msg); got != tt.wantInfiniBand {
+				t.Errorf("got InfiniBand %v, want %v", got, tt.wantInfiniBand)
+			}
+			if got := CanUnicastToHwAddr(msg); got != tt.wantUnicast {
+				t.Errorf("got unicast %v, want %v", got, tt.wantUnicast)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package v4
+
+import (
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This implements DHCPv4 Leasequery
+// https://tools.ietf.org/html/rfc4388
+// and DHCPv4 Bulk Leasequery
+// https://tools.ietf.org/html/rfc6926
+
+const (
+	MessageTypeLeaseQuery       dhcpv4.MessageType = 10
+	MessageTypeLeaseUnassigned  dhcpv4.MessageType = 11
+	MessageTypeLeaseUnknown     dhcpv4.MessageType = 12
+	MessageTypeLeaseActive      dhcpv4.MessageType = 13
+	MessageTypeBulkLeaseQuery   dhcpv4.MessageType = 14
+	MessageTypeLeaseQueryDone   dhcpv4.MessageType = 15
+	MessageTypeLeaseQueryStatus dhcpv4.MessageType = 17
+)
+
+const (
+	OptionClientLastTransactionTime dhcpv4.OptionCode = 91
+	OptionStatusCode                dhcpv4.OptionCode = 151
+	OptionBaseTime                  dhcpv4.OptionCode = 152
+	OptionQueryStartTime            dhcpv4.OptionCode = 154
+	OptionQueryEndTime              dhcpv4.OptionCode = 155
+)
+
+// https://tools.ietf.org/html/rfc6925#section-3
+const RelayAgentRelayID RelayAgentSubOptionCode = 12
+
+// LeaseQueryStatus is the status of a bulk leasequery.
+// See https://tools.ietf.org/html/rfc6926#section-6.2.2
+type LeaseQueryStatus uint8
+
+const (
+	LeaseQueryStatusSuccess         LeaseQueryStatus = 0
+	LeaseQueryStatusUnspecFail      LeaseQueryStatus = 1
+	LeaseQueryStatusQueryTerminated LeaseQueryStatus = 2
+	LeaseQueryStatusMalformedQuery  LeaseQueryStatus = 3
+	LeaseQueryStatusNotAllowed      LeaseQueryStatus = 4
+)
+
+// LeaseV4 is an active lease, as it is reported to leasequeries.
+type LeaseV4 struct {
+	Info ClientInfoV4
+	// Remaining is the time until the lease expires.
+	Remaining time.Duration
+}
+
+// SinceLastTransaction returns how long ago the client last renewed the lease.
+func (l *LeaseV4) SinceLastTransaction() time.Duration {
+	if l.Info.Timeouts.Lease == InfiniteLease || l.Remaining > l.Info.Timeouts.Lease {
+		return 0
+	}
+	return l.Info.Timeouts.Lease - l.Remaining
+}
+
+// StatusCodeOption returns the Status Code option with the status and the message.
+// See https://tools.ietf.org/html/rfc6926#section-6.2.2
+func StatusCodeOption(status LeaseQueryStatus, message string) dhcpv4.Option {
+	return &dhcpv4.OptionGeneric{OptionCode: OptionStatusCode, Data: append([]byte{byte(status)}, message...)}
+}
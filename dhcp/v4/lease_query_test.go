@@ -0,0 +1,41 @@
+package v4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSinceLastTransaction(t *testing.T) {
+	tests := []struct {
+		name      string
+		lease     time.Duration
+		remaining time.Duration
+		want      time.Duration
+	}{
+		{name: "just renewed", lease: time.Hour, remaining: time.Hour, want: 0},
+		{name: "renewed a while ago", lease: time.Hour, remaining: 45 * time.Minute, want: 15 * time.Minute},
+		{name: "longer than granted", lease: time.Hour, remaining: 2 * time.Hour, want: 0},
+		{name: "infinite", lease: InfiniteLease, remaining: time.Hour, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lease := LeaseV4{Remaining: tt.remaining}
+			lease.Info.Timeouts.Lease = tt.lease
+
+			if got := lease.SinceLastTransaction(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusCodeOption(t *testing.T) {
+	got := StatusCodeOption(LeaseQueryStatusMalformedQuery, "exactly one query is expected").ToBytes()
+	want := append([]byte{151, 30, 3}, "exactly one query is expected"...)
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,189 @@
+package v4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// neighborTTL is how long a resolved MAC is remembered.
+const neighborTTL = 1 * time.Minute
+
+// failureTTL is how long a failed lookup is remembered, so that retransmitting clients don't block the server
+// with a lookup each.
+const failureTTL = 10 * time.Second
+
+// arpTimeout is how long to wait for the answer to an ARP request.
+const arpTimeout = 500 * time.Millisecond
+
+// routeTable is where Linux exposes the IPv4 routing table.
+const routeTable = "/proc/net/route"
+
+type neighbor struct {
+	hwAddr  net.HardwareAddr
+	err     error
+	expires time.Time
+}
+
+// NeighborCache resolves the MAC of the next hop towards an IP with ARP and remembers it for a while.
+// See https://tools.ietf.org/html/rfc826 and https://tools.ietf.org/html/rfc1122#section-2.3.2.1
+type NeighborCache struct {
+	iface    net.Interface
+	senderIP net.IP
+
+	mutex     sync.Mutex
+	neighbors map[string]neighbor
+}
+
+// NewNeighborCache creates a cache for the interface, whose ARP requests are sent from senderIP.
+func NewNeighborCache(iface net.Interface, senderIP net.IP) *NeighborCache {
+	return &NeighborCache{
+		iface:     iface,
+		senderIP:  senderIP,
+		neighbors: make(map[string]neighbor),
+	}
+}
+
+// Resolve returns the MAC to send a packet for the IP to.
+// That is the MAC of the host itself if it is on the link, and the MAC of the gateway towards it otherwise.
+// The result is remembered per IP, so the routing table is not read again until it expires.
+func (n *NeighborCache) Resolve(ip net.IP) (net.HardwareAddr, error) {
+	key := ip.String()
+
+	n.mutex.Lock()
+	cached, ok := n.neighbors[key]
+	n.mutex.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.hwAddr, cached.err
+	}
+
+	hwAddr, err := n.lookup(ip)
+
+	ttl := neighborTTL
+	if err != nil {
+		ttl = failureTTL
+	}
+
+	n.mutex.Lock()
+	n.neighbors[key] = neighbor{hwAddr: hwAddr, err: err, expires: time.Now().Add(ttl)}
+	n.mutex.Unlock()
+
+	return hwAddr, err
+}
+
+// lookup resolves the MAC of the next hop towards the IP with ARP.
+func (n *NeighborCache) lookup(ip net.IP) (net.HardwareAddr, error) {
+	nextHop, err := n.nextHop(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	hwAddr, err := requestARP(n.iface, n.senderIP, nextHop, arpTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve the MAC of '%s': %s", nextHop, err)
+	}
+
+	return hwAddr, nil
+}
+
+// nextHop returns the IP itself if it is in one of the networks of the interface,
+// and the gateway of the most specific route of the interface towards it otherwise.
+func (n *NeighborCache) nextHop(ip net.IP) (net.IP, error) {
+	addrs, err := n.iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(ip) {
+			return ip, nil
+		}
+	}
+
+	gateway, err := routeGateway(n.iface.Name, ip)
+	if err != nil {
+		return nil, err
+	}
+
+	return gateway, nil
+}
+
+// routeGateway looks up the gateway of the most specific route of the interface that matches the IP.
+func routeGateway(ifaceName string, ip net.IP) (net.IP, error) {
+	file, err := os.Open(routeTable)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseRouteTable(file, ifaceName, ip)
+}
+
+// parseRouteTable finds the gateway of the most specific route of the interface that matches the IP
+// in a route table in the format of /proc/net/route.
+// The addresses in the route table are hex encoded in host byte order, which is little endian on all supported hosts.
+func parseRouteTable(routes io.Reader, ifaceName string, ip net.IP) (net.IP, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return nil, fmt.Errorf("'%s' is not an IPv4", ip)
+	}
+
+	var gateway net.IP
+	bestOnes := -1
+
+	scanner := bufio.NewScanner(routes)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[0] != ifaceName {
+			continue
+		}
+
+		destination, err1 := parseRouteIP(fields[1])
+		hop, err2 := parseRouteIP(fields[2])
+		mask, err3 := parseRouteIP(fields[7])
+		if err1 != nil || err2 != nil || err3 != nil {
+			continue
+		}
+
+		route := net.IPNet{IP: destination, Mask: net.IPMask(mask)}
+		ones, _ := route.Mask.Size()
+		if route.Contains(ip4) && ones > bestOnes {
+			gateway, bestOnes = hop, ones
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if gateway == nil {
+		return nil, fmt.Errorf("no route to '%s' on '%s'", ip, ifaceName)
+	} else if gateway.Equal(net.IPv4zero) {
+		// A route without gateway means the network is directly attached.
+		return ip4, nil
+	}
+
+	return gateway, nil
+}
+
+func parseRouteIP(field string) (net.IP, error) {
+	raw, err := hex.DecodeString(field)
+	if err != nil {
+		return nil, err
+	} else if len(raw) != 4 {
+		return nil, fmt.Errorf("'%s' is not an IPv4", field)
+	}
+
+	ip := make(net.IP, 4)
+	binary.BigEndian.PutUint32(ip, binary.LittleEndian.Uint32(raw))
+	return ip, nil
+}
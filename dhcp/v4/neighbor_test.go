@@ -0,0 +1,96 @@
+package v4
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The addresses are hex encoded in little endian, e.g. '0100000A' is 10.0.0.1.
+const testRouteTable = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0100000A	0003	0	0	0	00000000	0	0	0
+eth0	0000000A	00000000	0001	0	0	0	00FFFFFF	0	0	0
+eth0	0000140A	0200000A	0003	0	0	0	0000FFFF	0	0	0
+eth1	0000000B	00000000	0001	0	0	0	000000FF	0	0	0
+eth1	invalid	00000000	0001	0	0	0	00000000	0	0	0
+`
+
+func TestParseRouteTable(t *testing.T) {
+	tests := []struct {
+		iface   string
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{iface: "eth0", ip: "10.0.0.5", want: "10.0.0.5"},
+		{iface: "eth0", ip: "10.20.1.1", want: "10.0.0.2"},
+		{iface: "eth0", ip: "192.0.2.1", want: "10.0.0.1"},
+		{iface: "eth0", ip: "11.0.0.1", want: "10.0.0.1"},
+		{iface: "eth1", ip: "11.0.0.1", want: "11.0.0.1"},
+		{iface: "eth1", ip: "192.0.2.1", wantErr: true},
+		{iface: "eth2", ip: "10.0.0.5", wantErr: true},
+		{iface: "eth0", ip: "2001:db8::1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.iface+" "+tt.ip, func(t *testing.T) {
+			gateway, err := parseRouteTable(strings.NewReader(testRouteTable), tt.iface, net.ParseIP(tt.ip))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got '%s'", gateway)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !gateway.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("got '%s', want '%s'", gateway, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRouteIP(t *testing.T) {
+	tests := []struct {
+		field   string
+		want    string
+		wantErr bool
+	}{
+		{field: "0100000A", want: "10.0.0.1"},
+		{field: "00FFFFFF", want: "255.255.255.0"},
+		{field: "00000000", want: "0.0.0.0"},
+		{field: "0100", wantErr: true},
+		{field: "invalid!", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		ip, err := parseRouteIP(tt.field)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("expected an error for '%s', got '%s'", tt.field, ip)
+			}
+		} else if err != nil || !ip.Equal(net.ParseIP(tt.want)) {
+			t.Errorf("got '%s' (%v) for '%s', want '%s'", ip, err, tt.field, tt.want)
+		}
+	}
+}
+
+func TestNeighborCacheRemembers(t *testing.T) {
+	n := NewNeighborCache(net.Interface{Name: "eth0"}, net.IPv4(10, 0, 0, 254))
+	hwAddr := net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	errFailed := errors.New("no answer")
+
+	n.neighbors["10.0.0.1"] = neighbor{hwAddr: hwAddr, expires: time.Now().Add(neighborTTL)}
+	n.neighbors["10.0.0.2"] = neighbor{err: errFailed, expires: time.Now().Add(failureTTL)}
+
+	if got, err := n.Resolve(net.IPv4(10, 0, 0, 1)); err != nil || got.String() != hwAddr.String() {
+		t.Errorf("got '%s' (%v), want the remembered '%s'", got, err, hwAddr)
+	}
+	if _, err := n.Resolve(net.IPv4(10, 0, 0, 2)); err != errFailed {
+		t.Errorf("got error '%v', want the remembered failure", err)
+	}
+}
@@ -0,0 +1,68 @@
+package v4
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Authentication option
+// https://tools.ietf.org/html/rfc3118
+
+// OptAuthentication represents the Authentication option.
+type OptAuthentication struct {
+	Protocol        uint8
+	Algorithm       uint8
+	RDM             uint8
+	ReplayDetection uint64
+	Info            []byte
+}
+
+// ParseOptAuthentication constructs an OptAuthentication struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptAuthentication(data []byte) (*OptAuthentication, error) {
+	// Should at least have code, length, protocol, algorithm, RDM and replay detection.
+	if len(data) < 13 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != dhcpv4.OptionAuthentication {
+		return nil, fmt.Errorf("expected option %v, got %v instead", dhcpv4.OptionAuthentication, code)
+	}
+	length := int(data[1])
+	if length < 11 || len(data) < 2+length {
+		return nil, fmt.Errorf("expected length >= 11, got %v instead", length)
+	}
+	return &OptAuthentication{
+		Protocol:        data[2],
+		Algorithm:       data[3],
+		RDM:             data[4],
+		ReplayDetection: binary.BigEndian.Uint64(data[5:13]),
+		Info:            data[13 : 2+length],
+	}, nil
+}
+
+// Code returns the option code.
+func (o *OptAuthentication) Code() dhcpv4.OptionCode {
+	return dhcpv4.OptionAuthentication
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+func (o *OptAuthentication) ToBytes() []byte {
+	serializedOpt := []byte{byte(o.Code()), byte(o.Length()), o.Protocol, o.Algorithm, o.RDM}
+	serializedOpt = append(serializedOpt, make([]byte, 8)...)
+	binary.BigEndian.PutUint64(serializedOpt[5:13], o.ReplayDetection)
+	return append(serializedOpt, o.Info...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptAuthentication) String() string {
+	return fmt.Sprintf("Authentication -> protocol %v, algorithm %v, replay detection %v", o.Protocol, o.Algorithm, o.ReplayDetection)
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptAuthentication) Length() int {
+	return 11 + len(o.Info)
+}
@@ -0,0 +1,171 @@
+package v4
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Classless Static Route option
+// https://tools.ietf.org/html/rfc3442
+// and Microsoft's equivalent, which uses the same encoding
+// https://msdn.microsoft.com/en-us/library/cc227276.aspx
+
+const (
+	OptionClasslessStaticRoute   dhcpv4.OptionCode = 121
+	OptionMSClasslessStaticRoute dhcpv4.OptionCode = 249
+)
+
+// Route is a static route to Destination via Router.
+type Route struct {
+	Destination *net.IPNet
+	Router      net.IP
+}
+
+// ParseRoute parses a route from a destination in CIDR notation and the IPv4 of the router.
+func ParseRoute(destination, router string) (Route, error) {
+	_, dst, err := net.ParseCIDR(destination)
+	if err != nil || dst.IP.To4() == nil {
+		return Route{}, fmt.Errorf("invalid IPv4 route destination '%s'", destination)
+	}
+
+	gw := net.ParseIP(router).To4()
+	if gw == nil {
+		return Route{}, fmt.Errorf("invalid IPv4 router '%s'", router)
+	}
+
+	return Route{Destination: dst, Router: gw}, nil
+}
+
+// IsDefault returns true if the route's destination is 0.0.0.0/0.
+func (r Route) IsDefault() bool {
+	ones, _ := r.Destination.Mask.Size()
+	return ones == 0
+}
+
+func (r Route) String() string {
+	return fmt.Sprintf("%s via %s", r.Destination, r.Router)
+}
+
+// bytes returns the route encoded according to RFC3442, Section 3:
+// The prefix length, the significant octets of the destination and the router.
+func (r Route) bytes() []byte {
+	ones, _ := r.Destination.Mask.Size()
+	significant := (ones + 7) / 8
+
+	data := []byte{byte(ones)}
+	data = append(data, r.Destination.IP.To4()[:significant]...)
+	return append(data, r.Router.To4()...)
+}
+
+// WithDefaultRoute returns the routes with an additional default route via the first router,
+// unless the routes already contain a default route or there is no router.
+// Clients ignore the Router option when they receive static routes.
+// See https://tools.ietf.org/html/rfc3442#page-5
+func WithDefaultRoute(routes []Route, routers []net.IP) []Route {
+	if len(routers) == 0 {
+		return routes
+	}
+
+	for _, route := range routes {
+		if route.IsDefault() {
+			return routes
+		}
+	}
+
+	defaultRoute := Route{
+		Destination: &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+		Router:      routers[0],
+	}
+	return append(append([]Route{}, routes...), defaultRoute)
+}
+
+// OptClasslessStaticRoute represents the Classless Static Route option.
+// OptionCode is either OptionClasslessStaticRoute or OptionMSClasslessStaticRoute.
+type OptClasslessStaticRoute struct {
+	OptionCode dhcpv4.OptionCode
+	Routes     []Route
+}
+
+// ParseOptClasslessStaticRoute constructs an OptClasslessStaticRoute struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptClasslessStaticRoute(data []byte) (*OptClasslessStaticRoute, error) {
+	// Should at least have code, length, and one default route.
+	if len(data) < 7 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != OptionClasslessStaticRoute && code != OptionMSClasslessStaticRoute {
+		return nil, fmt.Errorf("expected option %v or %v, got %v instead", OptionClasslessStaticRoute, OptionMSClasslessStaticRoute, code)
+	}
+	length := int(data[1])
+	if len(data) < 2+length {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+
+	opt := OptClasslessStaticRoute{OptionCode: code}
+	routes := data[2 : 2+length]
+	for len(routes) > 0 {
+		ones := int(routes[0])
+		if ones > 32 {
+			return nil, fmt.Errorf("invalid prefix length %d", ones)
+		}
+		significant := (ones + 7) / 8
+		if len(routes) < 1+significant+4 {
+			return nil, dhcpv4.ErrShortByteStream
+		}
+
+		destination := make(net.IP, net.IPv4len)
+		copy(destination, routes[1:1+significant])
+		router := make(net.IP, net.IPv4len)
+		copy(router, routes[1+significant:1+significant+4])
+
+		opt.Routes = append(opt.Routes, Route{
+			Destination: &net.IPNet{IP: destination, Mask: net.CIDRMask(ones, 32)},
+			Router:      router,
+		})
+		routes = routes[1+significant+4:]
+	}
+	return &opt, nil
+}
+
+// Code returns the option code.
+func (o *OptClasslessStaticRoute) Code() dhcpv4.OptionCode {
+	return o.OptionCode
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+func (o *OptClasslessStaticRoute) ToBytes() []byte {
+	serializedOpt := []byte{byte(o.Code()), byte(o.Length())}
+	return append(serializedOpt, o.data()...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptClasslessStaticRoute) String() string {
+	routes := make([]string, 0, len(o.Routes))
+	for _, route := range o.Routes {
+		routes = append(routes, route.String())
+	}
+	return fmt.Sprintf("Classless Static Route -> %v", strings.Join(routes, ", "))
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptClasslessStaticRoute) Length() int {
+	return len(o.data())
+}
+
+// data returns the encoded routes. Routes that exceed the maximum option length of 255 bytes are left out.
+func (o *OptClasslessStaticRoute) data() []byte {
+	var data []byte
+	for _, route := range o.Routes {
+		encoded := route.bytes()
+		if len(data)+len(encoded) > 255 {
+			break
+		}
+		data = append(data, encoded...)
+	}
+	return data
+}
@@ -0,0 +1,124 @@
+package v4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func mustParseRoute(t *testing.T, destination, router string) Route {
+	t.Helper()
+
+	route, err := ParseRoute(destination, router)
+	if err != nil {
+		t.Fatalf("can't parse route: %s", err)
+	}
+	return route
+}
+
+func TestRouteBytes(t *testing.T) {
+	// The examples of RFC3442, Section 3.
+	tests := []struct {
+		destination string
+		data        []byte
+	}{
+		{"0.0.0.0/0", []byte{0, 10, 0, 0, 1}},
+		{"10.0.0.0/8", []byte{8, 10, 10, 0, 0, 1}},
+		{"10.17.0.0/16", []byte{16, 10, 17, 10, 0, 0, 1}},
+		{"10.27.129.0/24", []byte{24, 10, 27, 129, 10, 0, 0, 1}},
+		{"10.229.0.128/25", []byte{25, 10, 229, 0, 128, 10, 0, 0, 1}},
+		{"10.198.122.47/32", []byte{32, 10, 198, 122, 47, 10, 0, 0, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			route := mustParseRoute(t, tt.destination, "10.0.0.1")
+			if data := route.bytes(); !bytes.Equal(data, tt.data) {
+				t.Errorf("got % x, want % x", data, tt.data)
+			}
+		})
+	}
+}
+
+func TestParseRouteInvalid(t *testing.T) {
+	tests := []struct {
+		destination string
+		router      string
+	}{
+		{"10.0.0.0", "10.0.0.1"},
+		{"2001:db8::/32", "10.0.0.1"},
+		{"10.0.0.0/8", "2001:db8::1"},
+		{"10.0.0.0/8", "router"},
+	}
+
+	for _, tt := range tests {
+		if _, err := ParseRoute(tt.destination, tt.router); err == nil {
+			t.Errorf("expected an error for '%s' via '%s'", tt.destination, tt.router)
+		}
+	}
+}
+
+func TestOptClasslessStaticRouteRoundTrip(t *testing.T) {
+	routes := []Route{
+		mustParseRoute(t, "10.17.0.0/16", "10.0.0.1"),
+		mustParseRoute(t, "10.229.0.128/25", "10.0.0.2"),
+		mustParseRoute(t, "0.0.0.0/0", "10.0.0.3"),
+	}
+
+	for _, code := range []dhcpv4.OptionCode{OptionClasslessStaticRoute, OptionMSClasslessStaticRoute} {
+		opt := OptClasslessStaticRoute{OptionCode: code, Routes: routes}
+
+		parsed, err := ParseOptClasslessStaticRoute(opt.ToBytes())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if parsed.Code() != code {
+			t.Errorf("got code %v, want %v", parsed.Code(), code)
+		}
+		if len(parsed.Routes) != len(routes) {
+			t.Fatalf("got %d routes, want %d", len(parsed.Routes), len(routes))
+		}
+		for i, route := range parsed.Routes {
+			if route.String() != routes[i].String() {
+				t.Errorf("got route '%s', want '%s'", route, routes[i])
+			}
+		}
+	}
+}
+
+func TestOptClasslessStaticRouteTooLong(t *testing.T) {
+	// 29 routes of 9 bytes exceed the 255 bytes of an option, the last one is left out.
+	var routes []Route
+	for i := 0; i < 29; i++ {
+		routes = append(routes, Route{
+			Destination: &net.IPNet{IP: net.IPv4(10, byte(i), 0, 1).To4(), Mask: net.CIDRMask(32, 32)},
+			Router:      net.IPv4(10, 0, 0, 1),
+		})
+	}
+
+	opt := OptClasslessStaticRoute{OptionCode: OptionClasslessStaticRoute, Routes: routes}
+	if opt.Length() != 28*9 {
+		t.Errorf("got length %d, want %d", opt.Length(), 28*9)
+	}
+}
+
+func TestWithDefaultRoute(t *testing.T) {
+	routers := []net.IP{net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2)}
+	route := mustParseRoute(t, "10.17.0.0/16", "10.0.0.3")
+
+	withDefault := WithDefaultRoute([]Route{route}, routers)
+	if len(withDefault) != 2 || !withDefault[1].IsDefault() || !withDefault[1].Router.Equal(routers[0]) {
+		t.Errorf("expected a default route via the first router, got %v", withDefault)
+	}
+
+	defaultRoute := mustParseRoute(t, "0.0.0.0/0", "10.0.0.4")
+	if routes := WithDefaultRoute([]Route{defaultRoute}, routers); len(routes) != 1 {
+		t.Errorf("expected the existing default route to be kept, got %v", routes)
+	}
+
+	if routes := WithDefaultRoute([]Route{route}, nil); len(routes) != 1 {
+		t.Errorf("expected no default route without routers, got %v", routes)
+	}
+}
@@ -0,0 +1,123 @@
+package v4
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Client Fully Qualified Domain Name option
+// https://tools.ietf.org/html/rfc4702
+
+const OptionClientFQDN dhcpv4.OptionCode = 81
+
+const (
+	// FQDNFlagS indicates that the server performs the A record update.
+	FQDNFlagS uint8 = 1 << 0
+	// FQDNFlagO indicates that the server overrode the client's preference to update the A record itself.
+	FQDNFlagO uint8 = 1 << 1
+	// FQDNFlagE indicates that the domain name is in the canonical wire format.
+	FQDNFlagE uint8 = 1 << 2
+	// FQDNFlagN indicates that the server performs no updates at all.
+	FQDNFlagN uint8 = 1 << 3
+)
+
+// OptClientFQDN represents the Client FQDN option.
+type OptClientFQDN struct {
+	Flags      uint8
+	DomainName string
+}
+
+// ParseOptClientFQDN constructs an OptClientFQDN struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptClientFQDN(data []byte) (*OptClientFQDN, error) {
+	// Should at least have code, length, flags and the two RCODE fields.
+	if len(data) < 5 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != OptionClientFQDN {
+		return nil, fmt.Errorf("expected option %v, got %v instead", OptionClientFQDN, code)
+	}
+	length := int(data[1])
+	if length < 3 || len(data) < 2+length {
+		return nil, fmt.Errorf("expected length >= 3, got %v instead", length)
+	}
+
+	flags := data[2]
+	rawName := data[5 : 2+length]
+
+	if flags&FQDNFlagE == 0 {
+		// Deprecated ASCII encoding, see https://tools.ietf.org/html/rfc4702#section-2.3.1
+		return &OptClientFQDN{Flags: flags, DomainName: string(rawName)}, nil
+	}
+
+	domainName, err := decodeDomainName(rawName)
+	if err != nil {
+		return nil, err
+	}
+	return &OptClientFQDN{Flags: flags, DomainName: domainName}, nil
+}
+
+// Code returns the option code.
+func (o *OptClientFQDN) Code() dhcpv4.OptionCode {
+	return OptionClientFQDN
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+// Servers set both RCODE fields to 255, see https://tools.ietf.org/html/rfc4702#section-2.2
+func (o *OptClientFQDN) ToBytes() []byte {
+	return append([]byte{byte(o.Code()), byte(o.Length()), o.Flags, 255, 255}, o.encodedName()...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptClientFQDN) String() string {
+	return fmt.Sprintf("Client FQDN -> %v (flags %04b)", o.DomainName, o.Flags)
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptClientFQDN) Length() int {
+	return 3 + len(o.encodedName())
+}
+
+func (o *OptClientFQDN) encodedName() []byte {
+	if o.Flags&FQDNFlagE == 0 {
+		return []byte(o.DomainName)
+	}
+	return encodeDomainName(o.DomainName)
+}
+
+// encodeDomainName encodes a fully qualified domain name in the canonical wire format.
+// See https://tools.ietf.org/html/rfc1035#section-3.1
+func encodeDomainName(name string) []byte {
+	var encoded []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		encoded = append(encoded, byte(len(label)))
+		encoded = append(encoded, label...)
+	}
+	return append(encoded, 0)
+}
+
+// decodeDomainName decodes a domain name in the canonical wire format.
+// A partial name, i.e. one without the terminating zero length label, is returned without trailing dot.
+func decodeDomainName(data []byte) (string, error) {
+	var labels []string
+	for len(data) > 0 {
+		length := int(data[0])
+		if length == 0 {
+			return strings.Join(labels, ".") + ".", nil
+		}
+		if length > 63 || len(data) < 1+length {
+			return "", fmt.Errorf("invalid domain name")
+		}
+
+		labels = append(labels, string(data[1:1+length]))
+		data = data[1+length:]
+	}
+	return strings.Join(labels, "."), nil
+}
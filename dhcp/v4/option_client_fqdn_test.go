@@ -0,0 +1,102 @@
+package v4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseOptClientFQDN(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		wantFlags uint8
+		wantName  string
+		wantErr   bool
+	}{
+		{
+			name:      "canonical wire format",
+			data:      append([]byte{81, 17, FQDNFlagE | FQDNFlagS, 0, 0}, "\x04host\x07example\x00"...),
+			wantFlags: FQDNFlagE | FQDNFlagS,
+			wantName:  "host.example.",
+		},
+		{
+			name:      "partial name",
+			data:      append([]byte{81, 8, FQDNFlagE, 0, 0}, "\x04host"...),
+			wantFlags: FQDNFlagE,
+			wantName:  "host",
+		},
+		{
+			name:     "ASCII",
+			data:     append([]byte{81, 15, 0, 0, 0}, "host.example"...),
+			wantName: "host.example",
+		},
+		{
+			name:      "without name",
+			data:      []byte{81, 3, FQDNFlagN, 0, 0},
+			wantFlags: FQDNFlagN,
+		},
+		{name: "invalid label", data: append([]byte{81, 8, FQDNFlagE, 0, 0}, "\x09host"...), wantErr: true},
+		{name: "truncated", data: []byte{81, 9, FQDNFlagE, 0, 0, 4, 'h'}, wantErr: true},
+		{name: "too short", data: []byte{81, 2, 0, 0}, wantErr: true},
+		{name: "other option", data: []byte{12, 3, 0, 0, 0}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := ParseOptClientFQDN(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", opt)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if opt.Flags != tt.wantFlags || opt.DomainName != tt.wantName {
+				t.Errorf("got '%s' with flags %04b, want '%s' with %04b", opt.DomainName, opt.Flags, tt.wantName, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestOptClientFQDNToBytes(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  OptClientFQDN
+		want []byte
+	}{
+		{
+			name: "canonical wire format",
+			opt:  OptClientFQDN{Flags: FQDNFlagE | FQDNFlagS, DomainName: "host.example.com"},
+			want: append([]byte{81, 21, FQDNFlagE | FQDNFlagS, 255, 255}, "\x04host\x07example\x03com\x00"...),
+		},
+		{
+			name: "ASCII",
+			opt:  OptClientFQDN{Flags: FQDNFlagN, DomainName: "host.example"},
+			want: append([]byte{81, 15, FQDNFlagN, 255, 255}, "host.example"...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opt.ToBytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeDomainName(t *testing.T) {
+	for name, want := range map[string]string{
+		"host.example.com":  "\x04host\x07example\x03com\x00",
+		"host.example.com.": "\x04host\x07example\x03com\x00",
+		"host..example":     "\x04host\x07example\x00",
+		"":                  "\x00",
+	} {
+		if got := encodeDomainName(name); string(got) != want {
+			t.Errorf("encodeDomainName('%s') = %q, want %q", name, got, want)
+		}
+	}
+}
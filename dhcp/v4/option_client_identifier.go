@@ -0,0 +1,83 @@
+package v4
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Client-identifier option
+// https://tools.ietf.org/html/rfc2132#section-9.14
+// including the node-specific identifiers of
+// https://tools.ietf.org/html/rfc4361#section-6.1
+
+// ClientIdentifierTypeIAIDDUID is the type of a client identifier that consists of an IAID and a DUID.
+const ClientIdentifierTypeIAIDDUID = 255
+
+// OptClientIdentifier represents the Client-identifier option.
+type OptClientIdentifier struct {
+	Type       uint8
+	Identifier []byte
+}
+
+// ParseOptClientIdentifier constructs an OptClientIdentifier struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptClientIdentifier(data []byte) (*OptClientIdentifier, error) {
+	// Should at least have code, length, type and one byte of identifier.
+	if len(data) < 4 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != dhcpv4.OptionClientIdentifier {
+		return nil, fmt.Errorf("expected option %v, got %v instead", dhcpv4.OptionClientIdentifier, code)
+	}
+	length := int(data[1])
+	if length < 2 {
+		return nil, fmt.Errorf("expected length of at least 2, got %v instead", length)
+	}
+	if len(data) < 2+length {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	identifier := make([]byte, length-1)
+	copy(identifier, data[3:2+length])
+	return &OptClientIdentifier{Type: data[2], Identifier: identifier}, nil
+}
+
+// Code returns the option code.
+func (o *OptClientIdentifier) Code() dhcpv4.OptionCode {
+	return dhcpv4.OptionClientIdentifier
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+func (o *OptClientIdentifier) ToBytes() []byte {
+	serializedOpt := []byte{byte(o.Code()), byte(o.Length()), o.Type}
+	return append(serializedOpt, o.Identifier...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptClientIdentifier) String() string {
+	return fmt.Sprintf("Client-identifier -> type %d, %x", o.Type, o.Identifier)
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptClientIdentifier) Length() int {
+	return 1 + len(o.Identifier)
+}
+
+// IsIAIDDUID returns true if the identifier consists of an IAID and a DUID as described in RFC4361.
+func (o *OptClientIdentifier) IsIAIDDUID() bool {
+	// type code of the DUID is two bytes long
+	return o.Type == ClientIdentifierTypeIAIDDUID && len(o.Identifier) > 4+2
+}
+
+// IAID returns the hex encoded IAID of an RFC4361 client identifier.
+func (o *OptClientIdentifier) IAID() string {
+	return hex.EncodeToString(o.Identifier[:4])
+}
+
+// DUID returns the raw DUID of an RFC4361 client identifier.
+func (o *OptClientIdentifier) DUID() []byte {
+	return o.Identifier[4:]
+}
@@ -0,0 +1,93 @@
+package v4
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseOptClientIdentifier(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           []byte
+		wantType       uint8
+		wantIdentifier []byte
+		wantErr        bool
+	}{
+		{
+			name:           "hardware address",
+			data:           []byte{61, 7, 1, 0, 0x11, 0x22, 0x33, 0x44, 0x55},
+			wantType:       1,
+			wantIdentifier: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55},
+		},
+		{
+			name:           "IAID and DUID",
+			data:           []byte{61, 11, 255, 0, 0, 0, 1, 0, 3, 0, 1, 0xaa, 0xbb},
+			wantType:       255,
+			wantIdentifier: []byte{0, 0, 0, 1, 0, 3, 0, 1, 0xaa, 0xbb},
+		},
+		{name: "other option", data: []byte{60, 2, 1, 0}, wantErr: true},
+		{name: "only the type", data: []byte{61, 1, 1, 0}, wantErr: true},
+		{name: "truncated", data: []byte{61, 7, 1, 0, 0x11}, wantErr: true},
+		{name: "too short", data: []byte{61, 2, 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := ParseOptClientIdentifier(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", opt)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if opt.Type != tt.wantType || !bytes.Equal(opt.Identifier, tt.wantIdentifier) {
+				t.Errorf("got type %d with %v, want %d with %v", opt.Type, opt.Identifier, tt.wantType, tt.wantIdentifier)
+			}
+			if !bytes.Equal(opt.ToBytes(), tt.data) {
+				t.Errorf("expected the option to serialize to %v, got %v", tt.data, opt.ToBytes())
+			}
+		})
+	}
+}
+
+func TestIAIDDUID(t *testing.T) {
+	tests := []struct {
+		name     string
+		opt      OptClientIdentifier
+		want     bool
+		wantIAID string
+		wantDUID []byte
+	}{
+		{
+			name:     "RFC4361",
+			opt:      OptClientIdentifier{Type: 255, Identifier: []byte{0, 0, 0, 1, 0, 3, 0, 1, 0xaa}},
+			want:     true,
+			wantIAID: "00000001",
+			wantDUID: []byte{0, 3, 0, 1, 0xaa},
+		},
+		{name: "without DUID", opt: OptClientIdentifier{Type: 255, Identifier: []byte{0, 0, 0, 1, 0, 3}}},
+		{name: "hardware address", opt: OptClientIdentifier{Type: 1, Identifier: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.opt.IsIAIDDUID(); got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if !tt.want {
+				return
+			}
+
+			if iaid := tt.opt.IAID(); iaid != tt.wantIAID {
+				t.Errorf("got IAID '%s', want '%s'", iaid, tt.wantIAID)
+			}
+			if duid := tt.opt.DUID(); !bytes.Equal(duid, tt.wantDUID) {
+				t.Errorf("got DUID %v, want %v", duid, tt.wantDUID)
+			}
+		})
+	}
+}
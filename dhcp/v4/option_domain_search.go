@@ -0,0 +1,189 @@
+package v4
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Domain Search option
+// https://tools.ietf.org/html/rfc3397
+
+const OptionDomainSearch dhcpv4.OptionCode = 119
+
+// maxPointerOffset is the largest offset a compression pointer can point to.
+// See https://tools.ietf.org/html/rfc1035#section-4.1.4
+const maxPointerOffset = 0x3FFF
+
+// OptDomainSearch represents the Domain Search option.
+type OptDomainSearch struct {
+	DomainSearch []string
+}
+
+// ParseOptDomainSearch constructs an OptDomainSearch struct from a
+// sequence of bytes and returns it, or an error.
+// The data of several consecutive Domain Search options is concatenated, as described in RFC3396.
+func ParseOptDomainSearch(data []byte) (*OptDomainSearch, error) {
+	// Should at least have code and length.
+	if len(data) < 2 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+
+	var list []byte
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, dhcpv4.ErrShortByteStream
+		}
+		code := dhcpv4.OptionCode(data[0])
+		if code != OptionDomainSearch {
+			return nil, fmt.Errorf("expected option %v, got %v instead", OptionDomainSearch, code)
+		}
+		length := int(data[1])
+		if len(data) < 2+length {
+			return nil, dhcpv4.ErrShortByteStream
+		}
+
+		list = append(list, data[2:2+length]...)
+		data = data[2+length:]
+	}
+
+	opt := OptDomainSearch{}
+	for offset := 0; offset < len(list); {
+		domain, next, err := decodeCompressedName(list, offset)
+		if err != nil {
+			return nil, err
+		}
+		opt.DomainSearch = append(opt.DomainSearch, domain)
+		offset = next
+	}
+	return &opt, nil
+}
+
+// Code returns the option code.
+func (o *OptDomainSearch) Code() dhcpv4.OptionCode {
+	return OptionDomainSearch
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+// A list longer than 255 bytes is split into several consecutive options, see https://tools.ietf.org/html/rfc3396
+func (o *OptDomainSearch) ToBytes() []byte {
+	data := o.data()
+
+	var serializedOpt []byte
+	for len(data) > 255 {
+		serializedOpt = append(serializedOpt, byte(o.Code()), 255)
+		serializedOpt = append(serializedOpt, data[:255]...)
+		data = data[255:]
+	}
+	serializedOpt = append(serializedOpt, byte(o.Code()), byte(len(data)))
+	return append(serializedOpt, data...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptDomainSearch) String() string {
+	return fmt.Sprintf("Domain Search -> %v", strings.Join(o.DomainSearch, ", "))
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any). It exceeds 255 if the option is split, see ToBytes.
+func (o *OptDomainSearch) Length() int {
+	return len(o.data())
+}
+
+// data returns the domains encoded with the name compression of RFC1035, as required by RFC3397, Section 2.
+// A domain that repeats the suffix of a previous domain points to that suffix.
+// Invalid domains are left out.
+func (o *OptDomainSearch) data() []byte {
+	var data []byte
+	suffixes := make(map[string]int)
+
+	for _, domain := range o.DomainSearch {
+		labels, ok := domainLabels(domain)
+		if !ok {
+			continue
+		}
+
+		compressed := false
+		for i := range labels {
+			suffix := strings.ToLower(strings.Join(labels[i:], "."))
+			if offset, found := suffixes[suffix]; found {
+				data = append(data, 0xC0|byte(offset>>8), byte(offset))
+				compressed = true
+				break
+			}
+
+			if len(data) <= maxPointerOffset {
+				suffixes[suffix] = len(data)
+			}
+			data = append(data, byte(len(labels[i])))
+			data = append(data, labels[i]...)
+		}
+
+		if !compressed {
+			data = append(data, 0)
+		}
+	}
+
+	return data
+}
+
+// domainLabels returns the labels of the domain, or false if it's not a valid domain name.
+func domainLabels(domain string) ([]string, bool) {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || len(domain) > 253 {
+		return nil, false
+	}
+
+	labels := strings.Split(domain, ".")
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return nil, false
+		}
+	}
+	return labels, true
+}
+
+// decodeCompressedName decodes the name at the offset, which may end with a compression pointer,
+// and returns it together with the offset after the name.
+// See https://tools.ietf.org/html/rfc1035#section-4.1.4
+func decodeCompressedName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+
+	// Pointers must point backwards, which rules out loops.
+	for limit := offset; ; {
+		if offset >= len(data) {
+			return "", 0, dhcpv4.ErrShortByteStream
+		}
+
+		length := int(data[offset])
+		switch {
+		case length == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, "."), next, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(data) {
+				return "", 0, dhcpv4.ErrShortByteStream
+			}
+			pointer := (length&0x3F)<<8 | int(data[offset+1])
+			if pointer >= limit {
+				return "", 0, fmt.Errorf("invalid compression pointer %d", pointer)
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset, limit = pointer, pointer
+		case length > 63:
+			return "", 0, fmt.Errorf("invalid label length %d", length)
+		default:
+			if offset+1+length > len(data) {
+				return "", 0, dhcpv4.ErrShortByteStream
+			}
+			labels = append(labels, string(data[offset+1:offset+1+length]))
+			offset += 1 + length
+		}
+	}
+}
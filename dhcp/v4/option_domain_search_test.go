@@ -0,0 +1,108 @@
+package v4
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptDomainSearchCompression(t *testing.T) {
+	tests := []struct {
+		name    string
+		domains []string
+		data    []byte
+	}{
+		{
+			// The example of RFC3397, Section 2.
+			name:    "suffix of a previous domain",
+			domains: []string{"eng.apple.com.", "marketing.apple.com."},
+			data: []byte{
+				3, 'e', 'n', 'g', 5, 'a', 'p', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				9, 'm', 'a', 'r', 'k', 'e', 't', 'i', 'n', 'g', 0xC0, 4,
+			},
+		},
+		{
+			name:    "repeated domain",
+			domains: []string{"example.com", "example.com"},
+			data: []byte{
+				7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				0xC0, 0,
+			},
+		},
+		{
+			name:    "suffixes are compared case-insensitively",
+			domains: []string{"a.Example.com", "b.example.COM"},
+			data: []byte{
+				1, 'a', 7, 'E', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0,
+				1, 'b', 0xC0, 2,
+			},
+		},
+		{
+			name:    "invalid domains are left out",
+			domains: []string{"", "a..b", "c"},
+			data:    []byte{1, 'c', 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := OptDomainSearch{DomainSearch: tt.domains}
+
+			if data := opt.data(); !bytes.Equal(data, tt.data) {
+				t.Errorf("got % x, want % x", data, tt.data)
+			}
+			if opt.Length() != len(tt.data) {
+				t.Errorf("got length %d, want %d", opt.Length(), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestOptDomainSearchRoundTrip(t *testing.T) {
+	domains := []string{"eng.apple.com", "marketing.apple.com", "example.org"}
+
+	parsed, err := ParseOptDomainSearch((&OptDomainSearch{DomainSearch: domains}).ToBytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(parsed.DomainSearch, domains) {
+		t.Errorf("got %v, want %v", parsed.DomainSearch, domains)
+	}
+}
+
+func TestOptDomainSearchSplit(t *testing.T) {
+	// Distinct domains that can't be compressed, 32 bytes each.
+	var domains []string
+	for _, c := range "abcdefghij" {
+		domains = append(domains, strings.Repeat(string(c), 30))
+	}
+
+	opt := OptDomainSearch{DomainSearch: domains}
+	serialized := opt.ToBytes()
+
+	// RFC3396: 320 bytes are split into an option of 255 bytes and one of 65 bytes.
+	if serialized[0] != byte(OptionDomainSearch) || serialized[1] != 255 {
+		t.Fatalf("unexpected first option header % x", serialized[:2])
+	}
+	second := serialized[2+255:]
+	if second[0] != byte(OptionDomainSearch) || second[1] != 65 || len(second) != 2+65 {
+		t.Fatalf("unexpected second option header % x with %d bytes", second[:2], len(second))
+	}
+
+	parsed, err := ParseOptDomainSearch(serialized)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(parsed.DomainSearch, domains) {
+		t.Errorf("got %v, want %v", parsed.DomainSearch, domains)
+	}
+}
+
+func TestParseOptDomainSearchInvalidPointer(t *testing.T) {
+	// The pointer points to itself.
+	_, err := ParseOptDomainSearch([]byte{byte(OptionDomainSearch), 2, 0xC0, 0})
+	if err == nil {
+		t.Error("expected an error for a pointer that doesn't point backwards")
+	}
+}
@@ -0,0 +1,57 @@
+package v4
+
+import (
+	"fmt"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Message option
+// https://tools.ietf.org/html/rfc2132#section-9.9
+
+// OptMessage represents the Message option.
+type OptMessage struct {
+	Message string
+}
+
+// ParseOptMessage constructs an OptMessage struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptMessage(data []byte) (*OptMessage, error) {
+	// Should at least have code, length, and one character.
+	if len(data) < 3 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != dhcpv4.OptionMessage {
+		return nil, fmt.Errorf("expected option %v, got %v instead", dhcpv4.OptionMessage, code)
+	}
+	length := int(data[1])
+	if len(data) < 2+length {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	return &OptMessage{Message: string(data[2 : 2+length])}, nil
+}
+
+// Code returns the option code.
+func (o *OptMessage) Code() dhcpv4.OptionCode {
+	return dhcpv4.OptionMessage
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+func (o *OptMessage) ToBytes() []byte {
+	serializedOpt := []byte{byte(o.Code()), byte(o.Length())}
+	return append(serializedOpt, o.Message[:o.Length()]...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptMessage) String() string {
+	return fmt.Sprintf("Message -> %v", o.Message)
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptMessage) Length() int {
+	if len(o.Message) > 255 {
+		return 255
+	}
+	return len(o.Message)
+}
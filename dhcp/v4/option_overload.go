@@ -0,0 +1,62 @@
+package v4
+
+import (
+	"fmt"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Option Overload option
+// https://tools.ietf.org/html/rfc2132#section-9.3
+
+const (
+	// OverloadFile indicates that the 'file' field holds options.
+	OverloadFile uint8 = 1
+	// OverloadSName indicates that the 'sname' field holds options.
+	OverloadSName uint8 = 2
+	// OverloadBoth indicates that both the 'file' and the 'sname' field hold options.
+	OverloadBoth uint8 = 3
+)
+
+// OptOverload represents the Option Overload option.
+type OptOverload struct {
+	Overload uint8
+}
+
+// ParseOptOverload constructs an OptOverload struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptOverload(data []byte) (*OptOverload, error) {
+	// Should at least have code, length, and value.
+	if len(data) < 3 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != dhcpv4.OptionOptionOverload {
+		return nil, fmt.Errorf("expected option %v, got %v instead", dhcpv4.OptionOptionOverload, code)
+	}
+	length := int(data[1])
+	if length != 1 {
+		return nil, fmt.Errorf("expected length 1, got %v instead", length)
+	}
+	return &OptOverload{Overload: data[2]}, nil
+}
+
+// Code returns the option code.
+func (o *OptOverload) Code() dhcpv4.OptionCode {
+	return dhcpv4.OptionOptionOverload
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+func (o *OptOverload) ToBytes() []byte {
+	return []byte{byte(o.Code()), byte(o.Length()), o.Overload}
+}
+
+// String returns a human-readable string for this option.
+func (o *OptOverload) String() string {
+	return fmt.Sprintf("Option Overload -> %v", o.Overload)
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptOverload) Length() int {
+	return 1
+}
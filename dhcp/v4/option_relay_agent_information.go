@@ -0,0 +1,116 @@
+package v4
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This option implements the Relay Agent Information option
+// https://tools.ietf.org/html/rfc3046
+
+// RelayAgentSubOptionCode is the code of a sub-option of the Relay Agent Information option.
+type RelayAgentSubOptionCode uint8
+
+const (
+	// https://tools.ietf.org/html/rfc3046#section-3.1
+	RelayAgentCircuitID RelayAgentSubOptionCode = 1
+	// https://tools.ietf.org/html/rfc3046#section-3.2
+	RelayAgentRemoteID RelayAgentSubOptionCode = 2
+	// https://tools.ietf.org/html/rfc3527#section-3
+	RelayAgentLinkSelection RelayAgentSubOptionCode = 5
+	// https://tools.ietf.org/html/rfc3993#section-3
+	RelayAgentSubscriberID RelayAgentSubOptionCode = 6
+)
+
+// OptRelayAgentInformation represents the Relay Agent Information option.
+// The raw data is retained, so that the option can be echoed back unchanged.
+type OptRelayAgentInformation struct {
+	Data []byte
+}
+
+// ParseOptRelayAgentInformation constructs an OptRelayAgentInformation struct from a
+// sequence of bytes and returns it, or an error.
+func ParseOptRelayAgentInformation(data []byte) (*OptRelayAgentInformation, error) {
+	// Should at least have code, length, and one sub-option code and length.
+	if len(data) < 4 {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	code := dhcpv4.OptionCode(data[0])
+	if code != dhcpv4.OptionRelayAgentInformation {
+		return nil, fmt.Errorf("expected option %v, got %v instead", dhcpv4.OptionRelayAgentInformation, code)
+	}
+	length := int(data[1])
+	if len(data) < 2+length {
+		return nil, dhcpv4.ErrShortByteStream
+	}
+	optData := make([]byte, length)
+	copy(optData, data[2:2+length])
+	return &OptRelayAgentInformation{Data: optData}, nil
+}
+
+// Code returns the option code.
+func (o *OptRelayAgentInformation) Code() dhcpv4.OptionCode {
+	return dhcpv4.OptionRelayAgentInformation
+}
+
+// ToBytes returns a serialized stream of bytes for this option.
+func (o *OptRelayAgentInformation) ToBytes() []byte {
+	serializedOpt := []byte{byte(o.Code()), byte(o.Length())}
+	return append(serializedOpt, o.Data...)
+}
+
+// String returns a human-readable string for this option.
+func (o *OptRelayAgentInformation) String() string {
+	return fmt.Sprintf("Relay Agent Information -> %x", o.Data)
+}
+
+// Length returns the length of the data portion (excluding option code and byte
+// for length, if any).
+func (o *OptRelayAgentInformation) Length() int {
+	return len(o.Data)
+}
+
+// SubOption returns the data of the first sub-option with the given code, or nil if it is not present.
+func (o *OptRelayAgentInformation) SubOption(code RelayAgentSubOptionCode) []byte {
+	data := o.Data
+	for len(data) >= 2 {
+		subCode := RelayAgentSubOptionCode(data[0])
+		subLength := int(data[1])
+		if len(data) < 2+subLength {
+			return nil
+		}
+
+		if subCode == code {
+			return data[2 : 2+subLength]
+		}
+
+		data = data[2+subLength:]
+	}
+	return nil
+}
+
+// RelayAgentInfo returns the parsed values of the well-known sub-options.
+func (o *OptRelayAgentInformation) RelayAgentInfo() *RelayAgentInfo {
+	info := RelayAgentInfo{
+		CircuitID:    o.SubOption(RelayAgentCircuitID),
+		RemoteID:     o.SubOption(RelayAgentRemoteID),
+		SubscriberID: string(o.SubOption(RelayAgentSubscriberID)),
+	}
+
+	if linkSelection := o.SubOption(RelayAgentLinkSelection); len(linkSelection) == net.IPv4len {
+		info.LinkSelection = net.IP(linkSelection)
+	}
+
+	return &info
+}
+
+// RelayAgentInfo contains the values of the Relay Agent Information sub-options.
+// Absent sub-options are nil or empty.
+type RelayAgentInfo struct {
+	CircuitID     []byte
+	RemoteID      []byte
+	LinkSelection net.IP
+	SubscriberID  string
+}
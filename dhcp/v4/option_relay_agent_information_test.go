@@ -0,0 +1,88 @@
+package v4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestParseOptRelayAgentInformation(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		wantData []byte
+		wantErr  bool
+	}{
+		{name: "valid", data: []byte{82, 4, 1, 2, 'g', '1'}, wantData: []byte{1, 2, 'g', '1'}},
+		{name: "followed by other options", data: []byte{82, 4, 1, 2, 'g', '1', 255}, wantData: []byte{1, 2, 'g', '1'}},
+		{name: "other option", data: []byte{81, 4, 1, 2, 'g', '1'}, wantErr: true},
+		{name: "truncated", data: []byte{82, 6, 1, 2, 'g', '1'}, wantErr: true},
+		{name: "too short", data: []byte{82, 1, 1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt, err := ParseOptRelayAgentInformation(tt.data)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %v", opt.Data)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !bytes.Equal(opt.Data, tt.wantData) {
+				t.Errorf("got %v, want %v", opt.Data, tt.wantData)
+			}
+			if !bytes.Equal(opt.ToBytes(), tt.data[:2+len(tt.wantData)]) {
+				t.Errorf("expected the option to be echoed unchanged, got %v", opt.ToBytes())
+			}
+		})
+	}
+}
+
+func TestRelayAgentInfo(t *testing.T) {
+	opt := OptRelayAgentInformation{Data: []byte{
+		1, 7, 'G', 'i', '1', '/', '0', '/', '1', // Circuit ID
+		2, 3, 0xaa, 0xbb, 0xcc, // Remote ID
+		5, 4, 10, 0, 1, 0, // Link Selection
+		6, 3, 's', 'u', 'b', // Subscriber ID
+		1, 2, 'x', 'y', // a second Circuit ID is ignored
+	}}
+
+	info := opt.RelayAgentInfo()
+	if string(info.CircuitID) != "Gi1/0/1" {
+		t.Errorf("got circuit ID '%s', want 'Gi1/0/1'", info.CircuitID)
+	}
+	if !bytes.Equal(info.RemoteID, []byte{0xaa, 0xbb, 0xcc}) {
+		t.Errorf("got remote ID %v, want [170 187 204]", info.RemoteID)
+	}
+	if !info.LinkSelection.Equal(net.IPv4(10, 0, 1, 0)) {
+		t.Errorf("got link selection '%s', want '10.0.1.0'", info.LinkSelection)
+	}
+	if info.SubscriberID != "sub" {
+		t.Errorf("got subscriber ID '%s', want 'sub'", info.SubscriberID)
+	}
+}
+
+func TestRelayAgentInfoMalformed(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "truncated sub-option", data: []byte{1, 9, 'G', 'i'}},
+		{name: "link selection of the wrong length", data: []byte{5, 2, 10, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := (&OptRelayAgentInformation{Data: tt.data}).RelayAgentInfo()
+			if info.CircuitID != nil || info.RemoteID != nil || info.LinkSelection != nil || info.SubscriberID != "" {
+				t.Errorf("expected no sub-options, got %+v", info)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package v4
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/raw"
+)
+
+// ErrNoAnswer is returned when no host answered within the timeout.
+var ErrNoAnswer = errors.New("no answer within the timeout")
+
+var ethernetBroadcast = net.HardwareAddr{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// ProbeARP sends an ARP probe for the IP on the interface and reports whether a host answered within the timeout.
+// See https://tools.ietf.org/html/rfc5227#section-2.1.1
+func ProbeARP(iface net.Interface, ip net.IP, timeout time.Duration) (bool, error) {
+	// An ARP probe has an all-zero sender IP, so that it does not pollute the ARP caches of other hosts.
+	_, err := requestARP(iface, net.IPv4zero, ip, timeout)
+	if err == ErrNoAnswer {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// requestARP sends an ARP request for targetIP on the interface and returns the MAC of the host that answered.
+func requestARP(iface net.Interface, senderIP, targetIP net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	conn, err := raw.ListenPacket(&iface, uint16(layers.EthernetTypeARP), &raw.Config{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request, err := arpRequest(iface.HardwareAddr, senderIP, targetIP)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.WriteTo(request, &raw.Addr{HardwareAddr: ethernetBroadcast})
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	p := make([]byte, 1500)
+	for {
+		l, _, err := conn.ReadFrom(p)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, ErrNoAnswer
+		} else if err != nil {
+			return nil, err
+		}
+
+		if hwAddr, ok := arpSenderHwAddr(p[:l], targetIP); ok {
+			return hwAddr, nil
+		}
+	}
+}
+
+// arpRequest returns an Ethernet frame with an ARP request for targetIP.
+func arpRequest(srcMAC net.HardwareAddr, senderIP, targetIP net.IP) ([]byte, error) {
+	eth := layers.Ethernet{ // IEEE 802.3
+		DstMAC:       ethernetBroadcast,
+		SrcMAC:       srcMAC,
+		EthernetType: layers.EthernetTypeARP,
+	}
+
+	arp := layers.ARP{ // RFC 826
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: senderIP.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    targetIP.To4(),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &arp)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// arpSenderHwAddr returns the sender MAC of the Ethernet frame if it is an ARP packet sent by targetIP.
+// Any ARP packet that has the target IP as its sender IP comes from the host that uses it.
+func arpSenderHwAddr(p []byte, targetIP net.IP) (net.HardwareAddr, bool) {
+	pack := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+
+	arpLayer, ok := pack.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok || arpLayer == nil || !net.IP(arpLayer.SourceProtAddress).Equal(targetIP) {
+		return nil, false
+	}
+
+	return net.HardwareAddr(arpLayer.SourceHwAddress), true
+}
+
+// ProbeICMP sends an ICMP echo request to the IP and reports whether the host answered within the timeout.
+func ProbeICMP(ip net.IP, timeout time.Duration) (bool, error) {
+	conn, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	id := uint16(os.Getpid())
+	seq := uint16(rand.Intn(1 << 16))
+
+	request, err := echoRequest(id, seq)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = conn.WriteTo(request, &net.IPAddr{IP: ip})
+	if err != nil {
+		return false, err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return false, err
+	}
+
+	p := make([]byte, 1500)
+	for {
+		// The IPv4 header is stripped when reading from an 'ip4:icmp' socket.
+		l, addr, err := conn.ReadFrom(p)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+
+		ipAddr, ok := addr.(*net.IPAddr)
+		if !ok || !ipAddr.IP.Equal(ip) {
+			continue
+		}
+
+		if isEchoReply(p[:l], id, seq) {
+			return true, nil
+		}
+	}
+}
+
+// echoRequest returns an ICMP echo request with the identifier and the sequence number.
+func echoRequest(id, seq uint16) ([]byte, error) {
+	icmp := layers.ICMPv4{ // RFC 792
+		TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0),
+		Id:       id,
+		Seq:      seq,
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true}, &icmp, gopacket.Payload("netbox-dhcp"))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isEchoReply returns true if the ICMP message is the reply to the echo request with the identifier and the sequence number.
+func isEchoReply(p []byte, id, seq uint16) bool {
+	pack := gopacket.NewPacket(p, layers.LayerTypeICMPv4, gopacket.Default)
+
+	icmpLayer, ok := pack.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if !ok || icmpLayer == nil {
+		return false
+	}
+
+	return icmpLayer.TypeCode.Type() == layers.ICMPv4TypeEchoReply && icmpLayer.Id == id && icmpLayer.Seq == seq
+}
@@ -0,0 +1,139 @@
+package v4
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestARPRequest(t *testing.T) {
+	srcMAC := net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	target := net.IPv4(10, 0, 0, 1)
+
+	p, err := arpRequest(srcMAC, net.IPv4zero, target)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pack := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+	eth, ok := pack.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+	if !ok || eth.DstMAC.String() != ethernetBroadcast.String() {
+		t.Fatalf("expected an Ethernet broadcast, got %v", eth)
+	}
+
+	arp, ok := pack.Layer(layers.LayerTypeARP).(*layers.ARP)
+	if !ok {
+		t.Fatal("expected an ARP packet")
+	}
+	// RFC5227, Section 2.1.1: A probe has an all-zero sender IP.
+	if arp.Operation != layers.ARPRequest || !net.IP(arp.SourceProtAddress).Equal(net.IPv4zero) ||
+		!net.IP(arp.DstProtAddress).Equal(target) || net.HardwareAddr(arp.SourceHwAddress).String() != srcMAC.String() {
+		t.Errorf("got ARP %d from '%s' ('%s') for '%s'", arp.Operation,
+			net.IP(arp.SourceProtAddress), net.HardwareAddr(arp.SourceHwAddress), net.IP(arp.DstProtAddress))
+	}
+}
+
+// arpPacket returns an Ethernet frame with an ARP packet that the host with the MAC and the IP sent.
+func arpPacket(t *testing.T, operation uint16, srcMAC net.HardwareAddr, srcIP net.IP) []byte {
+	t.Helper()
+
+	eth := layers.Ethernet{DstMAC: ethernetBroadcast, SrcMAC: srcMAC, EthernetType: layers.EthernetTypeARP}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         operation,
+		SourceHwAddress:   srcMAC,
+		SourceProtAddress: srcIP.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    net.IPv4(10, 0, 0, 254).To4(),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &arp); err != nil {
+		t.Fatalf("can't serialize the ARP packet: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestARPSenderHwAddr(t *testing.T) {
+	target := net.IPv4(10, 0, 0, 1)
+	targetMAC := net.HardwareAddr{0, 0xaa, 0xbb, 0xcc, 0xdd, 0xee}
+
+	tests := []struct {
+		name   string
+		p      []byte
+		wantOK bool
+	}{
+		{name: "reply", p: arpPacket(t, layers.ARPReply, targetMAC, target), wantOK: true},
+		// RFC5227, Section 2.1.1: A request of the host using the IP reveals it as well.
+		{name: "request of the target", p: arpPacket(t, layers.ARPRequest, targetMAC, target), wantOK: true},
+		{name: "other host", p: arpPacket(t, layers.ARPReply, targetMAC, net.IPv4(10, 0, 0, 2))},
+		{name: "not ARP", p: make([]byte, 60)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hwAddr, ok := arpSenderHwAddr(tt.p, target)
+			if ok != tt.wantOK {
+				t.Fatalf("got %v, want %v", ok, tt.wantOK)
+			}
+			if ok && hwAddr.String() != targetMAC.String() {
+				t.Errorf("got '%s', want '%s'", hwAddr, targetMAC)
+			}
+		})
+	}
+}
+
+// icmpMessage returns an ICMP message of the type with the identifier and the sequence number.
+func icmpMessage(t *testing.T, icmpType uint8, id, seq uint16) []byte {
+	t.Helper()
+
+	icmp := layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(icmpType, 0), Id: id, Seq: seq}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{ComputeChecksums: true}, &icmp); err != nil {
+		t.Fatalf("can't serialize the ICMP message: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEchoRequest(t *testing.T) {
+	p, err := echoRequest(0x1234, 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	pack := gopacket.NewPacket(p, layers.LayerTypeICMPv4, gopacket.Default)
+	icmp, ok := pack.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+	if !ok {
+		t.Fatal("expected an ICMP message")
+	}
+	if icmp.TypeCode.Type() != layers.ICMPv4TypeEchoRequest || icmp.Id != 0x1234 || icmp.Seq != 7 {
+		t.Errorf("got %s with ID %d and sequence %d", icmp.TypeCode, icmp.Id, icmp.Seq)
+	}
+}
+
+func TestIsEchoReply(t *testing.T) {
+	tests := []struct {
+		name string
+		p    []byte
+		want bool
+	}{
+		{name: "reply", p: icmpMessage(t, layers.ICMPv4TypeEchoReply, 0x1234, 7), want: true},
+		{name: "other ID", p: icmpMessage(t, layers.ICMPv4TypeEchoReply, 0x4321, 7)},
+		{name: "other sequence", p: icmpMessage(t, layers.ICMPv4TypeEchoReply, 0x1234, 8)},
+		{name: "request", p: icmpMessage(t, layers.ICMPv4TypeEchoRequest, 0x1234, 7)},
+		{name: "garbage", p: []byte{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isEchoReply(tt.p, 0x1234, 7); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package v4
+
+import (
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// MinMessageSize is the size of a DHCP message, including the IP and UDP headers, that every client must accept.
+// See https://tools.ietf.org/html/rfc2131#section-2 and https://tools.ietf.org/html/rfc2132#section-9.10
+const MinMessageSize = 576
+
+const (
+	ipUDPHeaderSize = 20 + 8
+	bootpHeaderSize = 236 // op ... file
+	magicCookieSize = 4
+	snameSize       = 64
+	fileSize        = 128
+)
+
+// ReplyOptions collects the options of a reply, so that they can be laid out according to what the client
+// requested (option 55) and how large a message it accepts (option 57).
+type ReplyOptions struct {
+	// NoOverload keeps the options out of the 'file' and 'sname' fields,
+	// e.g. for BOOTP clients, which don't know the Option Overload option.
+	NoOverload bool
+	// FileInUse and SNameInUse keep the options out of the 'file' or the 'sname' field,
+	// because it already carries the boot file name or the server's host name.
+	FileInUse  bool
+	SNameInUse bool
+
+	required []dhcpv4.Option
+	optional []dhcpv4.Option
+	last     []dhcpv4.Option
+}
+
+// AddRequired adds an option that is sent regardless of whether the client requested it.
+// Required options are sent first, in the order they were added.
+func (o *ReplyOptions) AddRequired(opt dhcpv4.Option) {
+	o.required = append(o.required, opt)
+}
+
+// Add adds an option that is sent if there's enough space.
+// Options the client requested are sent in the order of its Parameter Request List,
+// followed by the options it did not request.
+func (o *ReplyOptions) Add(opt dhcpv4.Option) {
+	o.optional = append(o.optional, opt)
+}
+
+// Set adds an option that is sent if there's enough space, like Add.
+// It replaces the options with the same code that were added before.
+func (o *ReplyOptions) Set(opt dhcpv4.Option) {
+	optional := o.optional[:0]
+	for _, existing := range o.optional {
+		if existing.Code() != opt.Code() {
+			optional = append(optional, existing)
+		}
+	}
+	o.optional = append(optional, opt)
+}
+
+// AddLast adds a required option that is sent after all the other options,
+// like the Relay Agent Information option.
+// See https://tools.ietf.org/html/rfc3046#section-2.1
+func (o *ReplyOptions) AddLast(opt dhcpv4.Option) {
+	o.last = append(o.last, opt)
+}
+
+// OptionLayout describes where the options of a reply go.
+type OptionLayout struct {
+	// Options go into the 'options' field, in this order.
+	// It contains the Option Overload option, if File or SName are not nil.
+	Options []dhcpv4.Option
+	// File are the options for the 'file' field, including the End option, or nil if it's not overloaded.
+	File []byte
+	// SName are the options for the 'sname' field, including the End option, or nil if it's not overloaded.
+	SName []byte
+	// Dropped are the options that did not fit.
+	Dropped []dhcpv4.Option
+}
+
+// Layout arranges the options in the order requested by the client.
+// If they don't fit into a message of maxMessageSize bytes, the 'file' and then the 'sname' field
+// are overloaded with options, and the options that still don't fit are dropped.
+// The options the client requested are placed first, and the ones it did not request
+// only take up the space that is left, even if a large requested option was dropped before.
+// See https://tools.ietf.org/html/rfc2131#section-4.1 and https://tools.ietf.org/html/rfc2132#section-9.3
+func (o *ReplyOptions) Layout(requested []dhcpv4.OptionCode, maxMessageSize int) OptionLayout {
+	if maxMessageSize < MinMessageSize {
+		maxMessageSize = MinMessageSize
+	}
+
+	// Minus one byte for the End option.
+	space := maxMessageSize - ipUDPHeaderSize - bootpHeaderSize - magicCookieSize - 1
+	space -= size(o.required) + size(o.last)
+
+	requestedOpts, unrequestedOpts := o.ordered(requested)
+	if size(requestedOpts)+size(unrequestedOpts) <= space {
+		return OptionLayout{Options: o.concat(append(requestedOpts, unrequestedOpts...))}
+	}
+
+	overload := &OptOverload{}
+	fileSpace := fileSize - 1
+	snameSpace := snameSize - 1
+	if o.NoOverload || o.FileInUse {
+		fileSpace = 0
+	}
+	if o.NoOverload || o.SNameInUse {
+		snameSpace = 0
+	}
+	if fileSpace > 0 || snameSpace > 0 {
+		space -= len(overload.ToBytes())
+	}
+
+	var options, file, sname, dropped []dhcpv4.Option
+	place := func(opt dhcpv4.Option) {
+		l := len(opt.ToBytes())
+		switch {
+		case l <= space:
+			options = append(options, opt)
+			space -= l
+		case l <= fileSpace:
+			file = append(file, opt)
+			fileSpace -= l
+		case l <= snameSpace:
+			sname = append(sname, opt)
+			snameSpace -= l
+		default:
+			dropped = append(dropped, opt)
+		}
+	}
+
+	for _, opt := range requestedOpts {
+		place(opt)
+	}
+	for _, opt := range unrequestedOpts {
+		place(opt)
+	}
+
+	layout := OptionLayout{Dropped: dropped}
+	if len(file) > 0 {
+		overload.Overload |= OverloadFile
+		layout.File = serialize(file)
+	}
+	if len(sname) > 0 {
+		overload.Overload |= OverloadSName
+		layout.SName = serialize(sname)
+	}
+	if overload.Overload != 0 {
+		options = append([]dhcpv4.Option{overload}, options...)
+	}
+
+	layout.Options = o.concat(options)
+	return layout
+}
+
+// ordered returns the optional options that were requested in the order they were requested,
+// and the ones that were not requested.
+func (o *ReplyOptions) ordered(requested []dhcpv4.OptionCode) ([]dhcpv4.Option, []dhcpv4.Option) {
+	var ordered, unrequested []dhcpv4.Option
+	isRequested := make(map[dhcpv4.OptionCode]bool)
+	for _, code := range requested {
+		if isRequested[code] {
+			continue
+		}
+		isRequested[code] = true
+
+		for _, opt := range o.optional {
+			if opt.Code() == code {
+				ordered = append(ordered, opt)
+			}
+		}
+	}
+
+	for _, opt := range o.optional {
+		if !isRequested[opt.Code()] {
+			unrequested = append(unrequested, opt)
+		}
+	}
+
+	return ordered, unrequested
+}
+
+// concat returns the required, the given and the last options.
+func (o *ReplyOptions) concat(options []dhcpv4.Option) []dhcpv4.Option {
+	all := make([]dhcpv4.Option, 0, len(o.required)+len(options)+len(o.last))
+	all = append(all, o.required...)
+	all = append(all, options...)
+	return append(all, o.last...)
+}
+
+// size returns the number of bytes the options take up in a message.
+func size(options []dhcpv4.Option) int {
+	s := 0
+	for _, opt := range options {
+		s += len(opt.ToBytes())
+	}
+	return s
+}
+
+// serialize returns the options followed by the End option.
+// The remainder of the field is padded with zeros, i.e. Pad options.
+func serialize(options []dhcpv4.Option) []byte {
+	var data []byte
+	for _, opt := range options {
+		data = append(data, opt.ToBytes()...)
+	}
+	return append(data, byte(dhcpv4.OptionEnd))
+}
@@ -0,0 +1,132 @@
+package v4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func genericOption(code dhcpv4.OptionCode, size int) dhcpv4.Option {
+	return &dhcpv4.OptionGeneric{OptionCode: code, Data: make([]byte, size)}
+}
+
+func codes(options []dhcpv4.Option) []dhcpv4.OptionCode {
+	var c []dhcpv4.OptionCode
+	for _, opt := range options {
+		c = append(c, opt.Code())
+	}
+	return c
+}
+
+func TestReplyOptionsLayoutOrder(t *testing.T) {
+	var o ReplyOptions
+	o.AddRequired(genericOption(dhcpv4.OptionDHCPMessageType, 1))
+	o.AddLast(genericOption(dhcpv4.OptionRelayAgentInformation, 4))
+	o.Add(genericOption(200, 4))
+	o.Add(genericOption(dhcpv4.OptionSubnetMask, 4))
+	o.Add(genericOption(dhcpv4.OptionRouter, 4))
+	o.Set(genericOption(200, 8))
+
+	layout := o.Layout([]dhcpv4.OptionCode{dhcpv4.OptionRouter, dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter}, 0)
+
+	want := []dhcpv4.OptionCode{
+		dhcpv4.OptionDHCPMessageType,
+		dhcpv4.OptionRouter,
+		dhcpv4.OptionSubnetMask,
+		200,
+		dhcpv4.OptionRelayAgentInformation,
+	}
+	if got := codes(layout.Options); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if layout.File != nil || layout.SName != nil || layout.Dropped != nil {
+		t.Errorf("expected no overload and no dropped options, got %+v", layout)
+	}
+	if l := layout.Options[3].Length(); l != 8 {
+		t.Errorf("expected Set to replace option 200, got length %d", l)
+	}
+}
+
+func TestReplyOptionsLayoutOverload(t *testing.T) {
+	// A message of 576 bytes leaves 307 bytes for the options, minus 3 for the Option Overload option.
+	requested := genericOption(201, 250)   // 252 bytes, leaves 52 bytes
+	unrequested := genericOption(202, 100) // 102 bytes, only fits into 'file'
+	small := genericOption(203, 40)        // 42 bytes, still fits into 'options'
+
+	tests := []struct {
+		name     string
+		options  ReplyOptions
+		overload uint8
+		file     []byte
+		codes    []dhcpv4.OptionCode
+		dropped  []dhcpv4.OptionCode
+	}{
+		{
+			name:     "file",
+			overload: OverloadFile,
+			file:     serialize([]dhcpv4.Option{unrequested}),
+			codes:    []dhcpv4.OptionCode{dhcpv4.OptionOptionOverload, 201, 203},
+		},
+		{
+			name:    "file in use",
+			options: ReplyOptions{FileInUse: true},
+			codes:   []dhcpv4.OptionCode{201, 203},
+			dropped: []dhcpv4.OptionCode{202},
+		},
+		{
+			name:    "no overload",
+			options: ReplyOptions{NoOverload: true},
+			codes:   []dhcpv4.OptionCode{201, 203},
+			dropped: []dhcpv4.OptionCode{202},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := tt.options
+			o.Add(unrequested)
+			o.Add(small)
+			o.Add(requested)
+
+			layout := o.Layout([]dhcpv4.OptionCode{201}, MinMessageSize)
+
+			if got := codes(layout.Options); !reflect.DeepEqual(got, tt.codes) {
+				t.Errorf("got options %v, want %v", got, tt.codes)
+			}
+			if got := codes(layout.Dropped); !reflect.DeepEqual(got, tt.dropped) {
+				t.Errorf("got dropped options %v, want %v", got, tt.dropped)
+			}
+			if !bytes.Equal(layout.File, tt.file) {
+				t.Errorf("got 'file' % x, want % x", layout.File, tt.file)
+			}
+			if layout.SName != nil {
+				t.Errorf("expected 'sname' not to be overloaded, got % x", layout.SName)
+			}
+			if tt.overload != 0 {
+				overload, ok := layout.Options[0].(*OptOverload)
+				if !ok || overload.Overload != tt.overload {
+					t.Errorf("got %v, want Option Overload %d", layout.Options[0], tt.overload)
+				}
+			}
+		})
+	}
+}
+
+func TestReplyOptionsLayoutRequestedFirst(t *testing.T) {
+	// The unrequested option was added first, but the requested option gets the space.
+	var o ReplyOptions
+	o.NoOverload = true
+	o.Add(genericOption(202, 200))
+	o.Add(genericOption(201, 200))
+
+	layout := o.Layout([]dhcpv4.OptionCode{201}, MinMessageSize)
+
+	if got := codes(layout.Options); !reflect.DeepEqual(got, []dhcpv4.OptionCode{201}) {
+		t.Errorf("got options %v, want [201]", got)
+	}
+	if got := codes(layout.Dropped); !reflect.DeepEqual(got, []dhcpv4.OptionCode{202}) {
+		t.Errorf("got dropped options %v, want [202]", got)
+	}
+}
@@ -0,0 +1,31 @@
+package v4
+
+import (
+	"net"
+	"time"
+)
+
+// RequestInfoV4 holds details about a request, which a resolver may take into account.
+type RequestInfoV4 struct {
+	// LinkAddress selects the subnet the client is on.
+	// It's the address of the relay agent (or its link selection) for relayed requests,
+	// and the address of the server otherwise.
+	LinkAddress net.IP
+	// MAC is the client hardware address of 'chaddr', or empty if the client has none, e.g. on InfiniBand.
+	MAC string
+	// RelayAgentInfo holds the sub-options of the Relay Agent Information option.
+	// It is nil if the request did not contain that option.
+	RelayAgentInfo *RelayAgentInfo
+	// Architectures are the client system architecture types of option 93.
+	Architectures []uint16
+	// VendorClass is the vendor class identifier of option 60, e.g. 'PXEClient:Arch:00007:UNDI:003016'.
+	VendorClass string
+	// UserClasses are the user classes of option 77, e.g. 'iPXE'.
+	UserClasses []string
+	// VendorEnterprises are the enterprise numbers of option 124.
+	VendorEnterprises []uint32
+	// RequestedLeaseTime is the lease time the client asked for in option 51, or 0 if it asked for none.
+	RequestedLeaseTime time.Duration
+	// BOOTP is true for BOOTREQUESTs without DHCP message type, whose leases never expire.
+	BOOTP bool
+}
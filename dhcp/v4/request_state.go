@@ -0,0 +1,65 @@
+package v4
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// RequestState is the state a client is in when it sends a DHCPREQUEST.
+// See https://tools.ietf.org/html/rfc2131#section-4.3.2
+type RequestState uint8
+
+const (
+	RequestStateSelecting RequestState = iota
+	RequestStateInitReboot
+	RequestStateRenewing
+	RequestStateRebinding
+)
+
+func (r RequestState) String() string {
+	switch r {
+	case RequestStateSelecting:
+		return "SELECTING"
+	case RequestStateInitReboot:
+		return "INIT-REBOOT"
+	case RequestStateRenewing:
+		return "RENEWING"
+	case RequestStateRebinding:
+		return "REBINDING"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// DetermineRequestState derives the client's state from the fields of a DHCPREQUEST
+// as described in RFC2131, Section 4.3.2:
+//
+// SELECTING:   'server identifier' and 'requested IP address' present, 'ciaddr' zero
+// INIT-REBOOT: no 'server identifier', 'requested IP address' present, 'ciaddr' zero
+// RENEWING:    no 'server identifier', no 'requested IP address', 'ciaddr' set, unicast
+// REBINDING:   no 'server identifier', no 'requested IP address', 'ciaddr' set, broadcast
+//
+// The dstIP is the IPv4 the DHCPREQUEST was sent to.
+func DetermineRequestState(request *dhcpv4.DHCPv4, dstIP net.IP) RequestState {
+	if request.GetOneOption(dhcpv4.OptionServerIdentifier) != nil {
+		return RequestStateSelecting
+	}
+
+	ciaddr := request.ClientIPAddr()
+	if ciaddr == nil || ciaddr.Equal(net.IPv4zero) {
+		return RequestStateInitReboot
+	}
+
+	// relay agents only forward broadcasts
+	giaddr := request.GatewayIPAddr()
+	if giaddr != nil && !giaddr.Equal(net.IPv4zero) {
+		return RequestStateRebinding
+	}
+
+	if dstIP != nil && dstIP.Equal(net.IPv4bcast) {
+		return RequestStateRebinding
+	}
+
+	return RequestStateRenewing
+}
@@ -2,6 +2,7 @@ package v4
 
 import (
 	"errors"
+	"fmt"
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -15,47 +16,89 @@ const MinPackSize = 5*4 + // minimal IPv4 header size
 	2*4 + // minimal UDP header size
 	13*4 // minimal DHCPv4 header size
 
+// MinBOOTPSize is the minimal size of a BOOTP message, which some BOOTP relay agents and clients insist on.
+// See https://tools.ietf.org/html/rfc1542#section-2.1
+const MinBOOTPSize = 300
+
 type DHCPV4Conn struct {
-	conn  *raw.Conn
-	iface net.Interface
-	laddr net.IP
+	conn      *raw.Conn
+	relayConn *net.UDPConn
+	iface     net.Interface
+	laddr     net.IP
+	neighbors *NeighborCache
 }
 
+// ListenDHCPv4 creates a connection that listens on the given interface for dhcpv4 traffic.
+//
+// Replies to relay agents are not sent through the raw socket, but through a regular UDP socket
+// bound to laddr, so that the kernel takes care of routing them to the relay agent.
+// That socket needs port 67, so no other DHCP server or relay agent may use it.
 func ListenDHCPv4(iface net.Interface, laddr net.IP) (*DHCPV4Conn, error) {
 	conn, err := raw.ListenPacket(&iface, uint16(layers.EthernetTypeIPv4), &raw.Config{})
+	if err != nil {
+		return nil, err
+	}
 
-	return &DHCPV4Conn{conn: conn, iface: iface, laddr: laddr}, err
+	relayConn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: laddr, Port: dhcpv4.ServerPort})
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("can't open the socket for replies to relay agents on '%s:%d': %s", laddr, dhcpv4.ServerPort, err)
+	}
+
+	// The requests are read through the raw socket,
+	// so the copies the UDP socket receives are discarded before they fill its receive buffer.
+	go discard(relayConn)
+
+	return &DHCPV4Conn{
+		conn:      conn,
+		relayConn: relayConn,
+		iface:     iface,
+		laddr:     laddr,
+		neighbors: NewNeighborCache(iface, laddr),
+	}, nil
+}
+
+// ResolveMAC returns the MAC of the next hop towards dstIP, see NeighborCache.
+func (c *DHCPV4Conn) ResolveMAC(dstIP net.IP) (net.HardwareAddr, error) {
+	return c.neighbors.Resolve(dstIP)
 }
 
-// ReadFrom returns the parsed packet, source IP, source MAC, error
-func (c *DHCPV4Conn) ReadFrom() (dhcpv4.DHCPv4, net.IP, net.HardwareAddr, error) {
+// ReadFrom returns the parsed packet, source IP, destination IP, source MAC, error
+func (c *DHCPV4Conn) ReadFrom() (dhcpv4.DHCPv4, net.IP, net.IP, net.HardwareAddr, error) {
 	eth, ip4, _, p, err := c.readFrom()
 	if err != nil {
-		return dhcpv4.DHCPv4{}, nil, nil, err
+		return dhcpv4.DHCPv4{}, nil, nil, nil, err
 	}
 
 	srcIP := ip4.SrcIP
+	dstIP := ip4.DstIP
 	srcMAC := eth.SrcMAC
 	pack, err := dhcpv4.FromBytes(p)
 
 	if err != nil {
-		return dhcpv4.DHCPv4{}, srcIP, srcMAC, err
+		return dhcpv4.DHCPv4{}, srcIP, dstIP, srcMAC, err
 	}
 
-	return *pack, srcIP, srcMAC, nil
+	return *pack, srcIP, dstIP, srcMAC, nil
 }
 
 func (c *DHCPV4Conn) WriteTo(pack dhcpv4.DHCPv4, dstIP net.IP, dstMAC net.HardwareAddr) error {
-	serverIdentifier, ok := pack.GetOneOption(dhcpv4.OptionServerIdentifier).(*dhcpv4.OptServerIdentifier)
-	if !ok {
-		return errors.New("option ServerIdentifier undefined, illegal dhcp packet")
+	var srcIP net.IP
+	if pack.MessageType() == nil {
+		// BOOTREPLYs have no server identifier.
+		srcIP = c.laddr
+	} else {
+		serverIdentifier, ok := pack.GetOneOption(dhcpv4.OptionServerIdentifier).(*dhcpv4.OptServerIdentifier)
+		if !ok {
+			return errors.New("option ServerIdentifier undefined, illegal dhcp packet")
+		}
+		srcIP = serverIdentifier.ServerID
 	}
-	srcIP := serverIdentifier.ServerID
 	srcMAC := c.iface.HardwareAddr
 
-	p := pack.ToBytes()
+	p := toBytes(pack)
 
-	log.Printf("Sending DHCP%s (%d bytes) to %s (%s) from %s (%s)", pack.MessageType(), len(p), dstIP, dstMAC, srcIP, srcMAC)
+	log.Printf("Sending %s (%d bytes) to %s (%s) from %s (%s)", messageName(pack), len(p), dstIP, dstMAC, srcIP, srcMAC)
 
 	udp := layers.UDP{ // RFC 768
 		SrcPort: dhcpv4.ServerPort,
@@ -94,10 +137,68 @@ func (c *DHCPV4Conn) WriteTo(pack dhcpv4.DHCPv4, dstIP net.IP, dstMAC net.Hardwa
 	return err
 }
 
+// WriteToRelay sends the packet to the 'DHCP server' port of the relay agent with the address relayIP.
+// See https://tools.ietf.org/html/rfc2131#section-4.1
+func (c *DHCPV4Conn) WriteToRelay(pack dhcpv4.DHCPv4, relayIP net.IP) error {
+	p := toBytes(pack)
+
+	log.Printf("Sending %s (%d bytes) to relay agent %s from %s", messageName(pack), len(p), relayIP, c.laddr)
+
+	_, err := c.relayConn.WriteToUDP(p, &net.UDPAddr{IP: relayIP, Port: dhcpv4.ServerPort})
+	return err
+}
+
+// WriteToClient sends the packet to the 'DHCP client' port of a bound client with the address clientIP.
+// The kernel takes care of routing it, as the client might be behind a relay agent.
+// If a nonce is given, the packet is signed with it, see SignForceRenew.
+func (c *DHCPV4Conn) WriteToClient(pack dhcpv4.DHCPv4, clientIP net.IP, nonce []byte) error {
+	p := toBytes(pack)
+	if nonce != nil {
+		if err := SignForceRenew(p, nonce); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Sending %s (%d bytes) to client %s from %s", messageName(pack), len(p), clientIP, c.laddr)
+
+	_, err := c.relayConn.WriteToUDP(p, &net.UDPAddr{IP: clientIP, Port: dhcpv4.ClientPort})
+	return err
+}
+
+// toBytes serializes the packet and pads it to the minimal size of a BOOTP message.
+// The padding follows the End option, so it consists of Pad options.
+func toBytes(pack dhcpv4.DHCPv4) []byte {
+	p := pack.ToBytes()
+	if len(p) < MinBOOTPSize {
+		p = append(p, make([]byte, MinBOOTPSize-len(p))...)
+	}
+	return p
+}
+
+// messageName returns the name of the packet's DHCP message type, or BOOTREPLY if it has none.
+func messageName(pack dhcpv4.DHCPv4) string {
+	if pack.MessageType() == nil {
+		return "BOOTREPLY"
+	}
+	return fmt.Sprintf("DHCP%s", pack.MessageType())
+}
+
 func (c *DHCPV4Conn) Close() error {
+	_ = c.relayConn.Close()
+
 	return c.conn.Close()
 }
 
+// discard reads the packets of the socket and drops them, until the socket is closed.
+func discard(conn *net.UDPConn) {
+	p := make([]byte, dhcpv4.MaxUDPReceivedPacketSize)
+	for {
+		if _, _, err := conn.ReadFrom(p); err != nil {
+			return
+		}
+	}
+}
+
 func (c *DHCPV4Conn) readFrom() (*layers.Ethernet, *layers.IPv4, *layers.UDP, []byte, error) {
 	p := make([]byte, dhcpv4.MaxUDPReceivedPacketSize)
 
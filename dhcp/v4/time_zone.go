@@ -0,0 +1,89 @@
+package v4
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This implements the Timezone Options
+// https://tools.ietf.org/html/rfc4833
+
+// zoneInfoDirs are the places where the tz database is usually installed.
+// Like in the time package, the ZONEINFO environment variable takes precedence.
+var zoneInfoDirs = []string{
+	"/usr/share/zoneinfo/",
+	"/usr/share/lib/zoneinfo/",
+	"/usr/lib/locale/TZ/",
+}
+
+// unresolvedTimeZones are the time zones whose POSIX TZ string couldn't be read, so each is only logged once.
+var unresolvedTimeZones sync.Map
+
+// TimeZoneOptions returns the POSIX TZ string (100) and the tz database name (101) of the time zone.
+// The POSIX TZ string is omitted if it can't be read from the tz database.
+func TimeZoneOptions(name string) []dhcpv4.Option {
+	options := make([]dhcpv4.Option, 0, 2)
+
+	posixTZ, err := PosixTimeZone(name)
+	if err == nil {
+		options = append(options, &dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionPCode, Data: []byte(posixTZ)})
+	} else if _, logged := unresolvedTimeZones.LoadOrStore(name, true); !logged {
+		log.Printf("Can't send the POSIX TZ string of the time zone '%s', only its name: %s", name, err)
+	}
+
+	return append(options, &dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionTCode, Data: []byte(name)})
+}
+
+// PosixTimeZone returns the POSIX TZ string of the time zone, e.g. 'CET-1CEST,M3.5.0,M10.5.0/3' for 'Europe/Zurich'.
+// It is the footer of the zone's TZif file, which is present from version 2 on.
+// See https://tools.ietf.org/html/rfc8536#section-3.3
+func PosixTimeZone(name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.Contains(name, "..") {
+		return "", fmt.Errorf("'%s' is not a valid time zone name", name)
+	}
+
+	data, err := readZoneInfo(name)
+	if err != nil {
+		return "", err
+	}
+
+	if len(data) < 5 || string(data[0:4]) != "TZif" || data[4] < '2' {
+		return "", fmt.Errorf("the tz database entry of '%s' has no POSIX TZ string", name)
+	}
+
+	if data[len(data)-1] != '\n' {
+		return "", fmt.Errorf("the tz database entry of '%s' ends without a footer", name)
+	}
+
+	footer := data[:len(data)-1]
+	start := bytes.LastIndexByte(footer, '\n')
+	if start < 0 || start == len(footer)-1 {
+		return "", fmt.Errorf("the tz database entry of '%s' has an empty footer", name)
+	}
+
+	return string(footer[start+1:]), nil
+}
+
+func readZoneInfo(name string) ([]byte, error) {
+	dirs := zoneInfoDirs
+	if zoneInfo := os.Getenv("ZONEINFO"); zoneInfo != "" {
+		dirs = append([]string{zoneInfo}, dirs...)
+	}
+
+	for _, dir := range dirs {
+		data, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err == nil {
+			return data, nil
+		}
+	}
+
+	return nil, fmt.Errorf("the time zone '%s' is not in the tz database", name)
+}
@@ -0,0 +1,103 @@
+package v4
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withZoneInfo points ZONEINFO to a directory with the given TZif files for the duration of the test.
+func withZoneInfo(t *testing.T, files map[string]string) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "zoneinfo")
+	if err != nil {
+		t.Fatalf("can't create the directory: %s", err)
+	}
+
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("can't create the directory of '%s': %s", name, err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("can't write '%s': %s", name, err)
+		}
+	}
+
+	previous, wasSet := os.LookupEnv("ZONEINFO")
+	os.Setenv("ZONEINFO", dir)
+
+	return func() {
+		if wasSet {
+			os.Setenv("ZONEINFO", previous)
+		} else {
+			os.Unsetenv("ZONEINFO")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPosixTimeZone(t *testing.T) {
+	// The header and data blocks are irrelevant, only the footer after the last two newlines is read.
+	defer withZoneInfo(t, map[string]string{
+		"Europe/Zurich":   "TZif2\x00\x00\x00data of version 1\nTZif2 data\nCET-1CEST,M3.5.0,M10.5.0/3\n",
+		"Etc/Version1":    "TZif\x00\x00\x00\x00data of version 1",
+		"Etc/NoFooter":    "TZif2\x00\x00\x00data without footer",
+		"Etc/EmptyFooter": "TZif2\x00\x00\x00data\n\n",
+		"Etc/NotTZif":     "something else\nUTC0\n",
+	})()
+
+	tests := []struct {
+		name    string
+		want    string
+		wantErr bool
+	}{
+		{name: "Europe/Zurich", want: "CET-1CEST,M3.5.0,M10.5.0/3"},
+		{name: "Etc/Version1", wantErr: true},
+		{name: "Etc/NoFooter", wantErr: true},
+		{name: "Etc/EmptyFooter", wantErr: true},
+		{name: "Etc/NotTZif", wantErr: true},
+		{name: "Mars/Olympus_Mons", wantErr: true},
+		{name: "../Europe/Zurich", wantErr: true},
+		{name: "/etc/passwd", wantErr: true},
+		{name: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PosixTimeZone(tt.name)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got '%s'", got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got '%s', want '%s'", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTimeZoneOptions(t *testing.T) {
+	defer withZoneInfo(t, map[string]string{
+		"Europe/Zurich": "TZif2\x00\x00\x00data\nCET-1CEST,M3.5.0,M10.5.0/3\n",
+	})()
+
+	options := TimeZoneOptions("Europe/Zurich")
+	if len(options) != 2 || string(options[0].ToBytes()[2:]) != "CET-1CEST,M3.5.0,M10.5.0/3" ||
+		string(options[1].ToBytes()[2:]) != "Europe/Zurich" {
+		t.Errorf("expected the POSIX TZ string and the name, got %v", options)
+	}
+
+	options = TimeZoneOptions("Mars/Olympus_Mons")
+	if len(options) != 1 || string(options[0].ToBytes()[2:]) != "Mars/Olympus_Mons" {
+		t.Errorf("expected only the name of an unknown time zone, got %v", options)
+	}
+}
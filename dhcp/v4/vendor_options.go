@@ -0,0 +1,142 @@
+package v4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// This implements the Vendor Specific Information option
+// https://tools.ietf.org/html/rfc2132#section-8.4
+// and the Vendor-Identifying Vendor Options
+// https://tools.ietf.org/html/rfc3925
+
+const (
+	OptionVendorIdentifyingVendorClass    dhcpv4.OptionCode = 124
+	OptionVendorIdentifyingVendorSpecific dhcpv4.OptionCode = 125
+)
+
+// VendorSubOptionTemplate describes a vendor sub-option.
+// Its value is taken from the client's vendor values by Key, or is Value if the client has no such value.
+type VendorSubOptionTemplate struct {
+	Code  int
+	Type  string
+	Key   string
+	Value interface{}
+}
+
+// VendorOptionTemplate describes the vendor sub-options for the clients of a vendor.
+// If VendorClass is set, the sub-options are sent in option 43 to clients whose vendor class (option 60) starts with it.
+// If Enterprise is set, the sub-options are sent in option 125 to clients that name the enterprise in option 124.
+type VendorOptionTemplate struct {
+	VendorClass string
+	Enterprise  uint32
+	SubOptions  []VendorSubOptionTemplate
+}
+
+// ParseVendorEnterprises returns the enterprise numbers of the Vendor-Identifying Vendor Class option.
+// See https://tools.ietf.org/html/rfc3925#section-3
+func ParseVendorEnterprises(data []byte) []uint32 {
+	var enterprises []uint32
+	for len(data) >= 5 {
+		length := int(data[4])
+		if len(data) < 5+length {
+			break
+		}
+
+		enterprises = append(enterprises, binary.BigEndian.Uint32(data[:4]))
+		data = data[5+length:]
+	}
+	return enterprises
+}
+
+// VendorOptions returns the options 43 and 125 for the client, built from the templates that match it.
+// Option 43 is only built from the first matching template, as it can't hold the sub-options of several vendors.
+func VendorOptions(templates []VendorOptionTemplate, requestInfo *RequestInfoV4, values map[string]interface{}) []dhcpv4.Option {
+	var options []dhcpv4.Option
+	var vendorSpecific, vendorIdentifying []byte
+	for _, template := range templates {
+		if vendorSpecific == nil && template.VendorClass != "" && strings.HasPrefix(requestInfo.VendorClass, template.VendorClass) {
+			vendorSpecific = template.encode(values)
+		}
+
+		if template.Enterprise != 0 && requestInfo.hasVendorEnterprise(template.Enterprise) {
+			subOptions := template.encode(values)
+			if len(subOptions) == 0 || len(vendorIdentifying)+5+len(subOptions) > 255 {
+				continue
+			}
+
+			enterprise := make([]byte, 4)
+			binary.BigEndian.PutUint32(enterprise, template.Enterprise)
+			vendorIdentifying = append(vendorIdentifying, enterprise...)
+			vendorIdentifying = append(vendorIdentifying, byte(len(subOptions)))
+			vendorIdentifying = append(vendorIdentifying, subOptions...)
+		}
+	}
+
+	if len(vendorSpecific) > 0 {
+		options = append(options, &dhcpv4.OptionGeneric{OptionCode: dhcpv4.OptionVendorSpecificInformation, Data: vendorSpecific})
+	}
+	if len(vendorIdentifying) > 0 {
+		options = append(options, &dhcpv4.OptionGeneric{OptionCode: OptionVendorIdentifyingVendorSpecific, Data: vendorIdentifying})
+	}
+
+	return options
+}
+
+func (r *RequestInfoV4) hasVendorEnterprise(enterprise uint32) bool {
+	for _, e := range r.VendorEnterprises {
+		if e == enterprise {
+			return true
+		}
+	}
+	return false
+}
+
+// encode returns the encoded sub-options. Sub-options without a value and invalid sub-options are left out.
+func (t VendorOptionTemplate) encode(values map[string]interface{}) []byte {
+	var data []byte
+	for _, subOption := range t.SubOptions {
+		value, ok := values[subOption.Key]
+		if !ok || subOption.Key == "" {
+			value = subOption.Value
+		}
+		if value == nil {
+			continue
+		}
+
+		encoded, err := encodeSubOption(subOption.Code, subOption.Type, value)
+		if err != nil {
+			log.Printf("Ignoring the vendor sub-option %d: %s", subOption.Code, err)
+			continue
+		}
+
+		if len(data)+len(encoded) > 255 {
+			log.Printf("Ignoring the vendor sub-option %d, as the vendor option would exceed 255 bytes.", subOption.Code)
+			continue
+		}
+
+		data = append(data, encoded...)
+	}
+	return data
+}
+
+func encodeSubOption(code int, optionType string, value interface{}) ([]byte, error) {
+	if code < 1 || code > 254 {
+		return nil, fmt.Errorf("invalid sub-option code %d", code)
+	}
+
+	data, err := encodeOptionValue(optionType, value)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > 253 {
+		return nil, fmt.Errorf("value is longer than 253 bytes")
+	}
+
+	return append([]byte{byte(code), byte(len(data))}, data...), nil
+}
@@ -0,0 +1,103 @@
+package v4
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestParseVendorEnterprises(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []uint32
+	}{
+		{name: "one", data: []byte{0, 0, 0x0d, 0xe9, 2, 1, 'x'}, want: []uint32{3561}},
+		{name: "two", data: []byte{0, 0, 0x0d, 0xe9, 0, 0, 0, 0, 9, 1, 'x'}, want: []uint32{3561, 9}},
+		{name: "truncated", data: []byte{0, 0, 0x0d, 0xe9, 0, 0, 0, 0, 9, 5, 'x'}, want: []uint32{3561}},
+		{name: "empty", data: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseVendorEnterprises(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVendorOptions(t *testing.T) {
+	templates := []VendorOptionTemplate{
+		{
+			VendorClass: "Aruba",
+			SubOptions: []VendorSubOptionTemplate{
+				{Code: 1, Type: "ip", Key: "controller", Value: "192.0.2.1"},
+				{Code: 2, Type: "string", Key: "group"},
+				{Code: 3, Type: "uint8", Value: 300}, // out of range, left out
+			},
+		},
+		{
+			VendorClass: "Aruba",
+			SubOptions:  []VendorSubOptionTemplate{{Code: 9, Type: "string", Value: "never sent"}},
+		},
+		{
+			Enterprise: 3561,
+			SubOptions: []VendorSubOptionTemplate{{Code: 1, Type: "string", Key: "oui", Value: "00A0C9"}},
+		},
+		{
+			Enterprise: 9,
+			SubOptions: []VendorSubOptionTemplate{{Code: 2, Type: "uint16", Value: 443}},
+		},
+	}
+	values := map[string]interface{}{"controller": "192.0.2.2", "group": "office"}
+
+	tests := []struct {
+		name        string
+		requestInfo RequestInfoV4
+		want        map[dhcpv4.OptionCode][]byte
+	}{
+		{
+			name:        "vendor class",
+			requestInfo: RequestInfoV4{VendorClass: "ArubaAP"},
+			want: map[dhcpv4.OptionCode][]byte{
+				dhcpv4.OptionVendorSpecificInformation: {1, 4, 192, 0, 2, 2, 2, 6, 'o', 'f', 'f', 'i', 'c', 'e'},
+			},
+		},
+		{
+			name:        "enterprises",
+			requestInfo: RequestInfoV4{VendorEnterprises: []uint32{9, 3561}},
+			want: map[dhcpv4.OptionCode][]byte{
+				OptionVendorIdentifyingVendorSpecific: {
+					0, 0, 0x0d, 0xe9, 8, 1, 6, '0', '0', 'A', '0', 'C', '9',
+					0, 0, 0, 9, 4, 2, 2, 0x01, 0xbb,
+				},
+			},
+		},
+		{
+			name:        "other vendor",
+			requestInfo: RequestInfoV4{VendorClass: "MSFT 5.0", VendorEnterprises: []uint32{311}},
+			want:        map[dhcpv4.OptionCode][]byte{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[dhcpv4.OptionCode][]byte)
+			for _, option := range VendorOptions(templates, &tt.requestInfo, values) {
+				got[option.Code()] = option.ToBytes()[2:]
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("got options %v, want %v", got, tt.want)
+			}
+			for code, want := range tt.want {
+				if !bytes.Equal(got[code], want) {
+					t.Errorf("got option %d with %v, want %v", code, got[code], want)
+				}
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ type ClientInfoV6 struct {
 	Options struct {
 		HostName          string
 		DomainName        string
+		DomainSearch      []string
 		DomainNameServers []net.IP
 		NTPServers        []net.IP
 	}
@@ -0,0 +1,286 @@
+package v6
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/raw"
+)
+
+// ErrNoAnswer is returned when no host answered within the timeout.
+var ErrNoAnswer = errors.New("no answer within the timeout")
+
+// neighborTTL is how long a resolved MAC is remembered.
+const neighborTTL = 1 * time.Minute
+
+// failureTTL is how long a failed lookup is remembered, so that retransmitting clients don't block the server
+// with a lookup each.
+const failureTTL = 10 * time.Second
+
+// solicitationTimeout is how long to wait for the answer to a Neighbor Solicitation.
+const solicitationTimeout = 500 * time.Millisecond
+
+// routeTable is where Linux exposes the IPv6 routing table.
+const routeTable = "/proc/net/ipv6_route"
+
+type neighbor struct {
+	hwAddr  net.HardwareAddr
+	err     error
+	expires time.Time
+}
+
+// NeighborCache resolves the MAC of the next hop towards an IP with Neighbor Discovery and remembers it for a while.
+// See https://tools.ietf.org/html/rfc4861#section-7.2
+type NeighborCache struct {
+	iface    net.Interface
+	senderIP net.IP
+
+	mutex     sync.Mutex
+	neighbors map[string]neighbor
+}
+
+// NewNeighborCache creates a cache for the interface, whose Neighbor Solicitations are sent from senderIP.
+func NewNeighborCache(iface net.Interface, senderIP net.IP) *NeighborCache {
+	return &NeighborCache{
+		iface:     iface,
+		senderIP:  senderIP,
+		neighbors: make(map[string]neighbor),
+	}
+}
+
+// Resolve returns the MAC to send a packet for the IP to.
+// That is the MAC of the host itself if it is on the link, and the MAC of the router towards it otherwise.
+// The result is remembered per IP, so the routing table is not read again until it expires.
+func (n *NeighborCache) Resolve(ip net.IP) (net.HardwareAddr, error) {
+	key := ip.String()
+
+	n.mutex.Lock()
+	cached, ok := n.neighbors[key]
+	n.mutex.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.hwAddr, cached.err
+	}
+
+	hwAddr, err := n.lookup(ip)
+
+	ttl := neighborTTL
+	if err != nil {
+		ttl = failureTTL
+	}
+
+	n.mutex.Lock()
+	n.neighbors[key] = neighbor{hwAddr: hwAddr, err: err, expires: time.Now().Add(ttl)}
+	n.mutex.Unlock()
+
+	return hwAddr, err
+}
+
+// lookup resolves the MAC of the next hop towards the IP with Neighbor Discovery.
+func (n *NeighborCache) lookup(ip net.IP) (net.HardwareAddr, error) {
+	nextHop, err := n.nextHop(ip)
+	if err != nil {
+		return nil, err
+	}
+
+	hwAddr, err := n.solicit(nextHop)
+	if err != nil {
+		return nil, fmt.Errorf("can't resolve the MAC of '%s': %s", nextHop, err)
+	}
+
+	return hwAddr, nil
+}
+
+// nextHop returns the IP itself if it is link-local or in one of the networks of the interface,
+// and the router of the most specific route of the interface towards it otherwise.
+func (n *NeighborCache) nextHop(ip net.IP) (net.IP, error) {
+	if ip.IsLinkLocalUnicast() {
+		return ip, nil
+	}
+
+	addrs, err := n.iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.Contains(ip) {
+			return ip, nil
+		}
+	}
+
+	return routeNextHop(n.iface.Name, ip)
+}
+
+// solicit sends a Neighbor Solicitation for the target to its solicited-node multicast address
+// and returns the link-layer address of the Neighbor Advertisement that answers it.
+// See https://tools.ietf.org/html/rfc4861#section-4.3
+func (n *NeighborCache) solicit(target net.IP) (net.HardwareAddr, error) {
+	conn, err := raw.ListenPacket(&n.iface, uint16(layers.EthernetTypeIPv6), &raw.Config{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	p, dstMAC, err := neighborSolicitation(n.iface.HardwareAddr, n.senderIP, target)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.WriteTo(p, &raw.Addr{HardwareAddr: dstMAC})
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(solicitationTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		l, _, err := conn.ReadFrom(buf)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, ErrNoAnswer
+		} else if err != nil {
+			return nil, err
+		}
+
+		if hwAddr, ok := advertisedHwAddr(buf[:l], target); ok {
+			return hwAddr, nil
+		}
+	}
+}
+
+// neighborSolicitation returns the Ethernet frame of a Neighbor Solicitation for the target
+// and the MAC of the target's solicited-node multicast address it is sent to.
+func neighborSolicitation(srcMAC net.HardwareAddr, srcIP, target net.IP) ([]byte, net.HardwareAddr, error) {
+	// See https://tools.ietf.org/html/rfc4291#section-2.7.1
+	solicitedNode := net.ParseIP("ff02::1:ff00:0")
+	copy(solicitedNode[13:], target.To16()[13:])
+	dstMAC := ip6ToHwAddr(solicitedNode)
+
+	eth := layers.Ethernet{ // IEEE 802.3
+		DstMAC:       dstMAC,
+		SrcMAC:       srcMAC,
+		EthernetType: layers.EthernetTypeIPv6,
+	}
+
+	ip6 := layers.IPv6{ // RFC 8200
+		Version:    6,
+		NextHeader: layers.IPProtocolICMPv6,
+		// RFC4861, Section 7.1.1: Neighbor Discovery messages must have a hop limit of 255.
+		HopLimit: 255,
+		SrcIP:    srcIP,
+		DstIP:    solicitedNode,
+	}
+
+	icmp6 := layers.ICMPv6{ // RFC 4443
+		TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborSolicitation, 0),
+	}
+	if err := icmp6.SetNetworkLayerForChecksum(&ip6); err != nil {
+		return nil, nil, err
+	}
+
+	solicitation := layers.ICMPv6NeighborSolicitation{
+		TargetAddress: target.To16(),
+		Options: layers.ICMPv6Options{
+			{Type: layers.ICMPv6OptSourceAddress, Data: srcMAC},
+		},
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	err := gopacket.SerializeLayers(buf, opts, &eth, &ip6, &icmp6, &solicitation)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return buf.Bytes(), dstMAC, nil
+}
+
+// advertisedHwAddr returns the link-layer address of the target, if the Ethernet frame is a Neighbor Advertisement for it.
+// See https://tools.ietf.org/html/rfc4861#section-4.4
+func advertisedHwAddr(p []byte, target net.IP) (net.HardwareAddr, bool) {
+	pack := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+
+	advertisement, ok := pack.Layer(layers.LayerTypeICMPv6NeighborAdvertisement).(*layers.ICMPv6NeighborAdvertisement)
+	if !ok || advertisement == nil || !advertisement.TargetAddress.Equal(target) {
+		return nil, false
+	}
+
+	// The Target Link-Layer Address option is omitted in answers to unicast solicitations only.
+	for _, option := range advertisement.Options {
+		if option.Type == layers.ICMPv6OptTargetAddress && len(option.Data) >= 6 {
+			return net.HardwareAddr(option.Data[:6]), true
+		}
+	}
+
+	if ethLayer, ok := pack.Layer(layers.LayerTypeEthernet).(*layers.Ethernet); ok && ethLayer != nil {
+		return ethLayer.SrcMAC, true
+	}
+
+	return nil, false
+}
+
+// routeNextHop looks up the next hop of the most specific route of the interface that matches the IP.
+func routeNextHop(ifaceName string, ip net.IP) (net.IP, error) {
+	file, err := os.Open(routeTable)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return parseRouteTable(file, ifaceName, ip)
+}
+
+// parseRouteTable finds the next hop of the most specific route of the interface that matches the IP
+// in a route table in the format of /proc/net/ipv6_route.
+func parseRouteTable(routes io.Reader, ifaceName string, ip net.IP) (net.IP, error) {
+	var nextHop net.IP
+	bestOnes := -1
+
+	scanner := bufio.NewScanner(routes)
+	for scanner.Scan() {
+		// destination, prefix length, source, source prefix length, next hop, metric, refcnt, use, flags, iface
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[9] != ifaceName {
+			continue
+		}
+
+		destination, err1 := hex.DecodeString(fields[0])
+		ones, err2 := strconv.ParseInt(fields[1], 16, 32)
+		hop, err3 := hex.DecodeString(fields[4])
+		if err1 != nil || err2 != nil || err3 != nil || len(destination) != net.IPv6len || len(hop) != net.IPv6len {
+			continue
+		}
+
+		route := net.IPNet{IP: destination, Mask: net.CIDRMask(int(ones), 128)}
+		if route.Contains(ip) && int(ones) > bestOnes {
+			nextHop, bestOnes = hop, int(ones)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if nextHop == nil {
+		return nil, fmt.Errorf("no route to '%s' on '%s'", ip, ifaceName)
+	} else if nextHop.Equal(net.IPv6zero) {
+		// A route without next hop means the network is directly attached.
+		return ip, nil
+	}
+
+	return nextHop, nil
+}
@@ -0,0 +1,172 @@
+package v6
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// destination, prefix length, source, source prefix length, next hop, metric, refcnt, use, flags, iface
+const testRouteTable = `20010db8000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+20010db8000100000000000000000000 30 00000000000000000000000000000000 00 20010db8000000000000000000000002 00000400 00000001 00000000 00000003     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00000003     eth0
+20010db8000200000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth1
+invalid 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth1
+`
+
+func TestParseRouteTable(t *testing.T) {
+	tests := []struct {
+		iface   string
+		ip      string
+		want    string
+		wantErr bool
+	}{
+		{iface: "eth0", ip: "2001:db8::5", want: "2001:db8::5"},
+		{iface: "eth0", ip: "2001:db8:1:2::1", want: "2001:db8::2"},
+		{iface: "eth0", ip: "2001:db8:2::1", want: "fe80::1"},
+		{iface: "eth1", ip: "2001:db8:2::1", want: "2001:db8:2::1"},
+		{iface: "eth1", ip: "2001:db8::5", wantErr: true},
+		{iface: "eth2", ip: "2001:db8::5", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.iface+" "+tt.ip, func(t *testing.T) {
+			nextHop, err := parseRouteTable(strings.NewReader(testRouteTable), tt.iface, net.ParseIP(tt.ip))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got '%s'", nextHop)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !nextHop.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("got '%s', want '%s'", nextHop, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeighborSolicitation(t *testing.T) {
+	srcMAC := net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	srcIP := net.ParseIP("fe80::1")
+	target := net.ParseIP("2001:db8::12:3456")
+
+	p, dstMAC, err := neighborSolicitation(srcMAC, srcIP, target)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// RFC4291, Section 2.7.1: The solicited-node multicast address of the target.
+	if want := "33:33:ff:12:34:56"; dstMAC.String() != want {
+		t.Errorf("got destination MAC '%s', want '%s'", dstMAC, want)
+	}
+
+	pack := gopacket.NewPacket(p, layers.LayerTypeEthernet, gopacket.Default)
+	ip6, ok := pack.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	if !ok {
+		t.Fatal("expected an IPv6 packet")
+	}
+	if !ip6.DstIP.Equal(net.ParseIP("ff02::1:ff12:3456")) || !ip6.SrcIP.Equal(srcIP) || ip6.HopLimit != 255 {
+		t.Errorf("got IPv6 from '%s' to '%s' with hop limit %d", ip6.SrcIP, ip6.DstIP, ip6.HopLimit)
+	}
+
+	solicitation, ok := pack.Layer(layers.LayerTypeICMPv6NeighborSolicitation).(*layers.ICMPv6NeighborSolicitation)
+	if !ok {
+		t.Fatal("expected a Neighbor Solicitation")
+	}
+	if !solicitation.TargetAddress.Equal(target) {
+		t.Errorf("got target '%s', want '%s'", solicitation.TargetAddress, target)
+	}
+	if len(solicitation.Options) != 1 || solicitation.Options[0].Type != layers.ICMPv6OptSourceAddress ||
+		net.HardwareAddr(solicitation.Options[0].Data).String() != srcMAC.String() {
+		t.Errorf("expected the source link-layer address option, got %v", solicitation.Options)
+	}
+}
+
+// neighborAdvertisement returns an Ethernet frame with a Neighbor Advertisement for the target.
+func neighborAdvertisement(t *testing.T, srcMAC net.HardwareAddr, target net.IP, options layers.ICMPv6Options) []byte {
+	t.Helper()
+
+	eth := layers.Ethernet{DstMAC: net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, SrcMAC: srcMAC, EthernetType: layers.EthernetTypeIPv6}
+	ip6 := layers.IPv6{Version: 6, NextHeader: layers.IPProtocolICMPv6, HopLimit: 255, SrcIP: target, DstIP: net.ParseIP("fe80::1")}
+	icmp6 := layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(layers.ICMPv6TypeNeighborAdvertisement, 0)}
+	if err := icmp6.SetNetworkLayerForChecksum(&ip6); err != nil {
+		t.Fatalf("can't set the network layer: %s", err)
+	}
+	advertisement := layers.ICMPv6NeighborAdvertisement{Flags: 0x60, TargetAddress: target, Options: options}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{ComputeChecksums: true, FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip6, &icmp6, &advertisement); err != nil {
+		t.Fatalf("can't serialize the advertisement: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestAdvertisedHwAddr(t *testing.T) {
+	target := net.ParseIP("2001:db8::2")
+	targetMAC := net.HardwareAddr{0, 0xaa, 0xbb, 0xcc, 0xdd, 0xee}
+	routerMAC := net.HardwareAddr{0, 0xaa, 0xbb, 0xcc, 0xdd, 0xff}
+	targetOption := layers.ICMPv6Options{{Type: layers.ICMPv6OptTargetAddress, Data: targetMAC}}
+
+	tests := []struct {
+		name   string
+		p      []byte
+		want   net.HardwareAddr
+		wantOK bool
+	}{
+		{
+			name:   "target link-layer address",
+			p:      neighborAdvertisement(t, routerMAC, target, targetOption),
+			want:   targetMAC,
+			wantOK: true,
+		},
+		{
+			name:   "without target link-layer address",
+			p:      neighborAdvertisement(t, routerMAC, target, nil),
+			want:   routerMAC,
+			wantOK: true,
+		},
+		{
+			name: "other target",
+			p:    neighborAdvertisement(t, routerMAC, net.ParseIP("2001:db8::3"), targetOption),
+		},
+		{
+			name: "not an advertisement",
+			p:    make([]byte, 64),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := advertisedHwAddr(tt.p, target)
+			if ok != tt.wantOK || got.String() != tt.want.String() {
+				t.Errorf("got '%s' (%v), want '%s' (%v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNeighborCacheRemembers(t *testing.T) {
+	n := NewNeighborCache(net.Interface{Name: "eth0"}, net.ParseIP("fe80::1"))
+	hwAddr := net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}
+	errFailed := errors.New("no answer")
+
+	n.neighbors["2001:db8::1"] = neighbor{hwAddr: hwAddr, expires: time.Now().Add(neighborTTL)}
+	n.neighbors["2001:db8::2"] = neighbor{err: errFailed, expires: time.Now().Add(failureTTL)}
+
+	if got, err := n.Resolve(net.ParseIP("2001:db8::1")); err != nil || got.String() != hwAddr.String() {
+		t.Errorf("got '%s' (%v), want the remembered '%s'", got, err, hwAddr)
+	}
+	if _, err := n.Resolve(net.ParseIP("2001:db8::2")); err != errFailed {
+		t.Errorf("got error '%v', want the remembered failure", err)
+	}
+}
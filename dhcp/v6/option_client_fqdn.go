@@ -0,0 +1,71 @@
+package v6
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// This implements the Client FQDN option
+// https://tools.ietf.org/html/rfc4704
+
+const (
+	// FQDNFlagS indicates that the server performs the AAAA record update.
+	FQDNFlagS uint8 = 1 << 0
+	// FQDNFlagO indicates that the server overrode the client's preference to update the AAAA record itself.
+	FQDNFlagO uint8 = 1 << 1
+	// FQDNFlagN indicates that the server performs no updates at all.
+	FQDNFlagN uint8 = 1 << 2
+)
+
+// ClientFQDN is the content of the Client FQDN option.
+type ClientFQDN struct {
+	Flags      uint8
+	DomainName string
+}
+
+// ParseClientFQDN parses the data of the Client FQDN option.
+// See https://tools.ietf.org/html/rfc4704#section-4
+func ParseClientFQDN(data []byte) (ClientFQDN, error) {
+	if len(data) < 1 {
+		return ClientFQDN{}, fmt.Errorf("the Client FQDN option is empty")
+	}
+
+	var labels []string
+	rest := data[1:]
+	for len(rest) > 0 {
+		length := int(rest[0])
+		if length == 0 {
+			labels = append(labels, "")
+			break
+		}
+		if length > 63 || len(rest) < 1+length {
+			return ClientFQDN{}, fmt.Errorf("the Client FQDN option contains an invalid domain name")
+		}
+
+		labels = append(labels, string(rest[1:1+length]))
+		rest = rest[1+length:]
+	}
+
+	return ClientFQDN{Flags: data[0], DomainName: strings.Join(labels, ".")}, nil
+}
+
+// Option returns the Client FQDN option with the fully qualified domain name.
+func (c ClientFQDN) Option() layers.DHCPv6Option {
+	data := []byte{c.Flags}
+	for _, label := range strings.Split(strings.TrimSuffix(c.DomainName, "."), ".") {
+		if label == "" {
+			continue
+		}
+		data = append(data, byte(len(label)))
+		data = append(data, label...)
+	}
+	data = append(data, 0)
+
+	return layers.DHCPv6Option{
+		Code: layers.DHCPv6OptClientFQDN,
+		// Length: 0, fixed by the serializer
+		Data: data,
+	}
+}
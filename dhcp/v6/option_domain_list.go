@@ -0,0 +1,33 @@
+package v6
+
+import (
+	"strings"
+
+	"github.com/google/gopacket/layers"
+)
+
+// This implements the Domain Search List option
+// https://tools.ietf.org/html/rfc3646#section-4
+
+// DomainListOption returns the Domain Search List option with the domains.
+// Unlike in DHCPv4, the domain names are not compressed.
+// See https://tools.ietf.org/html/rfc8415#section-10
+func DomainListOption(domains []string) layers.DHCPv6Option {
+	var data []byte
+	for _, domain := range domains {
+		for _, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+			if label == "" {
+				continue
+			}
+			data = append(data, byte(len(label)))
+			data = append(data, label...)
+		}
+		data = append(data, 0)
+	}
+
+	return layers.DHCPv6Option{
+		Code: layers.DHCPv6OptDomainList,
+		// Length: 0, fixed by the serializer
+		Data: data,
+	}
+}
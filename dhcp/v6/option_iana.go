@@ -0,0 +1,68 @@
+package v6
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/google/gopacket/layers"
+)
+
+// NoAddrsAvailOption returns an IA_NA without addresses, whose status tells the client that there are none for it.
+// See https://tools.ietf.org/html/rfc8415#section-18.3.2
+func NoAddrsAvailOption(iaid IAID, message string) (layers.DHCPv6Option, error) {
+	iana := IANontemporaryAddress{
+		IAID:             iaid,
+		StatusCodeOption: statusCodeOption{code: layers.DHCPv6StatusCodeNoAddrsAvail, message: message},
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := iana.encodeDataTo(buf); err != nil {
+		return layers.DHCPv6Option{}, err
+	}
+
+	return layers.DHCPv6Option{
+		Code: layers.DHCPv6OptIANA,
+		// Length: 0, fixed by the serializer
+		Data: buf.Bytes(),
+	}, nil
+}
+
+// LeaseOption returns the IA_NA with the IPs of the info. The addresses the client asked for in its IA_NA that
+// are not among them are returned with lifetimes of 0, so that the client stops using them.
+// See https://tools.ietf.org/html/rfc8415#section-18.3.2
+func LeaseOption(iana IANontemporaryAddress, info ClientInfoV6) (layers.DHCPv6Option, error) {
+	data, err := EncodeOptions(iana.IAID, info)
+	if err != nil {
+		return layers.DHCPv6Option{}, err
+	}
+
+	var invalid iaAddresses
+	for _, ip := range InvalidAddresses(iana, info) {
+		invalid = append(invalid, iaAddress{
+			addr:             ip,
+			statusCodeOption: statusCodeOption{code: layers.DHCPv6StatusCodeSuccess},
+		})
+	}
+
+	buf := bytes.NewBuffer(data)
+	if _, err := invalid.encodeTo(buf); err != nil {
+		return layers.DHCPv6Option{}, err
+	}
+
+	return layers.DHCPv6Option{
+		Code: layers.DHCPv6OptIANA,
+		// Length: 0, fixed by the serializer
+		Data: buf.Bytes(),
+	}, nil
+}
+
+// InvalidAddresses returns the addresses the client asked for in its IA_NA that are not among the IPs of the info.
+func InvalidAddresses(iana IANontemporaryAddress, info ClientInfoV6) []net.IP {
+	var invalid []net.IP
+	for _, requested := range iana.AddressOptions {
+		if !isIpInList(requested.addr, info.IPAddrs) {
+			invalid = append(invalid, requested.addr)
+		}
+	}
+	return invalid
+}
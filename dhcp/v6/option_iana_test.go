@@ -0,0 +1,159 @@
+package v6
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+var testIAID = IAID{0, 0, 0, 1}
+
+func TestNoAddrsAvailOption(t *testing.T) {
+	opt, err := NoAddrsAvailOption(testIAID, "none")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []byte{
+		0, 0, 0, 1, // IAID
+		0, 0, 0, 0, // T1
+		0, 0, 0, 0, // T2
+		0, 13, 0, 6, 0, 2, 'n', 'o', 'n', 'e', // Status Code: NoAddrsAvail
+	}
+	if opt.Code != layers.DHCPv6OptIANA || !bytes.Equal(opt.Data, want) {
+		t.Errorf("got option %d with %v, want %d with %v", opt.Code, opt.Data, layers.DHCPv6OptIANA, want)
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	var info ClientInfoV6
+	info.IPAddrs = []net.IP{net.ParseIP("2001:db8::1")}
+	info.Timeouts.T1RenewalTime = time.Hour
+	info.Timeouts.T2RebindingTime = 2 * time.Hour
+	info.Timeouts.PreferredLifetime = 3 * time.Hour
+	info.Timeouts.ValidLifetime = 4 * time.Hour
+
+	data, err := EncodeOptions(testIAID, info)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	want := []byte{
+		0, 0, 0, 1, // IAID
+		0, 0, 0x0e, 0x10, // T1: 3600
+		0, 0, 0x1c, 0x20, // T2: 7200
+		0, 5, 0, 30, // IA Address
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0x2a, 0x30, // preferred lifetime: 10800
+		0, 0, 0x38, 0x40, // valid lifetime: 14400
+		0, 13, 0, 2, 0, 0, // Status Code of the address: Success
+		0, 13, 0, 2, 0, 0, // Status Code of the IA_NA: Success
+	}
+	if !bytes.Equal(data, want) {
+		t.Errorf("got %v, want %v", data, want)
+	}
+
+	iana, err := ParseIANAOption(layers.DHCPv6Option{Code: layers.DHCPv6OptIANA, Data: data})
+	if err != nil {
+		t.Fatalf("can't parse the encoded IA_NA: %s", err)
+	}
+	if iana.IAID != testIAID || len(iana.AddressOptions) != 1 ||
+		!iana.AddressOptions[0].addr.Equal(info.IPAddrs[0]) || iana.AddressOptions[0].validLifetime != 14400 {
+		t.Errorf("got %+v after parsing the encoded IA_NA", iana)
+	}
+}
+
+func TestLeaseOption(t *testing.T) {
+	var info ClientInfoV6
+	info.IPAddrs = []net.IP{net.ParseIP("2001:db8::1")}
+	info.Timeouts.ValidLifetime = time.Hour
+
+	requested := IANontemporaryAddress{
+		IAID: testIAID,
+		AddressOptions: iaAddresses{
+			{addr: net.ParseIP("2001:db8::1")},
+			{addr: net.ParseIP("2001:db8::2")},
+		},
+	}
+
+	opt, err := LeaseOption(requested, info)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	iana, err := ParseIANAOption(opt)
+	if err != nil {
+		t.Fatalf("can't parse the IA_NA: %s", err)
+	}
+
+	lifetimes := make(map[string]uint32)
+	for _, address := range iana.AddressOptions {
+		lifetimes[address.addr.String()] = address.validLifetime
+	}
+	if len(lifetimes) != 2 || lifetimes["2001:db8::1"] != 3600 || lifetimes["2001:db8::2"] != 0 {
+		t.Errorf("expected the leased address and the other one with a lifetime of 0, got %v", lifetimes)
+	}
+}
+
+func TestParseIANAOption(t *testing.T) {
+	header := []byte{0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0}
+	address := []byte{
+		0, 5, 0, 30,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0, 13, 0, 4, 0, 6, 'n', 'o',
+	}
+	status := []byte{0, 13, 0, 4, 0, 2, 'n', 'o'}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		wantStatus layers.DHCPv6StatusCode
+		wantErr    bool
+	}{
+		{name: "header only", data: header},
+		{name: "address with status", data: append(append([]byte{}, header...), address...)},
+		{name: "status", data: append(append([]byte{}, header...), status...), wantStatus: layers.DHCPv6StatusCodeNoAddrsAvail},
+		{name: "short", data: header[:11], wantErr: true},
+		{name: "empty", data: nil, wantErr: true},
+		{name: "truncated sub-option", data: append(append([]byte{}, header...), address[:20]...), wantErr: true},
+		{name: "short address", data: append(append([]byte{}, header...), 0, 5, 0, 2, 0, 0), wantErr: true},
+		{name: "short status", data: append(append([]byte{}, header...), 0, 13, 0, 1, 0), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			iana, err := ParseIANAOption(layers.DHCPv6Option{Code: layers.DHCPv6OptIANA, Data: tt.data})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", iana)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if iana.IAID != testIAID || iana.StatusCodeOption.code != tt.wantStatus {
+				t.Errorf("got %+v", iana)
+			}
+		})
+	}
+}
+
+func TestFindStatusCodeOpt(t *testing.T) {
+	other := []byte{0, 99, 0, 1, 0}
+	status := []byte{0, 13, 0, 6, 0, 2, 'n', 'o', 'n', 'e'}
+
+	got, ok := findStatusCodeOpt(append(append([]byte{}, other...), status...))
+	if !ok || got.code != layers.DHCPv6StatusCodeNoAddrsAvail || got.message != "none" {
+		t.Errorf("got %+v (%v), want NoAddrsAvail with 'none'", got, ok)
+	}
+
+	if got, ok := findStatusCodeOpt(status[:8]); ok {
+		t.Errorf("expected no status for a truncated option, got %+v", got)
+	}
+}
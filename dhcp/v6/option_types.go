@@ -51,10 +51,12 @@ type statusCodeOption struct {
 	message string
 }
 
+// encodeTo encodes the Status Code option, see https://tools.ietf.org/html/rfc8415#section-21.13
 func (sco statusCodeOption) encodeTo(buf *bytes.Buffer) (int, error) {
-	b := make([]byte, 4)
-	binary.BigEndian.PutUint16(b[0:2], uint16(sco.code))
-	binary.BigEndian.PutUint16(b[2:4], uint16(len(sco.message)))
+	b := make([]byte, 6)
+	binary.BigEndian.PutUint16(b[0:2], uint16(layers.DHCPv6OptStatusCode))
+	binary.BigEndian.PutUint16(b[2:4], uint16(2+len(sco.message)))
+	binary.BigEndian.PutUint16(b[4:6], uint16(sco.code))
 
 	n, err := buf.Write(b)
 	if err != nil {
@@ -89,6 +91,7 @@ type iaAddress struct {
 	otherOptions      iaOptions
 }
 
+// encodeTo encodes the IA Address option, see https://tools.ietf.org/html/rfc8415#section-21.6
 func (iaa iaAddress) encodeTo(buf *bytes.Buffer) (int, error) {
 	optionBuf := new(bytes.Buffer)
 	_, err := iaa.statusCodeOption.encodeTo(optionBuf)
@@ -102,7 +105,10 @@ func (iaa iaAddress) encodeTo(buf *bytes.Buffer) (int, error) {
 
 	b := make([]byte, 28)
 	binary.BigEndian.PutUint16(b[0:2], uint16(layers.DHCPv6OptIAAddr))
-	binary.BigEndian.PutUint16(b[2:4], uint16(optionBuf.Len()))
+	binary.BigEndian.PutUint16(b[2:4], uint16(24+optionBuf.Len()))
+	copy(b[4:20], iaa.addr.To16())
+	binary.BigEndian.PutUint32(b[20:24], iaa.preferredLifetime)
+	binary.BigEndian.PutUint32(b[24:28], iaa.validLifetime)
 
 	n, err := buf.Write(b)
 	if err != nil {
@@ -226,35 +232,55 @@ func EncodeOptions(iaid IAID, info ClientInfoV6) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// parseIANAOptions parses an IA Non-Temporary Address DHCPv6 Option
-func ParseIANAOption(ianaOpt layers.DHCPv6Option) IANontemporaryAddress {
+// ParseIANAOption parses an IA Non-Temporary Address DHCPv6 Option.
+// It returns an error if the option or one of its sub-options is shorter than it must be.
+// See https://tools.ietf.org/html/rfc8415#section-21.4
+func ParseIANAOption(ianaOpt layers.DHCPv6Option) (IANontemporaryAddress, error) {
+	if len(ianaOpt.Data) < 12 {
+		return IANontemporaryAddress{}, fmt.Errorf("the IA_NA has %d bytes instead of at least 12", len(ianaOpt.Data))
+	}
+
 	iana := IANontemporaryAddress{}
 	copy(iana.IAID[:], ianaOpt.Data[0:4])
 
-	addrOpt, statusOpt, otherOpt := parseIANASubOptions(ianaOpt.Data[12:])
+	addrOpt, statusOpt, otherOpt, err := parseIANASubOptions(ianaOpt.Data[12:])
+	if err != nil {
+		return IANontemporaryAddress{}, err
+	}
 
 	iana.AddressOptions = addrOpt
 	iana.StatusCodeOption = statusOpt
 	iana.OtherOptions = otherOpt
 
-	return iana
+	return iana, nil
 }
 
-func parseIANASubOptions(data []byte) ([]iaAddress, statusCodeOption, []iaOption) {
+func parseIANASubOptions(data []byte) ([]iaAddress, statusCodeOption, []iaOption, error) {
 	if len(data) < 4 {
-		return []iaAddress{}, statusCodeOption{}, []iaOption{}
+		return []iaAddress{}, statusCodeOption{}, []iaOption{}, nil
 	}
 
 	code := layers.DHCPv6Opt(binary.BigEndian.Uint16(data[:2]))
-	len := binary.BigEndian.Uint16(data[2:4])
+	length := int(binary.BigEndian.Uint16(data[2:4]))
 
-	thisOptData := data[:4+len]
-	rest := data[4+len:]
+	if len(data) < 4+length {
+		return nil, statusCodeOption{}, nil, fmt.Errorf("the option %d of the IA_NA is truncated", code)
+	}
 
-	iaAddresses, statusCodeOpt, iaOptions := parseIANASubOptions(rest)
+	thisOptData := data[:4+length]
+	rest := data[4+length:]
+
+	iaAddresses, statusCodeOpt, iaOptions, err := parseIANASubOptions(rest)
+	if err != nil {
+		return nil, statusCodeOption{}, nil, err
+	}
 
 	switch code {
 	case layers.DHCPv6OptIAAddr:
+		if len(thisOptData) < 28 {
+			return nil, statusCodeOption{}, nil, fmt.Errorf("the IA Address option has %d bytes instead of at least 28", len(thisOptData))
+		}
+
 		addrOpt := iaAddress{
 			addr:              thisOptData[4:20],
 			preferredLifetime: binary.BigEndian.Uint32(thisOptData[20:24]),
@@ -268,6 +294,10 @@ func parseIANASubOptions(data []byte) ([]iaAddress, statusCodeOption, []iaOption
 
 		iaAddresses = append(iaAddresses, addrOpt)
 	case layers.DHCPv6OptStatusCode:
+		if len(thisOptData) < 6 {
+			return nil, statusCodeOption{}, nil, fmt.Errorf("the Status Code option has %d bytes instead of at least 6", len(thisOptData))
+		}
+
 		statusCodeOpt = statusCodeOption{
 			code:    layers.DHCPv6StatusCode(binary.BigEndian.Uint16(thisOptData[4:6])),
 			message: string(thisOptData[6:]),
@@ -281,7 +311,7 @@ func parseIANASubOptions(data []byte) ([]iaAddress, statusCodeOption, []iaOption
 		iaOptions = append(iaOptions, otherOpt)
 	}
 
-	return iaAddresses, statusCodeOpt, iaOptions
+	return iaAddresses, statusCodeOpt, iaOptions, nil
 }
 
 // findStatusCodeOpt browses through the given data to find a DHCPv6 Status Option. It does this recursively.
@@ -292,13 +322,16 @@ func findStatusCodeOpt(data []byte) (statusCodeOption, bool) {
 		return statusCodeOption{}, false
 	}
 
-	endOfOption := binary.BigEndian.Uint16(data[2:4]) + 4 // 4 = status_code_len + opt_len,
+	endOfOption := int(binary.BigEndian.Uint16(data[2:4])) + 4 // 4 = status_code_len + opt_len,
+	if endOfOption > len(data) {
+		return statusCodeOption{}, false
+	}
 
 	optCode := layers.DHCPv6Opt(binary.BigEndian.Uint16(data[0:2]))
 	if optCode == layers.DHCPv6OptStatusCode {
 		option := statusCodeOption{
 			code:    layers.DHCPv6StatusCode(binary.BigEndian.Uint16(data[4:6])),
-			message: string(data[6 : 4+endOfOption]),
+			message: string(data[6:endOfOption]),
 		}
 
 		return option, true
@@ -25,6 +25,8 @@ type DHCPV6Conn struct {
 	iface  net.Interface
 	daddrs []net.IP
 	laddr  net.IP
+	// neighbors resolves the MACs of unicast destinations
+	neighbors *NeighborCache
 }
 
 // ListenDHCPv6 creates a connection that listens on the given interface for dhcpv6 traffic
@@ -68,7 +70,12 @@ func ListenDHCPv6(iface net.Interface, daddrs []net.IP, laddr net.IP) (*DHCPV6Co
 	}
 
 	//return &DHCPV6Conn{MulticastV6Conn: MulticastV6Conn{conn: conn}, iface: iface, daddrs: daddrs, laddr: laddr.To16()}, err
-	return &DHCPV6Conn{conn: conn, iface: iface, daddrs: daddrs, laddr: laddr.To16()}, err
+	return &DHCPV6Conn{conn: conn, iface: iface, daddrs: daddrs, laddr: laddr.To16(), neighbors: NewNeighborCache(iface, laddr.To16())}, err
+}
+
+// ResolveMAC returns the MAC of the next hop towards dstIP, see NeighborCache.
+func (c *DHCPV6Conn) ResolveMAC(dstIP net.IP) (net.HardwareAddr, error) {
+	return c.neighbors.Resolve(dstIP)
 }
 
 // ReadFrom returns the parsed packet, source IP, source MAC, error
@@ -6,11 +6,13 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"syscall"
 
 	"github.com/go-redis/redis"
 
 	redisCache "github.com/cimnine/netbox-dhcp/cache/redis"
 	"github.com/cimnine/netbox-dhcp/configuration"
+	"github.com/cimnine/netbox-dhcp/ddns"
 	"github.com/cimnine/netbox-dhcp/dhcp"
 	"github.com/cimnine/netbox-dhcp/netbox"
 	"github.com/cimnine/netbox-dhcp/resolver"
@@ -41,13 +43,33 @@ func main() {
 		log.Fatalln("The config contains inactive or missing sites. Please check the log.")
 	}
 
-	netboxOfferer := resolver.Netbox{Client: &netboxClient}
+	siteCache := resolver.NewSiteCache(resolver.SiteCacheTTL)
+	netboxOfferer := resolver.Netbox{Client: &netboxClient, Sites: siteCache}
 	redisCachingRequester := resolver.Redis{Client: &redisClient}
 
-	requester := resolver.CachingResolver{Source: netboxOfferer, Cache: redisCachingRequester}
+	requester := resolver.CachingResolver{
+		Source:           netboxOfferer,
+		Cache:            redisCachingRequester,
+		DeclineProbation: config.DHCP.DeclineProbationValue(),
+	}
+	if config.Netbox.DeclineTag != "" {
+		requester.DeclineHook = netboxOfferer
+	}
+	if config.Netbox.Pools {
+		requester.Pool = resolver.NetboxPool{Client: &netboxClient, Cache: redisCachingRequester, Sites: siteCache}
+	}
+
+	var dnsUpdater *ddns.Updater
+	if config.DDNS.Enabled {
+		dnsUpdater, err = ddns.NewUpdater(&config.DDNS, redisCachingRequester)
+		if err != nil {
+			log.Fatalln("Can't set up the dynamic DNS updates.", err)
+		}
+	}
 
-	d := dhcp.NewDaemon(&config, requester)
+	d := dhcp.NewDaemon(&config, requester, dnsUpdater)
 	setupShutdownHandler(d.Shutdown)
+	setupForceRenewHandler(d.ForceRenewV4)
 
 	d.Start()
 
@@ -71,3 +93,15 @@ func setupShutdownHandler(shutdown func()) {
 
 	log.Println("Quit with CTRL+C.")
 }
+
+// setupForceRenewHandler checks all leases for changes in Netbox when SIGUSR1 is received.
+func setupForceRenewHandler(forceRenew func()) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	go func() {
+		for range c {
+			log.Println("Checking the leases for changes.")
+			forceRenew()
+		}
+	}()
+}
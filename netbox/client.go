@@ -27,6 +27,35 @@ func (c *Client) GetSites() (res []models.Site, err error) {
 	return response.Result().(*models.SiteList).Sites, err
 }
 
+func (c *Client) GetSiteByID(id uint64) (res *models.Site, err error) {
+	response, err := c.request().
+		SetPathParams(map[string]string{"id": strconv.FormatUint(id, 10)}).
+		SetResult(models.Site{}).
+		Get(c.resolve(models.Site{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving the Site '%d'", id)
+		return nil, err
+	}
+
+	return response.Result().(*models.Site), nil
+}
+
+// FindConfigContextsBySite returns the active config contexts that are assigned to the Site.
+func (c *Client) FindConfigContextsBySite(siteID uint64) ([]models.ConfigContext, error) {
+	response, err := c.request().
+		SetQueryParams(map[string]string{"site_id": strconv.FormatUint(siteID, 10), "is_active": "True"}).
+		SetResult(models.ConfigContextList{}).
+		Get(c.resolve(models.ConfigContextList{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving the config contexts of the Site '%d'", siteID)
+		return []models.ConfigContext{}, err
+	}
+
+	return response.Result().(*models.ConfigContextList).ConfigContexts, nil
+}
+
 func (c *Client) request() *resty.Request {
 	return resty.R().
 		SetHeader("Accept", "application/json").
@@ -70,6 +99,11 @@ func (c *Client) CheckSites() bool {
 func (c *Client) FindInterfacesByMAC(mac string) (res []models.Interface, err error) {
 	mac = strings.ToUpper(mac)
 
+	// Netbox ignores an empty filter and would return all interfaces.
+	if mac == "" {
+		return nil, fmt.Errorf("can't look up interfaces without a MAC")
+	}
+
 	if !IsLikelyMAC(mac) {
 		log.Printf("'%s' does not seem to be a MAC address!", mac)
 	}
@@ -87,9 +121,28 @@ func (c *Client) FindInterfacesByMAC(mac string) (res []models.Interface, err er
 	return response.Result().(*models.InterfaceList).Interfaces, err
 }
 
+func (c *Client) FindInterfacesByDeviceAndName(deviceName, name string) (res []models.Interface, err error) {
+	response, err := c.request().
+		SetQueryParams(map[string]string{"device": deviceName, "name": name}).
+		SetResult(models.InterfaceList{}).
+		Get(c.resolve(models.InterfaceList{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving the interface '%s' of the Device '%s'", name, deviceName)
+		return nil, err
+	}
+
+	return response.Result().(*models.InterfaceList).Interfaces, err
+}
+
 func (c *Client) FindDevicesByMAC(mac string) (res []models.Device, err error) {
 	mac = strings.ToUpper(mac)
 
+	// Netbox ignores an empty filter and would return all devices.
+	if mac == "" {
+		return nil, fmt.Errorf("can't look up devices without a MAC")
+	}
+
 	if !IsLikelyMAC(mac) {
 		log.Printf("'%s' does not seem to be a MAC address!", mac)
 	}
@@ -121,19 +174,19 @@ func (c *Client) GetDeviceByID(id uint64) (res *models.Device, err error) {
 	return response.Result().(*models.Device), nil
 }
 
-func (c *Client) GetDeviceByDUID(duid string) (res *models.Device, err error) {
+func (c *Client) FindDevicesByDUID(duid string) (res []models.Device, err error) {
 	deviceDUIDField := c.Config.DeviceDUIDField
 	response, err := c.request().
-		SetPathParams(map[string]string{deviceDUIDField: duid}).
-		SetResult(models.Device{}).
-		Get(c.resolve(models.Device{}))
+		SetQueryParams(map[string]string{deviceDUIDField: duid}).
+		SetResult(models.DeviceList{}).
+		Get(c.resolve(models.DeviceList{}))
 
 	if err != nil {
-		log.Printf("An error occured while receiveing the Device by client id: '%s'='%s'", deviceDUIDField, duid)
+		log.Printf("An error occured while receiveing Devices by client id: '%s'='%s'", deviceDUIDField, duid)
 		return nil, err
 	}
 
-	return response.Result().(*models.Device), nil
+	return response.Result().(*models.DeviceList).Devices, nil
 }
 
 func (c *Client) GetIPAddressByID(id uint64) (res *models.IP, err error) {
@@ -164,6 +217,82 @@ func (c *Client) FindIPAddressesByInterfaceID(ifaceID uint64) ([]models.IP, erro
 	return response.Result().(*models.IPList).IPs, nil
 }
 
+func (c *Client) FindIPAddressesByAddress(address string) ([]models.IP, error) {
+	response, err := c.request().
+		SetQueryParams(map[string]string{"address": address}).
+		SetResult(models.IPList{}).
+		Get(c.resolve(models.IPList{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving IPs for the address '%s'", address)
+		return []models.IP{}, err
+	}
+
+	return response.Result().(*models.IPList).IPs, nil
+}
+
+func (c *Client) FindPoolPrefixesV4BySite(siteID string) ([]models.Prefix, error) {
+	response, err := c.request().
+		SetQueryParams(map[string]string{"site_id": siteID, "is_pool": "True", "family": "4", "status": "1"}).
+		SetResult(models.PrefixList{}).
+		Get(c.resolve(models.PrefixList{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving pool Prefixes for Site '%s'", siteID)
+		return []models.Prefix{}, err
+	}
+
+	return response.Result().(*models.PrefixList).Prefixes, nil
+}
+
+func (c *Client) FindPrefixesContaining(address string) ([]models.Prefix, error) {
+	response, err := c.request().
+		SetQueryParams(map[string]string{"contains": address}).
+		SetResult(models.PrefixList{}).
+		Get(c.resolve(models.PrefixList{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving Prefixes containing '%s'", address)
+		return []models.Prefix{}, err
+	}
+
+	return response.Result().(*models.PrefixList).Prefixes, nil
+}
+
+func (c *Client) GetAvailableIPsByPrefixID(prefixID uint64) ([]models.AvailableIP, error) {
+	response, err := c.request().
+		SetPathParams(map[string]string{"id": strconv.FormatUint(prefixID, 10)}).
+		SetQueryParams(map[string]string{"limit": "1000"}).
+		SetResult(models.AvailableIPList{}).
+		Get(c.resolve(models.AvailableIPList{}))
+
+	if err != nil {
+		log.Printf("An error occurred while receiving the available IPs of Prefix '%d'", prefixID)
+		return []models.AvailableIP{}, err
+	}
+
+	return *response.Result().(*models.AvailableIPList), nil
+}
+
+func (c *Client) SetIPAddressTags(id uint64, tags models.Tags) error {
+	response, err := c.request().
+		SetPathParams(map[string]string{"id": strconv.FormatUint(id, 10)}).
+		SetBody(map[string]models.Tags{"tags": tags}).
+		Patch(c.resolve(models.IP{}))
+
+	if err != nil {
+		log.Printf("An error occurred while updating the tags of the IP '%d'", id)
+		return err
+	}
+
+	if response.IsError() {
+		log.Printf("Netbox refused to update the tags of the IP '%d': %s", id, response.Status())
+		return fmt.Errorf("can't update the tags of the IP '%d': %s", id, response.Status())
+	}
+
+	return nil
+}
+
 func IsLikelyMAC(mac string) (isLikelyMAC bool) {
 	isLikelyMAC, err := regexp.MatchString("(?:[a-fA-F0-9]{2}:){5}[a-fA-F0-9]{2}", mac)
 	if err != nil {
@@ -8,6 +8,10 @@ type NetboxConfig struct {
 	Cache struct {
 		RawDuration string `yaml:"duration"`
 	}
-	Sites           []string
-	DeviceDUIDField string `yaml:"device_duid_field"`
+	Sites             []string
+	DeviceDUIDField   string `yaml:"device_duid_field"`
+	SwitchPortLookup  bool   `yaml:"switch_port_lookup"`
+	Pools             bool   `yaml:"pools"`
+	DeclineTag        string `yaml:"decline_tag"`
+	PrefixRoutesField string `yaml:"prefix_routes_field"`
 }
@@ -29,9 +29,10 @@ func (SiteList) Resolve() string {
 
 type Device struct {
 	NetboxObject
-	Name          string     `json:"name"`
-	PrimaryIP4    EmbeddedIP `json:"primary_ip4"`
-	PrimaryIP6    EmbeddedIP `json:"primary_ip6"`
+	Name          string       `json:"name"`
+	Site          EmbeddedSite `json:"site"`
+	PrimaryIP4    EmbeddedIP   `json:"primary_ip4"`
+	PrimaryIP6    EmbeddedIP   `json:"primary_ip6"`
 	ConfigContext struct {
 		DHCP DHCPConfigContext `json:"dhcp"`
 	} `json:"config_context"`
@@ -59,8 +60,19 @@ type EmbeddedDevice struct {
 
 type Interface struct {
 	NetboxObject
-	Device EmbeddedDevice `json:"device"`
-	Name   string         `json:"name"`
+	Device              EmbeddedDevice       `json:"device"`
+	Name                string               `json:"name"`
+	InterfaceConnection *InterfaceConnection `json:"interface_connection"`
+}
+
+type InterfaceConnection struct {
+	Interface EmbeddedInterface `json:"interface"`
+	Status    ConnectionStatus  `json:"status"`
+}
+
+type ConnectionStatus struct {
+	Value bool   `json:"value"`
+	Label string `json:"label"`
 }
 
 func (i Interface) Resolve() string {
@@ -84,11 +96,35 @@ type EmbeddedInterface struct {
 }
 
 type DHCPConfigContext struct {
-	Routers       []string `json:"routers"`
-	DomainName    string   `json:"domain_name"`
-	DNSServers    []string `json:"dns_servers"`
-	NTPServers    []string `json:"ntp_servers"`
-	NextServer    string   `json:"next_server"`
-	BootFileName  string   `json:"bootfile_name"`
-	LeaseDuration string   `json:"lease_duration"`
+	Routers               []string               `json:"routers"`
+	DomainName            string                 `json:"domain_name"`
+	DomainSearch          []string               `json:"domain_search"`
+	DNSServers            []string               `json:"dns_servers"`
+	NTPServers            []string               `json:"ntp_servers"`
+	NextServer            string                 `json:"next_server"`
+	BootFileName          string                 `json:"bootfile_name"`
+	LeaseDuration         string                 `json:"lease_duration"`
+	MinLeaseDuration      string                 `json:"min_lease_duration"`
+	MaxLeaseDuration      string                 `json:"max_lease_duration"`
+	ClasslessStaticRoutes []StaticRoute          `json:"classless_static_routes"`
+	Boot                  map[string]BootEntry   `json:"boot"`
+	Options               []CustomOption         `json:"options"`
+	VendorOptions         map[string]interface{} `json:"vendor_options"`
+}
+
+type CustomOption struct {
+	Code  int         `json:"code"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type BootEntry struct {
+	BootFileName string `json:"bootfile_name"`
+	NextServer   string `json:"next_server"`
+	HTTPURL      string `json:"http_url"`
+}
+
+type StaticRoute struct {
+	Destination string `json:"destination"`
+	Router      string `json:"router"`
 }
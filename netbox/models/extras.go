@@ -0,0 +1,24 @@
+package models
+
+type ConfigContext struct {
+	NetboxObject
+	Name     string `json:"name"`
+	Weight   int    `json:"weight"`
+	IsActive bool   `json:"is_active"`
+	Data     struct {
+		DHCP DHCPConfigContext `json:"dhcp"`
+	} `json:"data"`
+}
+
+func (c ConfigContext) Resolve() string {
+	return "extras/config-contexts/{id}/"
+}
+
+type ConfigContextList struct {
+	NetboxList
+	ConfigContexts []ConfigContext `json:"results"`
+}
+
+func (ConfigContextList) Resolve() string {
+	return "extras/config-contexts/"
+}
@@ -30,6 +30,21 @@ func (PrefixList) Resolve() string {
 	return "ipam/prefixes/"
 }
 
+type AvailableIP struct {
+	Family     uint8  `json:"family"`
+	RawAddress string `json:"address"`
+}
+
+func (ip AvailableIP) Address() (net.IP, *net.IPNet, error) {
+	return net.ParseCIDR(ip.RawAddress)
+}
+
+type AvailableIPList []AvailableIP
+
+func (AvailableIPList) Resolve() string {
+	return "ipam/prefixes/{id}/available-ips/"
+}
+
 type EmbeddedIP struct {
 	EmbeddedNetboxObject
 	Family     uint8  `json:"family"`
@@ -1,8 +1,12 @@
 package resolver
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/cimnine/netbox-dhcp/dhcp/v6"
 	"log"
+	"net"
+	"time"
 
 	"github.com/cimnine/netbox-dhcp/dhcp/v4"
 )
@@ -10,6 +14,7 @@ import (
 // A Sourcer assigns IPs based on a request
 type Sourcer interface {
 	Offerer
+	Informer
 	Solicitationer
 }
 
@@ -17,13 +22,28 @@ type Sourcer interface {
 type Cacher interface {
 	Acknowledger
 	Releaser
+	Claimer
+	Quarantiner
+	LeaseQuerier
+	IsQuarantinedV4(ip string) (bool, error)
 	ReserveV4(info *v4.ClientInfoV4, xid string) error
+	StoreLeaseV4ByMAC(info *v4.ClientInfoV4, xid, mac string) error
+	StoreLeaseV4ByID(info *v4.ClientInfoV4, xid, duid, iaid string) error
+	LookupV4ByMAC(info *v4.ClientInfoV4, mac string) error
+	LookupV4ByID(info *v4.ClientInfoV4, duid, iaid string) error
+	StoreLeaseV6(info *v6.ClientInfoV6, duid, iaid string) error
+	UpdateLeaseV4(lease *v4.LeaseV4) error
 }
 
 // Source and Cache are two independent implementations and are interchangeable
+// Pool is optional and hands out IPs to clients the Source does not know
+// DeclineHook is optional and is told about every declined IP
 type CachingResolver struct {
-	Source Sourcer
-	Cache  Cacher
+	Source           Sourcer
+	Cache            Cacher
+	Pool             Allocator
+	DeclineHook      Decliner
+	DeclineProbation time.Duration
 }
 
 func (r CachingResolver) SolicitationV6(info *v6.ClientInfoV6, clientID, clientMAC string, iaid string) (bool, error) {
@@ -38,19 +58,60 @@ func (r CachingResolver) SolicitationV6(info *v6.ClientInfoV6, clientID, clientM
 	return ok, nil
 }
 
+// RequestV6 looks up the IPs of the IA_NA like SolicitationV6 and records the binding in the cache.
+func (r CachingResolver) RequestV6(info *v6.ClientInfoV6, clientID, clientMAC string, iaid string) (bool, error) {
+	ok, err := r.Source.SolicitationV6(info, clientID, clientMAC, iaid)
+	if err != nil || !ok {
+		return ok, err
+	}
+
+	err = r.Cache.StoreLeaseV6(info, clientID, iaid)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeclineV4ByMAC marks the IP as not available and removes the client's lease,
+// as required by RFC2131 Section 4.3.3.
+// The client is offered another IP on its next DHCPDISCOVER, if there is one.
 func (r CachingResolver) DeclineV4ByMAC(xid, mac, ip string) error {
-	// This strictly speaking violates RFC2131 Section 4.3.3.
-	// But the source should only hand out IPs that are not yet taken anyway.
-	// At least as long as ip pools are not yet implemented.
-	log.Printf("The CachingResolver can't handle declines.")
+	err := r.Cache.QuarantineV4(ip, r.DeclineProbation, fmt.Sprintf("declined by MAC '%s'", mac))
+	if err != nil {
+		return err
+	}
+
+	err = r.Cache.ReleaseV4ByMAC(xid, mac, ip)
+	if err != nil {
+		log.Printf("Can't remove the lease of MAC '%s' for the declined IP '%s': %s", mac, ip, err)
+	}
+
+	if r.DeclineHook != nil {
+		_ = r.DeclineHook.DeclineV4ByMAC(xid, mac, ip)
+	}
+
 	return nil
 }
 
+// DeclineV4ByID marks the IP as not available and removes the client's lease,
+// as required by RFC2131 Section 4.3.3.
+// The client is offered another IP on its next DHCPDISCOVER, if there is one.
 func (r CachingResolver) DeclineV4ByID(xid, duid, iaid, ip string) error {
-	// This strictly speaking violates violates RFC2131 Section 4.3.3.
-	// But the source should only hand out IPs that are not yet taken anyway.
-	// At least as long as ip pools are not yet implemented.
-	log.Printf("The CachingResolver can't handle declines according to the RFC.")
+	err := r.Cache.QuarantineV4(ip, r.DeclineProbation, fmt.Sprintf("declined by DUID '%s' and IAID '%s'", duid, iaid))
+	if err != nil {
+		return err
+	}
+
+	err = r.Cache.ReleaseV4ByID(xid, duid, iaid, ip)
+	if err != nil {
+		log.Printf("Can't remove the lease of DUID '%s' and IAID '%s' for the declined IP '%s': %s", duid, iaid, ip, err)
+	}
+
+	if r.DeclineHook != nil {
+		_ = r.DeclineHook.DeclineV4ByID(xid, duid, iaid, ip)
+	}
+
 	return nil
 }
 
@@ -62,14 +123,18 @@ func (r CachingResolver) ReleaseV4ByID(xid, duid, iaid, ip string) error {
 	return r.Cache.ReleaseV4ByID(xid, duid, iaid, ip)
 }
 
-func (r CachingResolver) OfferV4ByMAC(info *v4.ClientInfoV4, xid, mac string) error {
-	err := r.Source.OfferV4ByMAC(info, xid, mac)
+func (r CachingResolver) QuarantineV4(ip string, duration time.Duration, reason string) error {
+	return r.Cache.QuarantineV4(ip, duration, reason)
+}
+
+func (r CachingResolver) OfferV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error {
+	err := r.lookupV4ByMAC(info, requestInfo, xid, mac)
 	if err != nil {
 		// TODO log message
 		return err
 	}
 
-	err = r.Cache.ReserveV4(info, xid)
+	err = r.reserveV4(info, requestInfo, xid)
 	if err != nil {
 		// TODO log message
 		return err
@@ -78,14 +143,14 @@ func (r CachingResolver) OfferV4ByMAC(info *v4.ClientInfoV4, xid, mac string) er
 	return nil
 }
 
-func (r CachingResolver) OfferV4ByID(info *v4.ClientInfoV4, xid, duid, iaid string) error {
-	err := r.Source.OfferV4ByID(info, xid, duid, iaid)
+func (r CachingResolver) OfferV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error {
+	err := r.lookupV4ByID(info, requestInfo, xid, duid, iaid)
 	if err != nil {
 		// TODO log message
 		return err
 	}
 
-	err = r.Cache.ReserveV4(info, xid)
+	err = r.reserveV4(info, requestInfo, xid)
 	if err != nil {
 		// TODO log message
 		return err
@@ -94,10 +159,263 @@ func (r CachingResolver) OfferV4ByID(info *v4.ClientInfoV4, xid, duid, iaid stri
 	return nil
 }
 
-func (r CachingResolver) AcknowledgeV4ByMAC(info *v4.ClientInfoV4, xid, mac, ip string) error {
-	return r.Cache.AcknowledgeV4ByMAC(info, xid, mac, ip)
+// CommitV4ByMAC looks up the IP like OfferV4ByMAC, but stores it as lease right away.
+func (r CachingResolver) CommitV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error {
+	err := r.lookupV4ByMAC(info, requestInfo, xid, mac)
+	if err != nil {
+		return err
+	}
+
+	return r.Cache.StoreLeaseV4ByMAC(info, xid, mac)
+}
+
+// CommitV4ByID looks up the IP like OfferV4ByID, but stores it as lease right away.
+func (r CachingResolver) CommitV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error {
+	err := r.lookupV4ByID(info, requestInfo, xid, duid, iaid)
+	if err != nil {
+		return err
+	}
+
+	return r.Cache.StoreLeaseV4ByID(info, xid, duid, iaid)
+}
+
+// lookupV4ByMAC finds the IP for the MAC in the source,
+// or in the pools if the source doesn't know the client or its IP is quarantined.
+// Other errors of the source are returned, so that clients aren't moved to the pools while the source is unavailable.
+// The lease time is the one the client requested, within the bounds of the info.
+func (r CachingResolver) lookupV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error {
+	err := r.Source.OfferV4ByMAC(info, requestInfo, xid, mac)
+	if err == nil {
+		err = r.checkQuarantine(info)
+	}
+	if (err == ErrNoRecord || err == ErrQuarantined) && r.Pool != nil {
+		log.Printf("The source has no usable IP for MAC '%s': %s. Trying the pools.", mac, err)
+		err = r.allocateV4(info, requestInfo, xid, func(leaseInfo *v4.ClientInfoV4) error {
+			return r.Cache.LookupV4ByMAC(leaseInfo, mac)
+		})
+	}
+	if err == nil {
+		info.ApplyRequestedLease(requestInfo.RequestedLeaseTime)
+	}
+	return err
+}
+
+// lookupV4ByID finds the IP for the DUID and IAID in the source, then the IP for the client's MAC,
+// and finally an IP in the pools if the source doesn't know the client or its IP is quarantined.
+// Clients whose MAC is documented in the source thereby get their IP, even if they send a client identifier.
+// Other errors of the source are returned, like in lookupV4ByMAC.
+// The lease time is the one the client requested, within the bounds of the info.
+func (r CachingResolver) lookupV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error {
+	err := r.Source.OfferV4ByID(info, requestInfo, xid, duid, iaid)
+	if err == ErrNoRecord && requestInfo.MAC != "" {
+		log.Printf("Can't find IPv4 for DUID '%s' and IAID '%s': %s. Trying with MAC '%s'.", duid, iaid, err, requestInfo.MAC)
+		err = r.Source.OfferV4ByMAC(info, requestInfo, xid, requestInfo.MAC)
+	}
+	if err == nil {
+		err = r.checkQuarantine(info)
+	}
+	if (err == ErrNoRecord || err == ErrQuarantined) && r.Pool != nil {
+		log.Printf("The source has no usable IP for DUID '%s' and IAID '%s': %s. Trying the pools.", duid, iaid, err)
+		err = r.allocateV4(info, requestInfo, xid, func(leaseInfo *v4.ClientInfoV4) error {
+			return r.Cache.LookupV4ByID(leaseInfo, duid, iaid)
+		})
+	}
+	if err == nil {
+		info.ApplyRequestedLease(requestInfo.RequestedLeaseTime)
+	}
+	return err
+}
+
+// reserveV4 reserves the IP of the offer in the cache.
+// The offers to BOOTP clients turn into leases that never expire, as BOOTP clients never renew their lease.
+func (r CachingResolver) reserveV4(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid string) error {
+	if requestInfo.BOOTP {
+		info.Timeouts.Lease = v4.InfiniteLease
+		info.Timeouts.T1RenewalTime = 0
+		info.Timeouts.T2RebindingTime = 0
+	}
+
+	return r.Cache.ReserveV4(info, xid)
+}
+
+// allocateV4 offers the IP of the client's current lease again, if it has one and it's not quarantined.
+// Otherwise a new IP is allocated from the pools.
+func (r CachingResolver) allocateV4(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid string, lookupLease func(*v4.ClientInfoV4) error) error {
+	var leaseInfo v4.ClientInfoV4
+	if err := lookupLease(&leaseInfo); err == nil && r.checkQuarantine(&leaseInfo) == nil {
+		log.Printf("Offering the leased IP '%s' again in transaction '%s'.", leaseInfo.IPAddr, xid)
+		*info = leaseInfo
+		return nil
+	}
+
+	return r.Pool.AllocateV4(info, requestInfo, xid)
+}
+
+// checkQuarantine returns ErrQuarantined if the IP of the info must not be handed out.
+// If the quarantine can't be checked, the IP is handed out.
+func (r CachingResolver) checkQuarantine(info *v4.ClientInfoV4) error {
+	quarantined, err := r.Cache.IsQuarantinedV4(info.IPAddr.String())
+	if err != nil {
+		log.Printf("Can't check whether '%s' is quarantined, assuming it's not: %s", info.IPAddr, err)
+		return nil
+	}
+
+	if quarantined {
+		log.Printf("The IP '%s' is quarantined.", info.IPAddr)
+		return ErrQuarantined
+	}
+
+	return nil
+}
+
+func (r CachingResolver) InformV4ByIP(info *v4.ClientInfoV4, xid, ip string) error {
+	// Informs are not cached, as the client already has an IP and no lease is handed out.
+	return r.Source.InformV4ByIP(info, xid, ip)
+}
+
+func (r CachingResolver) AcknowledgeV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac, ip string) error {
+	err := r.Cache.AcknowledgeV4ByMAC(info, requestInfo, xid, mac, ip)
+	if err != nil {
+		return err
+	}
+
+	// The cached lease might be stale, because the IP was moved in the source in the meantime.
+	// The client is looked up as for an offer, so that clients found by their switch port are found again.
+	var sourceInfo v4.ClientInfoV4
+	err = r.lookupV4ByMAC(&sourceInfo, requestInfo, xid, mac)
+	if err != nil {
+		log.Printf("Can't verify the lease of MAC '%s' with the source, keeping it: %s", mac, err)
+		return nil
+	}
+
+	return r.verifyLeaseV4(info, &sourceInfo, fmt.Sprintf("MAC '%s'", mac),
+		func() error {
+			return r.Cache.ReleaseV4ByMAC(xid, mac, ip)
+		},
+		func(sourceInfo *v4.ClientInfoV4) error {
+			return r.Cache.StoreLeaseV4ByMAC(sourceInfo, xid, mac)
+		})
+}
+
+func (r CachingResolver) AcknowledgeV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid, ip string) error {
+	err := r.Cache.AcknowledgeV4ByID(info, requestInfo, xid, duid, iaid, ip)
+	if err != nil {
+		return err
+	}
+
+	// The cached lease might be stale, because the IP was moved in the source in the meantime.
+	// The client is looked up as for an offer, so that clients found by their MAC are found again.
+	var sourceInfo v4.ClientInfoV4
+	err = r.lookupV4ByID(&sourceInfo, requestInfo, xid, duid, iaid)
+	if err != nil {
+		log.Printf("Can't verify the lease of DUID '%s' and IAID '%s' with the source, keeping it: %s", duid, iaid, err)
+		return nil
+	}
+
+	return r.verifyLeaseV4(info, &sourceInfo, fmt.Sprintf("DUID '%s' and IAID '%s'", duid, iaid),
+		func() error {
+			return r.Cache.ReleaseV4ByID(xid, duid, iaid, ip)
+		},
+		func(sourceInfo *v4.ClientInfoV4) error {
+			return r.Cache.StoreLeaseV4ByID(sourceInfo, xid, duid, iaid)
+		})
+}
+
+// verifyLeaseV4 compares the acknowledged lease with the client's configuration in the source.
+// The lease is released if its IP was moved, and updated if the rest of the configuration changed.
+func (r CachingResolver) verifyLeaseV4(info, sourceInfo *v4.ClientInfoV4, client string, release func() error, store func(*v4.ClientInfoV4) error) error {
+	// The client keeps the lease time it was granted by the cache, as long as it's within the bounds of the source.
+	sourceInfo.ApplyRequestedLease(info.Timeouts.Lease)
+
+	// The client renewed, so the DHCPFORCERENEWs sent for the lease have served their purpose.
+	forceRenewed := !info.Client.ForceRenewSent.IsZero()
+	sourceInfo.Client = info.Client
+	sourceInfo.Client.ForceRenewSent = time.Time{}
+
+	if !sourceInfo.IPAddr.Equal(info.IPAddr) {
+		log.Printf("The IP of %s changed from '%s' to '%s' in the source. Releasing the lease.",
+			client, info.IPAddr, sourceInfo.IPAddr)
+		_ = release()
+		return ErrAddressMismatch
+	}
+
+	if info.ConfigurationDiffers(sourceInfo) || forceRenewed {
+		log.Printf("The configuration of %s changed in the source. Updating the lease.", client)
+		if err := store(sourceInfo); err != nil {
+			return err
+		}
+		*info = *sourceInfo
+	}
+
+	return nil
+}
+
+// RecordForceRenewV4 stores the lease with the time the DHCPFORCERENEWs were started.
+func (r CachingResolver) RecordForceRenewV4(lease *v4.LeaseV4) error {
+	return r.Cache.UpdateLeaseV4(lease)
+}
+
+// VerifyV4ByMAC looks up the MAC in the source only, e.g. to compare it with the cached lease.
+func (r CachingResolver) VerifyV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, mac string) error {
+	return r.Source.OfferV4ByMAC(info, requestInfo, "", mac)
+}
+
+// VerifyV4ByID looks up the DUID and IAID in the source only, e.g. to compare it with the cached lease.
+func (r CachingResolver) VerifyV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, duid, iaid string) error {
+	return r.Source.OfferV4ByID(info, requestInfo, "", duid, iaid)
+}
+
+// LeaseQueryV4ByIP returns the lease of the IP.
+// It returns ErrNotLeased if the IP is not leased, but offered or designated for a Device in the source,
+// and ErrNoRecord if it is unknown.
+func (r CachingResolver) LeaseQueryV4ByIP(lease *v4.LeaseV4, ip string) error {
+	err := r.Cache.LeaseQueryV4ByIP(lease, ip)
+	if err != ErrNoRecord {
+		return err
+	}
+
+	var info v4.ClientInfoV4
+	if r.Source.InformV4ByIP(&info, "", ip) == nil {
+		return ErrNotLeased
+	}
+
+	return ErrNoRecord
+}
+
+// LeaseQueryV4ByMAC returns the lease of the MAC.
+// Leases of clients that identified themselves with a client identifier are found by their MAC as well.
+func (r CachingResolver) LeaseQueryV4ByMAC(lease *v4.LeaseV4, mac string) error {
+	err := r.Cache.LeaseQueryV4ByMAC(lease, mac)
+	if err != ErrNoRecord {
+		return err
+	}
+
+	hardwareAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return ErrNoRecord
+	}
+
+	found := false
+	err = r.Cache.ActiveLeasesV4(func(active *v4.LeaseV4) bool {
+		if bytes.Equal(active.Info.Client.HardwareAddr, hardwareAddr) {
+			*lease = *active
+			found = true
+		}
+		return !found
+	})
+	if err != nil {
+		return err
+	} else if !found {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+func (r CachingResolver) LeaseQueryV4ByID(lease *v4.LeaseV4, duid, iaid string) error {
+	return r.Cache.LeaseQueryV4ByID(lease, duid, iaid)
 }
 
-func (r CachingResolver) AcknowledgeV4ByID(info *v4.ClientInfoV4, xid, duid, iaid, ip string) error {
-	return r.Cache.AcknowledgeV4ByID(info, xid, duid, iaid, ip)
+func (r CachingResolver) ActiveLeasesV4(each func(lease *v4.LeaseV4) bool) error {
+	return r.Cache.ActiveLeasesV4(each)
 }
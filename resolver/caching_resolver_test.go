@@ -0,0 +1,249 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+)
+
+// fakeSource knows the IPs of clients by their MAC, their DUID or the circuit ID of their switch port.
+type fakeSource struct {
+	Sourcer
+	byMAC     map[string]string
+	byDUID    map[string]string
+	byCircuit map[string]string
+}
+
+func (f fakeSource) OfferV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error {
+	ip, ok := f.byMAC[mac]
+	if !ok && requestInfo.RelayAgentInfo != nil {
+		ip, ok = f.byCircuit[string(requestInfo.RelayAgentInfo.CircuitID)]
+	}
+	return f.fill(info, ip, ok)
+}
+
+func (f fakeSource) OfferV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error {
+	ip, ok := f.byDUID[duid]
+	return f.fill(info, ip, ok)
+}
+
+func (f fakeSource) fill(info *v4.ClientInfoV4, ip string, ok bool) error {
+	if !ok {
+		return ErrNoRecord
+	}
+	info.IPAddr = net.ParseIP(ip).To4()
+	info.Timeouts.Lease = time.Hour
+	return nil
+}
+
+// fakeCache holds a single lease.
+type fakeCache struct {
+	Cacher
+	lease    v4.ClientInfoV4
+	released bool
+	stored   *v4.ClientInfoV4
+}
+
+func (c *fakeCache) AcknowledgeV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac, ip string) error {
+	*info = c.lease
+	return nil
+}
+
+func (c *fakeCache) AcknowledgeV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid, ip string) error {
+	*info = c.lease
+	return nil
+}
+
+func (c *fakeCache) IsQuarantinedV4(ip string) (bool, error) {
+	return false, nil
+}
+
+func (c *fakeCache) ReleaseV4ByMAC(xid, mac, ip string) error {
+	c.released = true
+	return nil
+}
+
+func (c *fakeCache) ReleaseV4ByID(xid, duid, iaid, ip string) error {
+	c.released = true
+	return nil
+}
+
+func (c *fakeCache) StoreLeaseV4ByMAC(info *v4.ClientInfoV4, xid, mac string) error {
+	c.stored = info
+	return nil
+}
+
+func (c *fakeCache) StoreLeaseV4ByID(info *v4.ClientInfoV4, xid, duid, iaid string) error {
+	c.stored = info
+	return nil
+}
+
+func TestAcknowledgeV4(t *testing.T) {
+	const mac, duid, iaid = "00:11:22:33:44:55", "00:01:00:01:c7:92:bc:aa", "0"
+	source := fakeSource{
+		byMAC:     map[string]string{"00:11:22:33:44:66": "10.0.0.2"},
+		byDUID:    map[string]string{"00:02:00:00:00:09": "10.0.0.4"},
+		byCircuit: map[string]string{"Gi1/0/1": "10.0.0.1"},
+	}
+	switchPort := &v4.RequestInfoV4{MAC: mac, RelayAgentInfo: &v4.RelayAgentInfo{CircuitID: []byte("Gi1/0/1")}}
+
+	tests := []struct {
+		name         string
+		leasedIP     string
+		byID         bool
+		requestInfo  *v4.RequestInfoV4
+		wantErr      error
+		wantReleased bool
+	}{
+		{name: "found by switch port", leasedIP: "10.0.0.1", requestInfo: switchPort},
+		{name: "moved to another switch port", leasedIP: "10.0.0.3", requestInfo: switchPort,
+			wantErr: ErrAddressMismatch, wantReleased: true},
+		{name: "found by the MAC of the client identifier", leasedIP: "10.0.0.2", byID: true,
+			requestInfo: &v4.RequestInfoV4{MAC: "00:11:22:33:44:66"}},
+		{name: "moved from the MAC of the client identifier", leasedIP: "10.0.0.3", byID: true,
+			requestInfo: &v4.RequestInfoV4{MAC: "00:11:22:33:44:66"}, wantErr: ErrAddressMismatch, wantReleased: true},
+		{name: "unknown to the source", leasedIP: "10.0.0.3", requestInfo: &v4.RequestInfoV4{MAC: mac}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := &fakeCache{}
+			cache.lease.IPAddr = net.ParseIP(tt.leasedIP).To4()
+			cache.lease.Timeouts.Lease = time.Hour
+			r := CachingResolver{Source: source, Cache: cache}
+
+			var info v4.ClientInfoV4
+			var err error
+			if tt.byID {
+				err = r.AcknowledgeV4ByID(&info, tt.requestInfo, "xid", duid, iaid, tt.leasedIP)
+			} else {
+				err = r.AcknowledgeV4ByMAC(&info, tt.requestInfo, "xid", mac, tt.leasedIP)
+			}
+
+			if err != tt.wantErr {
+				t.Errorf("got error '%v', want '%v'", err, tt.wantErr)
+			}
+			if cache.released != tt.wantReleased {
+				t.Errorf("got released %v, want %v", cache.released, tt.wantReleased)
+			}
+			if cache.stored != nil {
+				t.Errorf("expected the unchanged lease not to be stored again, got %+v", cache.stored)
+			}
+		})
+	}
+}
+
+func TestAcknowledgeV4UpdatesConfiguration(t *testing.T) {
+	cache := &fakeCache{}
+	cache.lease.IPAddr = net.IPv4(10, 0, 0, 2).To4()
+	cache.lease.Timeouts.Lease = 30 * time.Minute
+	cache.lease.Options.DomainName = "old.example.com"
+	cache.lease.Client.HardwareAddr = net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x66}
+	cache.lease.Client.ForceRenewSent = time.Unix(1600000000, 0)
+	r := CachingResolver{Source: fakeSource{byMAC: map[string]string{"00:11:22:33:44:66": "10.0.0.2"}}, Cache: cache}
+
+	var info v4.ClientInfoV4
+	if err := r.AcknowledgeV4ByMAC(&info, &v4.RequestInfoV4{}, "xid", "00:11:22:33:44:66", "10.0.0.2"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if cache.stored == nil {
+		t.Fatal("expected the changed configuration to be stored")
+	}
+	if info.Options.DomainName != "" || info.Timeouts.Lease != 30*time.Minute {
+		t.Errorf("expected the configuration of the source with the granted lease time, got %+v", info)
+	}
+	if info.Client.HardwareAddr.String() != "00:11:22:33:44:66" || !info.Client.ForceRenewSent.IsZero() {
+		t.Errorf("expected the client of the lease without pending DHCPFORCERENEWs, got %+v", info.Client)
+	}
+}
+
+// fakePool hands out the same IP to every client.
+type fakePool struct {
+	allocated bool
+}
+
+func (p *fakePool) AllocateV4(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid string) error {
+	p.allocated = true
+	info.IPAddr = net.IPv4(10, 0, 1, 1).To4()
+	return nil
+}
+
+// failingSource can't be queried.
+type failingSource struct {
+	Sourcer
+	err error
+}
+
+func (f failingSource) OfferV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error {
+	return f.err
+}
+
+func (f failingSource) OfferV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error {
+	return f.err
+}
+
+// unleasedCache has no leases and quarantines the IPs in the map.
+type unleasedCache struct {
+	Cacher
+	quarantined map[string]bool
+}
+
+func (c unleasedCache) IsQuarantinedV4(ip string) (bool, error) {
+	return c.quarantined[ip], nil
+}
+
+func (c unleasedCache) LookupV4ByMAC(info *v4.ClientInfoV4, mac string) error {
+	return ErrNoRecord
+}
+
+func (c unleasedCache) LookupV4ByID(info *v4.ClientInfoV4, duid, iaid string) error {
+	return ErrNoRecord
+}
+
+func TestLookupV4FallsBackToThePool(t *testing.T) {
+	errNetbox := errors.New("Netbox is unavailable")
+	known := fakeSource{byMAC: map[string]string{"00:11:22:33:44:55": "10.0.0.1"}}
+
+	tests := []struct {
+		name          string
+		source        Sourcer
+		quarantined   bool
+		wantErr       error
+		wantAllocated bool
+	}{
+		{name: "known", source: known},
+		{name: "quarantined", source: known, quarantined: true, wantAllocated: true},
+		{name: "unknown", source: failingSource{err: ErrNoRecord}, wantAllocated: true},
+		{name: "source unavailable", source: failingSource{err: errNetbox}, wantErr: errNetbox},
+	}
+
+	for _, tt := range tests {
+		for _, byID := range []bool{false, true} {
+			t.Run(tt.name, func(t *testing.T) {
+				pool := &fakePool{}
+				cache := unleasedCache{quarantined: map[string]bool{"10.0.0.1": tt.quarantined}}
+				r := CachingResolver{Source: tt.source, Cache: cache, Pool: pool}
+				requestInfo := &v4.RequestInfoV4{MAC: "00:11:22:33:44:55"}
+
+				var info v4.ClientInfoV4
+				var err error
+				if byID {
+					err = r.lookupV4ByID(&info, requestInfo, "xid", "00:02:00:00:00:09", "0")
+				} else {
+					err = r.lookupV4ByMAC(&info, requestInfo, "xid", "00:11:22:33:44:55")
+				}
+
+				if err != tt.wantErr {
+					t.Errorf("got error '%v', want '%v'", err, tt.wantErr)
+				}
+				if pool.allocated != tt.wantAllocated {
+					t.Errorf("got allocated %v, want %v", pool.allocated, tt.wantAllocated)
+				}
+			})
+		}
+	}
+}
@@ -1,22 +1,79 @@
 package resolver
 
 import (
+	"errors"
+	"time"
+
 	"github.com/cimnine/netbox-dhcp/dhcp/v4"
 	"github.com/cimnine/netbox-dhcp/dhcp/v6"
 )
 
+// ErrNoRecord is returned when neither an offer nor a lease is known for a client,
+// or when the source doesn't know the client.
+var ErrNoRecord = errors.New("no offer or lease found for the client")
+
+// ErrAddressMismatch is returned when a client asks for an IP which is not (or no longer) designated for it.
+var ErrAddressMismatch = errors.New("the requested IP is not designated for the client")
+
+// ErrNotLeased is returned when an IP is known, but not leased to any client.
+var ErrNotLeased = errors.New("the IP is not leased")
+
+// ErrQuarantined is returned when the IP designated for a client is quarantined.
+var ErrQuarantined = errors.New("the IP designated for the client is quarantined")
+
 type Solicitationer interface {
 	SolicitationV6(info *v6.ClientInfoV6, clientID, clientMAC string, iaid string) (bool, error)
 }
 
+// A Requester leases the IPs of an IA_NA to a DHCPv6 client, see RFC8415 Section 18.3.2
+type Requester interface {
+	RequestV6(info *v6.ClientInfoV6, clientID, clientMAC string, iaid string) (bool, error)
+}
+
 type Offerer interface {
-	OfferV4ByMAC(clientInfo *v4.ClientInfoV4, xid, mac string) error
-	OfferV4ByID(clientInfo *v4.ClientInfoV4, xid, duid, iaid string) error
+	OfferV4ByMAC(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error
+	OfferV4ByID(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error
+}
+
+// An Allocator hands out IPs from dynamic pools
+type Allocator interface {
+	AllocateV4(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid string) error
+}
+
+// A Claimer makes sure that an IP is only handed out once
+type Claimer interface {
+	ClaimV4(clientInfo *v4.ClientInfoV4, xid, ip string) (bool, error)
+}
+
+// A Quarantiner keeps IPs from being handed out, e.g. because another host already uses them
+type Quarantiner interface {
+	QuarantineV4(ip string, duration time.Duration, reason string) error
+}
+
+// A Committer hands out a lease without offering it first, see RFC4039
+type Committer interface {
+	CommitV4ByMAC(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac string) error
+	CommitV4ByID(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid string) error
+}
+
+// A ForceRenewRecorder remembers that DHCPFORCERENEWs were sent for a lease, see RFC3203 Section 4
+type ForceRenewRecorder interface {
+	RecordForceRenewV4(lease *v4.LeaseV4) error
+}
+
+// A Verifier looks up the current configuration of a client in the source, bypassing the cache
+type Verifier interface {
+	VerifyV4ByMAC(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, mac string) error
+	VerifyV4ByID(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, duid, iaid string) error
+}
+
+type Informer interface {
+	InformV4ByIP(clientInfo *v4.ClientInfoV4, xid, ip string) error
 }
 
 type Acknowledger interface {
-	AcknowledgeV4ByMAC(clientInfo *v4.ClientInfoV4, xid, mac, ip string) error
-	AcknowledgeV4ByID(clientInfo *v4.ClientInfoV4, xid, duid, iaid, ip string) error
+	AcknowledgeV4ByMAC(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac, ip string) error
+	AcknowledgeV4ByID(clientInfo *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid, ip string) error
 }
 
 type Decliner interface {
@@ -29,10 +86,25 @@ type Releaser interface {
 	ReleaseV4ByID(xid, duid, iaid, ip string) error
 }
 
+// A LeaseQuerier answers queries about the active leases, see RFC4388 and RFC6926
+type LeaseQuerier interface {
+	LeaseQueryV4ByIP(lease *v4.LeaseV4, ip string) error
+	LeaseQueryV4ByMAC(lease *v4.LeaseV4, mac string) error
+	LeaseQueryV4ByID(lease *v4.LeaseV4, duid, iaid string) error
+	ActiveLeasesV4(each func(lease *v4.LeaseV4) bool) error
+}
+
 type Resolver interface {
 	Offerer
+	Committer
+	Verifier
+	ForceRenewRecorder
+	Informer
 	Acknowledger
 	Releaser
 	Decliner
+	Quarantiner
+	LeaseQuerier
 	Solicitationer
+	Requester
 }
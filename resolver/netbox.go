@@ -5,6 +5,7 @@ import (
 	"github.com/cimnine/netbox-dhcp/dhcp/v6"
 	"log"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/cimnine/netbox-dhcp/dhcp/v4"
@@ -15,25 +16,33 @@ import (
 
 type Netbox struct {
 	Client *netbox.Client
+	Sites  *SiteCache
 }
 
 func (n Netbox) SolicitationV6(info *v6.ClientInfoV6, clientID, clientMAC string, iaid string) (bool, error) {
-	_, err := n.findDeviceByDUID(clientID)
+	device, err := n.findDeviceByDUID(clientID)
 	if err == nil {
+		fillDeviceInfoV6(info, device)
 		return true, nil
 	}
 
 	log.Printf("Can't find a Device for client ID '%s'. Trying with MAC.", clientID)
 
-	_, _, _, err = n.findByInterfaceMAC(clientMAC)
+	address, _, device, err := n.findByInterfaceMAC(clientMAC)
 	if err == nil {
+		fillDeviceInfoV6(info, device)
+		if address.To4() == nil {
+			// The IP of the Interface takes precedence over the primary IPv6 of the Device.
+			info.IPAddrs = []net.IP{address}
+		}
 		return true, nil
 	}
 
 	log.Printf("Can't find an Interface for MAC '%s'. Trying via Device.", clientMAC)
 
-	_, _, _, err = n.findByDeviceMAC(clientMAC)
+	device, err = n.findDeviceByMAC(clientMAC)
 	if err == nil {
+		fillDeviceInfoV6(info, device)
 		return true, nil
 	}
 
@@ -41,30 +50,149 @@ func (n Netbox) SolicitationV6(info *v6.ClientInfoV6, clientID, clientMAC string
 	return false, fmt.Errorf("no result for client ID '%s' / MAC '%s' in Netbox", clientID, clientMAC)
 }
 
-func (n Netbox) OfferV4ByMAC(info *v4.ClientInfoV4, transactionID, mac string) error {
+func (n Netbox) OfferV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, transactionID, mac string) error {
 	address, netmask, device, err := n.findByInterfaceMAC(mac)
 	if err == nil {
-		fillClientInfo(info, address, netmask, device)
+		n.fillClientInfo(info, address, netmask, device)
 		return nil
 	}
 
 	log.Printf("Can't find IPv4 via Interface for MAC '%s'. Trying via Device.", mac)
 
+	interfaceErr := err
 	address, netmask, device, err = n.findByDeviceMAC(mac)
 	if err == nil {
-		fillClientInfo(info, address, netmask, device)
+		n.fillClientInfo(info, address, netmask, device)
 		return nil
 	}
 
-	log.Printf("Can't find IPv4 via Device for MAC '%s'. Giving up.", mac)
-	return fmt.Errorf("no result for MAC '%s' in Netbox", mac)
+	if !n.Client.Config.SwitchPortLookup || requestInfo.RelayAgentInfo == nil {
+		log.Printf("Can't find IPv4 via Device for MAC '%s'. Giving up.", mac)
+		return lookupError(interfaceErr, err)
+	}
+
+	log.Printf("Can't find IPv4 via Device for MAC '%s'. Trying via switch port.", mac)
+
+	deviceErr := err
+	address, netmask, device, err = n.findBySwitchPort(requestInfo.RelayAgentInfo)
+	if err == nil {
+		n.fillClientInfo(info, address, netmask, device)
+		return nil
+	}
+
+	log.Printf("Can't find IPv4 via switch port for MAC '%s'. Giving up.", mac)
+	return lookupError(interfaceErr, deviceErr, err)
+}
+
+func (n Netbox) OfferV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, transactionID, duid, iaid string) error {
+	address, netmask, device, err := n.findByDeviceDUID(duid)
+	if err == nil {
+		n.fillClientInfo(info, address, netmask, device)
+		return nil
+	}
+
+	log.Printf("Can't find IPv4 via Device for DUID '%s' and IAID '%s'. Giving up.", duid, iaid)
+	return lookupError(err)
+}
+
+// netboxError is an error of a request to Netbox, as opposed to a lookup that found no matching record.
+type netboxError struct {
+	err error
+}
+
+func (e netboxError) Error() string {
+	return e.err.Error()
+}
+
+// lookupError returns the first error of a request to Netbox among the errors of the lookups,
+// or ErrNoRecord if Netbox was reachable, but none of the lookups found the client.
+func lookupError(errs ...error) error {
+	for _, err := range errs {
+		if _, ok := err.(netboxError); ok {
+			return err
+		}
+	}
+
+	return ErrNoRecord
+}
+
+func (n Netbox) InformV4ByIP(info *v4.ClientInfoV4, transactionID, ip string) error {
+	address, netmask, device, err := n.findByIPAddress(ip)
+	if err != nil {
+		log.Printf("Can't find a Device for the IPv4 '%s'. Giving up.", ip)
+		return err
+	}
+
+	n.fillClientInfo(info, address, netmask, device)
+	return nil
 }
 
-func (n Netbox) OfferV4ByID(info *v4.ClientInfoV4, transactionID, duid, iaid string) error {
-	panic("please implement")
+// DeclineV4ByMAC tags the declined IP in Netbox, so that someone can investigate.
+func (n Netbox) DeclineV4ByMAC(transactionID, mac, ip string) error {
+	return n.tagDeclinedIP(ip)
+}
+
+// DeclineV4ByID tags the declined IP in Netbox, so that someone can investigate.
+func (n Netbox) DeclineV4ByID(transactionID, duid, iaid, ip string) error {
+	return n.tagDeclinedIP(ip)
+}
+
+func (n Netbox) tagDeclinedIP(ipStr string) error {
+	tag := n.Client.Config.DeclineTag
+	if tag == "" {
+		return nil
+	}
+
+	ips, err := n.Client.FindIPAddressesByAddress(ipStr)
+	if err != nil {
+		log.Printf("Can't find the declined IP '%s' in Netbox: %s", ipStr, err)
+		return err
+	}
+
+	if len(ips) == 0 {
+		log.Printf("The declined IP '%s' is not documented in Netbox. Not tagging it.", ipStr)
+		return nil
+	}
+
+	for _, ip := range ips {
+		if containsTag(ip.Tags, tag) {
+			continue
+		}
+
+		log.Printf("Tagging the declined IP '%s' (ID '%d') with '%s' in Netbox.", ipStr, ip.ID, tag)
+
+		err = n.Client.SetIPAddressTags(ip.ID, append(ip.Tags, tag))
+		if err != nil {
+			log.Printf("Can't tag the declined IP '%s' in Netbox: %s", ipStr, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsTag(tags models.Tags, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fillClientInfo fills in the information about the client from the Device and from the Prefix the client lives in.
+// Netbox already merges the config contexts of the Site into the one of the Device, so only the time zone is
+// taken from the Site.
+func (n Netbox) fillClientInfo(info *v4.ClientInfoV4, address net.IP, netmask net.IPMask, device models.Device) {
+	if site := lookupSite(n.Client, n.Sites, device.Site.ID); site.timeZone != "" {
+		info.Options.TimeZone = site.timeZone
+	}
+	fillDeviceInfo(info, address, netmask, device)
+	fillPrefixInfo(n.Client, info)
 }
 
-func fillClientInfo(info *v4.ClientInfoV4, address net.IP, netmask net.IPMask, device models.Device) {
+func fillDeviceInfo(info *v4.ClientInfoV4, address net.IP, netmask net.IPMask, device models.Device) {
 	info.IPAddr = address
 	info.IPMask = netmask
 
@@ -93,6 +221,10 @@ func fillClientInfo(info *v4.ClientInfoV4, address net.IP, netmask net.IPMask, d
 		info.Options.DomainName = domainName
 	}
 
+	if domainSearch := device.ConfigContext.DHCP.DomainSearch; len(domainSearch) > 0 {
+		info.Options.DomainSearch = domainSearch
+	}
+
 	dnsServers := util.ParseIP4s(device.ConfigContext.DHCP.DNSServers)
 	if len(dnsServers) > 0 {
 		info.Options.DomainNameServers = dnsServers
@@ -103,22 +235,228 @@ func fillClientInfo(info *v4.ClientInfoV4, address net.IP, netmask net.IPMask, d
 		info.Options.NTPServers = ntpServers
 	}
 
-	leaseDurationStr := device.ConfigContext.DHCP.LeaseDuration
-	leaseDuration, err := time.ParseDuration(leaseDurationStr)
-	if err != nil && leaseDurationStr != "" {
-		info.Timeouts.Lease = leaseDuration
+	bootMap := device.ConfigContext.DHCP.Boot
+	if len(bootMap) > 0 {
+		// The boot map of the Device replaces the default boot map as a whole.
+		info.BootMap = make(map[string]v4.BootEntry, len(bootMap))
+		for key, boot := range bootMap {
+			info.BootMap[key] = newBootEntry(boot.BootFileName, boot.NextServer, boot.HTTPURL)
+		}
+	}
+
+	if vendorValues := device.ConfigContext.DHCP.VendorOptions; len(vendorValues) > 0 {
+		info.VendorValues = vendorValues
+	}
+
+	customOptions := make([]v4.CustomOption, 0, len(device.ConfigContext.DHCP.Options))
+	for _, option := range device.ConfigContext.DHCP.Options {
+		customOption, err := v4.NewCustomOption(option.Code, option.Type, option.Value)
+		if err != nil {
+			log.Printf("Ignoring the invalid option %d of the Device '%s': %s", option.Code, device.Name, err)
+			continue
+		}
+		customOptions = append(customOptions, customOption)
+	}
+	info.Options.Custom = v4.MergeCustomOptions(info.Options.Custom, customOptions)
+
+	routes := parseConfigContextRoutes(device.ConfigContext.DHCP.ClasslessStaticRoutes)
+	if len(routes) > 0 {
+		info.Options.ClasslessStaticRoutes = routes
+	}
+
+	if leaseDuration, err := time.ParseDuration(device.ConfigContext.DHCP.LeaseDuration); err == nil {
+		info.SetLease(leaseDuration)
+	}
+	if minLease, err := time.ParseDuration(device.ConfigContext.DHCP.MinLeaseDuration); err == nil {
+		info.Timeouts.MinLease = minLease
+	}
+	if maxLease, err := time.ParseDuration(device.ConfigContext.DHCP.MaxLeaseDuration); err == nil {
+		info.Timeouts.MaxLease = maxLease
 	}
 }
 
-func (n Netbox) findByDeviceMAC(mac string) (net.IP, net.IPMask, models.Device, error) {
-	emptyDevice := models.Device{}
+// fillDeviceInfoV6 fills the primary IPv6 of the Device and the options of its config context.
+func fillDeviceInfoV6(info *v6.ClientInfoV6, device models.Device) {
+	if device.PrimaryIP6.ID != 0 {
+		address, _, err := device.PrimaryIP6.Address()
+		if err != nil {
+			log.Printf("Can't parse the primary IPv6 of the Device '%s': %s", device.Name, err)
+		} else {
+			info.IPAddrs = []net.IP{address}
+		}
+	}
+	if hostName := device.Name; hostName != "" {
+		info.Options.HostName = hostName
+	}
+	if domainName := device.ConfigContext.DHCP.DomainName; domainName != "" {
+		info.Options.DomainName = domainName
+	}
+	if domainSearch := device.ConfigContext.DHCP.DomainSearch; len(domainSearch) > 0 {
+		info.Options.DomainSearch = domainSearch
+	}
+}
+
+// lookupSite returns the information about the Site, or nothing if the client has no Site or it can't be received.
+func lookupSite(client *netbox.Client, sites *SiteCache, siteID uint64) siteInfo {
+	if siteID == 0 {
+		return siteInfo{}
+	}
+
+	site, err := sites.get(siteID, func(siteID uint64) (siteInfo, error) {
+		return fetchSiteInfo(client, siteID)
+	})
+	if err != nil {
+		log.Printf("Can't look up the Site '%d': %s", siteID, err)
+	}
+
+	return site
+}
+
+// fillSiteInfo fills the time zone of the Site and the NTP servers of the config contexts assigned to it.
+func fillSiteInfo(info *v4.ClientInfoV4, site siteInfo) {
+	if site.timeZone != "" {
+		info.Options.TimeZone = site.timeZone
+	}
+	if len(site.ntpServers) > 0 {
+		info.Options.NTPServers = site.ntpServers
+	}
+}
+
+// fillPrefixInfo adds the routes of the most specific Prefix that contains the client's IP
+// to the routes the client already has.
+// The routes are read from the custom field configured as 'prefix_routes_field'.
+func fillPrefixInfo(client *netbox.Client, info *v4.ClientInfoV4) {
+	field := client.Config.PrefixRoutesField
+	if field == "" {
+		return
+	}
+
+	prefix, err := findMostSpecificPrefix(client, info.IPAddr)
+	if err != nil {
+		log.Printf("Can't find the Prefix of the IPv4 '%s': %s", info.IPAddr, err)
+		return
+	}
+
+	routes := parseRoutes(prefix.CustomFields[field])
+	info.Options.ClasslessStaticRoutes = mergeRoutes(info.Options.ClasslessStaticRoutes, routes)
+}
+
+// findMostSpecificPrefix returns the IPv4 Prefix with the longest mask that contains the IP.
+func findMostSpecificPrefix(client *netbox.Client, ip net.IP) (models.Prefix, error) {
+	prefixes, err := client.FindPrefixesContaining(ip.String())
+	if err != nil {
+		return models.Prefix{}, err
+	}
+
+	var mostSpecific models.Prefix
+	mostSpecificOnes := -1
+	for _, prefix := range prefixes {
+		if prefix.Family != 4 {
+			continue
+		}
+
+		_, network, err := prefix.Prefix()
+		if err != nil {
+			continue
+		}
+
+		if ones, _ := network.Mask.Size(); ones > mostSpecificOnes {
+			mostSpecific = prefix
+			mostSpecificOnes = ones
+		}
+	}
+
+	if mostSpecificOnes < 0 {
+		return models.Prefix{}, fmt.Errorf("no Prefix contains '%s'", ip)
+	}
+
+	return mostSpecific, nil
+}
 
+func parseConfigContextRoutes(staticRoutes []models.StaticRoute) []v4.Route {
+	routes := make([]v4.Route, 0, len(staticRoutes))
+	for _, staticRoute := range staticRoutes {
+		route, err := v4.ParseRoute(staticRoute.Destination, staticRoute.Router)
+		if err != nil {
+			log.Printf("Can't parse the route '%s via %s': %s", staticRoute.Destination, staticRoute.Router, err)
+			continue
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// parseRoutes parses routes in the form '10.0.0.0/8 via 172.24.0.1, 192.168.0.0/16 via 172.24.0.2'.
+func parseRoutes(value string) []v4.Route {
+	routes := make([]v4.Route, 0)
+	for _, entry := range strings.Split(value, ",") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if len(fields) != 3 || fields[1] != "via" {
+			log.Printf("Can't parse the route '%s'. Expected '<destination> via <router>'.", entry)
+			continue
+		}
+
+		route, err := v4.ParseRoute(fields[0], fields[2])
+		if err != nil {
+			log.Printf("Can't parse the route '%s': %s", entry, err)
+			continue
+		}
+
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// mergeRoutes returns the routes followed by the additional routes to destinations that are not yet routed.
+func mergeRoutes(routes, additional []v4.Route) []v4.Route {
+	merged := append([]v4.Route{}, routes...)
+	for _, route := range additional {
+		routed := false
+		for _, existing := range routes {
+			if existing.Destination.String() == route.Destination.String() {
+				routed = true
+				break
+			}
+		}
+
+		if !routed {
+			merged = append(merged, route)
+		}
+	}
+
+	return merged
+}
+
+func (n Netbox) findByDeviceMAC(mac string) (net.IP, net.IPMask, models.Device, error) {
 	device, err := n.findDeviceByMAC(mac)
 	if err != nil {
 		log.Printf("Can't find Device for MAC '%s'", mac)
-		return nil, nil, emptyDevice, err
+		return nil, nil, models.Device{}, err
 	}
 
+	return findByPrimaryIP4(device)
+}
+
+func (n Netbox) findByDeviceDUID(duid string) (net.IP, net.IPMask, models.Device, error) {
+	device, err := n.findDeviceByDUID(duid)
+	if err != nil {
+		log.Printf("Can't find Device for DUID '%s'", duid)
+		return nil, nil, models.Device{}, err
+	}
+
+	return findByPrimaryIP4(device)
+}
+
+func findByPrimaryIP4(device models.Device) (net.IP, net.IPMask, models.Device, error) {
+	emptyDevice := models.Device{}
+
 	if device.PrimaryIP4.ID == 0 { // empty object
 		log.Printf("The Device with ID %d does not defined a primary IPv4.", device.ID)
 		return nil, nil, emptyDevice, fmt.Errorf("device %d has no primary IPv4", device.ID)
@@ -160,11 +498,126 @@ func (n Netbox) findByInterfaceMAC(mac string) (net.IP, net.IPMask, models.Devic
 	return address, network.Mask, device, nil
 }
 
+func (n Netbox) findByIPAddress(ipStr string) (net.IP, net.IPMask, models.Device, error) {
+	emptyDevice := models.Device{}
+
+	ips, err := n.Client.FindIPAddressesByAddress(ipStr)
+	if err != nil {
+		log.Printf("Error while receiving IPs for the address '%s': %s", ipStr, err)
+		return nil, nil, emptyDevice, netboxError{err}
+	}
+
+	if len(ips) != 1 {
+		log.Printf("Expected exactly one IP for the address '%s', but found %d.", ipStr, len(ips))
+		return nil, nil, emptyDevice, fmt.Errorf("found %d IPs for the address '%s', expected one", len(ips), ipStr)
+	}
+
+	ip := ips[0]
+	if ip.Interface.Device.ID == 0 { // empty object
+		log.Printf("The IP with ID %d is not assigned to an Interface of a Device.", ip.ID)
+		return nil, nil, emptyDevice, fmt.Errorf("IP %d is not assigned to a device", ip.ID)
+	}
+
+	address, network, err := ip.Address()
+	if err != nil {
+		return nil, nil, emptyDevice, err
+	}
+
+	device, err := n.findDeviceByID(ip.Interface.Device.ID)
+	if err != nil {
+		return nil, nil, emptyDevice, err
+	}
+
+	return address, network.Mask, device, nil
+}
+
+// findBySwitchPort follows the cable of the switch port, which is identified by the remote ID (the switch)
+// and the circuit ID (the port) of the relay agent, to the client's interface.
+func (n Netbox) findBySwitchPort(relayAgentInfo *v4.RelayAgentInfo) (net.IP, net.IPMask, models.Device, error) {
+	emptyDevice := models.Device{}
+
+	if len(relayAgentInfo.RemoteID) == 0 || len(relayAgentInfo.CircuitID) == 0 {
+		log.Printf("The relay agent did not send a remote ID and a circuit ID.")
+		return nil, nil, emptyDevice, fmt.Errorf("remote ID or circuit ID missing")
+	}
+
+	switchName := n.findSwitchName(relayAgentInfo.RemoteID)
+	portName := string(relayAgentInfo.CircuitID)
+
+	switchPort, err := n.findInterfaceByDeviceAndName(switchName, portName)
+	if err != nil {
+		return nil, nil, emptyDevice, err
+	}
+
+	connection := switchPort.InterfaceConnection
+	if connection == nil || connection.Interface.ID == 0 {
+		log.Printf("The interface '%s' of the switch '%s' is not connected to another interface.", portName, switchName)
+		return nil, nil, emptyDevice, fmt.Errorf("interface '%s' of '%s' is not connected", portName, switchName)
+	}
+
+	if !connection.Status.Value {
+		log.Printf("The connection of the interface '%s' of the switch '%s' is only '%s'.",
+			portName, switchName, connection.Status.Label)
+		return nil, nil, emptyDevice, fmt.Errorf("interface '%s' of '%s' is not connected", portName, switchName)
+	}
+
+	ip, err := n.findIPAddressByInterfaceID(connection.Interface.ID)
+	if err != nil {
+		log.Printf("Can't find IP address for interface '%d' connected to '%s' of '%s'",
+			connection.Interface.ID, portName, switchName)
+		return nil, nil, emptyDevice, err
+	}
+
+	address, network, err := ip.Address()
+	if err != nil {
+		return nil, nil, emptyDevice, err
+	}
+
+	device, err := n.findDeviceByID(connection.Interface.Device.ID)
+	if err != nil {
+		return nil, nil, emptyDevice, err
+	}
+
+	return address, network.Mask, device, nil
+}
+
+// findSwitchName interprets the remote ID as MAC of the switch if it's six bytes long,
+// and as the name of the switch otherwise.
+func (n Netbox) findSwitchName(remoteID []byte) string {
+	if len(remoteID) == 6 {
+		mac := net.HardwareAddr(remoteID).String()
+
+		device, err := n.findDeviceByMAC(mac)
+		if err == nil {
+			return device.Name
+		}
+
+		log.Printf("Can't find a switch with the MAC '%s'. Using the remote ID as name.", mac)
+	}
+
+	return string(remoteID)
+}
+
+func (n Netbox) findInterfaceByDeviceAndName(deviceName, name string) (iface models.Interface, err error) {
+	ifaces, err := n.Client.FindInterfacesByDeviceAndName(deviceName, name)
+	if err != nil {
+		log.Printf("Error while receiving the interface '%s' of the Device '%s': %s", name, deviceName, err)
+		return iface, netboxError{err}
+	}
+
+	if len(ifaces) != 1 {
+		log.Printf("Expected exactly one interface '%s' on the Device '%s', but found %d.", name, deviceName, len(ifaces))
+		return iface, fmt.Errorf("found %d interfaces '%s' on '%s', expected one", len(ifaces), name, deviceName)
+	}
+
+	return ifaces[0], nil
+}
+
 func (n Netbox) findInterfacesByMAC(mac string) (iface models.Interface, err error) {
 	ifaces, err := n.Client.FindInterfacesByMAC(mac)
 	if err != nil {
 		log.Printf("Error while receiving interfaces for MAC '%s': %s", mac, err)
-		return
+		return iface, netboxError{err}
 	}
 
 	if len(ifaces) == 0 {
@@ -201,7 +654,7 @@ func (n Netbox) findIPAddressByInterfaceID(ifaceID uint64) (ip models.IP, err er
 
 	if err != nil {
 		log.Printf("Error while receiving ips for the interface '%d': %s", ifaceID, err)
-		return
+		return ip, netboxError{err}
 	}
 
 	if len(ips) == 0 {
@@ -222,7 +675,7 @@ func (n Netbox) findDeviceByMAC(mac string) (device models.Device, err error) {
 
 	if err != nil {
 		log.Printf("Error while receiving devices with the MAC '%s'", mac)
-		return
+		return device, netboxError{err}
 	}
 
 	if len(devices) != 1 {
@@ -238,7 +691,7 @@ func (n Netbox) findDeviceByID(id uint64) (device models.Device, err error) {
 
 	if err != nil {
 		log.Printf("Error while receiving Device with ID '%d'", id)
-		return
+		return device, netboxError{err}
 	}
 
 	if devicePtr == nil {
@@ -250,17 +703,17 @@ func (n Netbox) findDeviceByID(id uint64) (device models.Device, err error) {
 }
 
 func (n Netbox) findDeviceByDUID(duid string) (device models.Device, err error) {
-	devicePtr, err := n.Client.GetDeviceByDUID(duid)
+	devices, err := n.Client.FindDevicesByDUID(duid)
 
 	if err != nil {
 		log.Printf("Error while receiving Device with DUID '%s'", duid)
-		return
+		return device, netboxError{err}
 	}
 
-	if devicePtr == nil {
-		log.Printf("Device with DUID '%s' not found", duid)
-		return device, fmt.Errorf("device not found by DUID '%s'", duid)
+	if len(devices) != 1 {
+		log.Printf("Expected exactly one Device with the DUID '%s', but found %d.", duid, len(devices))
+		return device, fmt.Errorf("found %d devices for the DUID '%s', expected one", len(devices), duid)
 	}
 
-	return *devicePtr, nil
+	return devices[0], nil
 }
@@ -0,0 +1,27 @@
+package resolver
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestLookupError(t *testing.T) {
+	notFound := errors.New("interface for MAC '00:11:22:33:44:55' not found")
+	unavailable := netboxError{errors.New("connection refused")}
+
+	tests := []struct {
+		name string
+		errs []error
+		want error
+	}{
+		{name: "nothing found", errs: []error{notFound, notFound}, want: ErrNoRecord},
+		{name: "Netbox unavailable", errs: []error{notFound, unavailable}, want: unavailable},
+		{name: "no lookups", want: ErrNoRecord},
+	}
+
+	for _, tt := range tests {
+		if got := lookupError(tt.errs...); got != tt.want {
+			t.Errorf("%s: got '%v', want '%v'", tt.name, got, tt.want)
+		}
+	}
+}
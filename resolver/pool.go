@@ -0,0 +1,168 @@
+package resolver
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+	"github.com/cimnine/netbox-dhcp/netbox"
+	"github.com/cimnine/netbox-dhcp/netbox/models"
+)
+
+// NetboxPool hands out the free IPs of the Prefixes that are marked as pool in Netbox.
+// An IP is free if it is neither documented in Netbox, nor offered or leased according to the cache.
+type NetboxPool struct {
+	Client *netbox.Client
+	Cache  Claimer
+	Sites  *SiteCache
+}
+
+func (p NetboxPool) AllocateV4(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid string) error {
+	for _, siteID := range p.Client.Config.Sites {
+		pools, err := p.Client.FindPoolPrefixesV4BySite(siteID)
+		if err != nil {
+			log.Printf("Can't receive the pools of the Site '%s': %s", siteID, err)
+			continue
+		}
+
+		for _, pool := range pools {
+			subnet, err := p.findSubnet(pool)
+			if err != nil {
+				log.Printf("Can't determine the subnet of the pool '%s': %s", pool.RawPrefix, err)
+				continue
+			}
+
+			if requestInfo.LinkAddress != nil && !subnet.Contains(requestInfo.LinkAddress) {
+				continue
+			}
+
+			ip, err := p.claimAvailableIP(info, xid, pool, subnet)
+			if err != nil {
+				log.Printf("Can't claim an IPv4 of the pool '%s': %s", pool.RawPrefix, err)
+				continue
+			}
+
+			log.Printf("Claimed IPv4 '%s' of the pool '%s' for transaction '%s'.", ip, pool.RawPrefix, xid)
+
+			info.IPAddr = ip
+			info.IPMask = subnet.Mask
+			fillSiteInfo(info, lookupSite(p.Client, p.Sites, pool.Site.ID))
+			fillPrefixInfo(p.Client, info)
+			return nil
+		}
+	}
+
+	log.Printf("No free IPv4 in the pools for transaction '%s' and link address '%s'.", xid, requestInfo.LinkAddress)
+	return fmt.Errorf("no free IPv4 in the pools for transaction '%s'", xid)
+}
+
+// findSubnet returns the most specific Prefix which contains the pool and is not a pool itself,
+// because the pool might only be a range of the actual subnet.
+// If there's no such Prefix, the pool is the subnet.
+func (p NetboxPool) findSubnet(pool models.Prefix) (*net.IPNet, error) {
+	_, poolNet, err := pool.Prefix()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes, err := p.Client.FindPrefixesContaining(poolNet.IP.String())
+	if err != nil {
+		log.Printf("Can't receive the Prefixes containing the pool '%s', using the pool as subnet: %s", poolNet, err)
+		return poolNet, nil
+	}
+
+	return mostSpecificSubnet(poolNet, prefixes), nil
+}
+
+// mostSpecificSubnet returns the most specific of the Prefixes which contains the pool and is not a pool itself,
+// or the pool if there is none.
+func mostSpecificSubnet(poolNet *net.IPNet, prefixes []models.Prefix) *net.IPNet {
+	poolOnes, _ := poolNet.Mask.Size()
+
+	subnet := poolNet
+	subnetOnes := -1
+	for _, prefix := range prefixes {
+		if prefix.IsPool || prefix.Family != 4 {
+			continue
+		}
+
+		_, prefixNet, err := prefix.Prefix()
+		if err != nil {
+			continue
+		}
+
+		ones, _ := prefixNet.Mask.Size()
+		if ones > poolOnes || ones <= subnetOnes || !prefixNet.Contains(poolNet.IP) {
+			continue
+		}
+
+		subnet = prefixNet
+		subnetOnes = ones
+	}
+
+	return subnet
+}
+
+// claimAvailableIP claims the first available IP of the pool that is not yet claimed in the cache.
+func (p NetboxPool) claimAvailableIP(info *v4.ClientInfoV4, xid string, pool models.Prefix, subnet *net.IPNet) (net.IP, error) {
+	availableIPs, err := p.Client.GetAvailableIPsByPrefixID(pool.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, err := claimFirstIP(p.Cache, info, xid, availableIPs, subnet)
+	if err == nil && ip == nil {
+		return nil, fmt.Errorf("all IPv4s of the pool '%s' are taken", pool.RawPrefix)
+	}
+
+	return ip, err
+}
+
+// claimFirstIP claims the first of the available IPs that is neither the network nor the broadcast address
+// of the subnet, and that is not yet claimed in the cache. It returns nil if all of them are taken.
+func claimFirstIP(cache Claimer, info *v4.ClientInfoV4, xid string, availableIPs []models.AvailableIP, subnet *net.IPNet) (net.IP, error) {
+	for _, availableIP := range availableIPs {
+		ip, _, err := availableIP.Address()
+		if err != nil {
+			continue
+		}
+
+		ip4 := ip.To4()
+		if ip4 == nil || isNetworkOrBroadcast(ip4, subnet) {
+			continue
+		}
+
+		claimed, err := cache.ClaimV4(info, xid, ip4.String())
+		if err != nil {
+			return nil, err
+		}
+
+		if claimed {
+			return ip4, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// isNetworkOrBroadcast checks whether the IP is the network or the broadcast address of the subnet.
+// Subnets with less than four IPs have neither.
+func isNetworkOrBroadcast(ip net.IP, subnet *net.IPNet) bool {
+	ones, bits := subnet.Mask.Size()
+	if bits-ones < 2 {
+		return false
+	}
+
+	network := ip.Mask(subnet.Mask)
+	if ip.Equal(network) {
+		return true
+	}
+
+	broadcast := make(net.IP, len(network))
+	for i := range network {
+		broadcast[i] = network[i] | ^subnet.Mask[i]
+	}
+
+	return ip.Equal(broadcast)
+}
@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+	"github.com/cimnine/netbox-dhcp/netbox/models"
+)
+
+func mustParseCIDR(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("can't parse '%s': %s", cidr, err)
+	}
+	return ipNet
+}
+
+func TestMostSpecificSubnet(t *testing.T) {
+	prefixes := []models.Prefix{
+		{Family: 4, RawPrefix: "10.0.0.0/8"},
+		{Family: 4, RawPrefix: "10.1.0.0/16"},
+		{Family: 4, RawPrefix: "10.1.2.0/24", IsPool: true},
+		{Family: 4, RawPrefix: "10.1.2.128/25"},
+		{Family: 6, RawPrefix: "2001:db8::/32"},
+		{Family: 4, RawPrefix: "invalid"},
+	}
+
+	tests := []struct {
+		pool string
+		want string
+	}{
+		{pool: "10.1.2.0/24", want: "10.1.0.0/16"},
+		{pool: "10.1.2.0/26", want: "10.1.0.0/16"},
+		{pool: "10.2.0.0/24", want: "10.0.0.0/8"},
+		{pool: "192.0.2.0/24", want: "192.0.2.0/24"},
+	}
+
+	for _, tt := range tests {
+		subnet := mostSpecificSubnet(mustParseCIDR(t, tt.pool), prefixes)
+		if subnet.String() != tt.want {
+			t.Errorf("got '%s' for the pool '%s', want '%s'", subnet, tt.pool, tt.want)
+		}
+	}
+}
+
+// fakeClaimer claims every IP once.
+type fakeClaimer struct {
+	claimed map[string]bool
+	err     error
+}
+
+func (c *fakeClaimer) ClaimV4(info *v4.ClientInfoV4, xid, ip string) (bool, error) {
+	if c.err != nil {
+		return false, c.err
+	} else if c.claimed[ip] {
+		return false, nil
+	}
+	c.claimed[ip] = true
+	return true, nil
+}
+
+func TestClaimFirstIP(t *testing.T) {
+	subnet := mustParseCIDR(t, "10.0.0.0/24")
+	availableIPs := []models.AvailableIP{
+		{Family: 4, RawAddress: "10.0.0.0/24"},
+		{Family: 4, RawAddress: "invalid"},
+		{Family: 4, RawAddress: "10.0.0.1/24"},
+		{Family: 4, RawAddress: "10.0.0.2/24"},
+		{Family: 4, RawAddress: "10.0.0.255/24"},
+	}
+
+	claimer := &fakeClaimer{claimed: map[string]bool{"10.0.0.1": true}}
+	var info v4.ClientInfoV4
+
+	ip, err := claimFirstIP(claimer, &info, "xid", availableIPs, subnet)
+	if err != nil || !ip.Equal(net.IPv4(10, 0, 0, 2)) {
+		t.Errorf("got '%s' (%v), want '10.0.0.2'", ip, err)
+	}
+
+	ip, err = claimFirstIP(claimer, &info, "xid", availableIPs, subnet)
+	if err != nil || ip != nil {
+		t.Errorf("got '%s' (%v), want none as all IPs are taken", ip, err)
+	}
+
+	errCache := errors.New("the cache is unavailable")
+	_, err = claimFirstIP(&fakeClaimer{err: errCache}, &info, "xid", availableIPs, subnet)
+	if err != errCache {
+		t.Errorf("got error '%v', want '%v'", err, errCache)
+	}
+}
+
+func TestIsNetworkOrBroadcast(t *testing.T) {
+	tests := []struct {
+		ip     string
+		subnet string
+		want   bool
+	}{
+		{"10.0.0.0", "10.0.0.0/24", true},
+		{"10.0.0.255", "10.0.0.0/24", true},
+		{"10.0.0.1", "10.0.0.0/24", false},
+		{"10.0.0.4", "10.0.0.4/30", true},
+		{"10.0.0.7", "10.0.0.4/30", true},
+		{"10.0.0.5", "10.0.0.4/30", false},
+		{"10.0.0.4", "10.0.0.4/31", false},
+		{"10.0.0.4", "10.0.0.4/32", false},
+	}
+
+	for _, tt := range tests {
+		if got := isNetworkOrBroadcast(net.ParseIP(tt.ip).To4(), mustParseCIDR(t, tt.subnet)); got != tt.want {
+			t.Errorf("isNetworkOrBroadcast('%s', '%s') = %v, want %v", tt.ip, tt.subnet, got, tt.want)
+		}
+	}
+}
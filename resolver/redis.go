@@ -4,9 +4,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/cimnine/netbox-dhcp/ddns"
 	"github.com/cimnine/netbox-dhcp/dhcp/v4"
+	"github.com/cimnine/netbox-dhcp/dhcp/v6"
 	"github.com/go-redis/redis"
 )
 
@@ -18,47 +23,285 @@ type Redis struct {
 // --------------------------------------------------
 // key:						      					value:	timeout:
 // --------------------------------------------------
-// v4;offer;{xid}     						{json}  reservation
-// v4;lease;{mac}     						{json}  lease
-// v4;lease;{duid};{iaid}     		{json}  lease
+// v4;offer;{xid}      						{json}  reservation
+// v4;{mac}            						{json}  lease (none for BOOTP)
+// v4;{duid};{iaid}    						{json}  lease (none for BOOTP)
+// v4;ip;{ip}          						{key}   reservation / lease
+// v4;quarantine;{ip}  						{why}   quarantine
+// v6;{duid};{iaid}    						{json}  valid lifetime
+// v6;ip;{ip}          						{key}   valid lifetime
+// v4;dns;{ip}         						{json}  none
+// v6;dns;{ip}         						{json}  none
+// dns;expiries        						{zset}  none
 // --------------------------------------------------
-
-func (r Redis) AcknowledgeV4ByMAC(info *v4.ClientInfoV4, xid, mac, ip string) error {
-	return r.acknowledgeV4(info, xid, keyMAC(4, mac))
+//
+// The v4;ip;{ip} keys point to the offer or the lease the IP is handed out with.
+// They are used to claim an IP atomically.
+// IPs with a v4;quarantine;{ip} key are not handed out.
+// The v4;dns;{ip} keys hold the DNS records that were added for the lease of the IP.
+// They don't expire, because the records must be removed when the lease expires.
+// Instead, dns;expiries holds their keys, scored by the expiry of the lease.
+
+func (r Redis) AcknowledgeV4ByMAC(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, mac, ip string) error {
+	return r.acknowledgeV4(info, requestInfo, xid, ip, keyMAC(4, mac))
 }
 
-func (r Redis) AcknowledgeV4ByID(info *v4.ClientInfoV4, xid, duid, iaid, ip string) error {
-	return r.acknowledgeV4(info, xid, keyClientID(4, duid, iaid))
+func (r Redis) AcknowledgeV4ByID(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, duid, iaid, ip string) error {
+	return r.acknowledgeV4(info, requestInfo, xid, ip, keyClientID(4, duid, iaid))
 }
 
 func (r Redis) ReserveV4(info *v4.ClientInfoV4, xid string) error {
 	return r.storeOffer(info, xid)
 }
 
+// StoreLeaseV4ByMAC stores the info as lease of the MAC, without an offer preceding it.
+func (r Redis) StoreLeaseV4ByMAC(info *v4.ClientInfoV4, xid, mac string) error {
+	return r.storeLease(info, xid, keyMAC(4, mac))
+}
+
+// StoreLeaseV4ByID stores the info as lease of the DUID and IAID, without an offer preceding it.
+func (r Redis) StoreLeaseV4ByID(info *v4.ClientInfoV4, xid, duid, iaid string) error {
+	return r.storeLease(info, xid, keyClientID(4, duid, iaid))
+}
+
+// StoreLeaseV6 stores the binding of the DUID and IAID to the IPs of the info.
+func (r Redis) StoreLeaseV6(info *v6.ClientInfoV6, duid, iaid string) error {
+	leaseKey := keyClientID(6, duid, iaid)
+
+	infoAsJson, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Can't convert payload for '%s': %s", leaseKey, err)
+		return err
+	}
+
+	log.Printf("Writing info about '%s' to the cache.", leaseKey)
+
+	ttl := info.Timeouts.ValidLifetime
+	status := r.Client.Set(leaseKey, infoAsJson, ttl)
+	if status.Err() != nil {
+		log.Printf("Can't add info for '%s' to the cache: %s", leaseKey, status.Err())
+		return status.Err()
+	}
+
+	for _, ip := range info.IPAddrs {
+		ipKey := keyIP(6, ip.String())
+		if result := r.Client.Set(ipKey, leaseKey, ttl); result.Err() != nil {
+			log.Printf("Can't point '%s' to '%s': %s", ipKey, leaseKey, result.Err())
+			return result.Err()
+		}
+	}
+
+	return nil
+}
+
 func (r Redis) ReleaseV4ByMAC(xid, mac, ip string) error {
-	return r.removeLease(keyMAC(4, mac))
+	return r.removeLease(keyMAC(4, mac), ip)
 }
 
 func (r Redis) ReleaseV4ByID(xid, duid, iaid, ip string) error {
-	return r.removeLease(keyClientID(4, duid, iaid))
+	return r.removeLease(keyClientID(4, duid, iaid), ip)
+}
+
+// claimScript points the IP key to the offer, unless the IP is quarantined or the IP key exists already.
+// Checking the quarantine and claiming the IP happen atomically, so an IP quarantined in between is never claimed.
+// The IP key expires after ARGV[2] milliseconds, or never if that is 0.
+var claimScript = redis.NewScript(`
+if redis.call("exists", KEYS[2]) == 1 then
+	return 0
+end
+if tonumber(ARGV[2]) > 0 then
+	return redis.call("set", KEYS[1], ARGV[1], "nx", "px", ARGV[2]) and 1 or 0
+end
+return redis.call("set", KEYS[1], ARGV[1], "nx") and 1 or 0
+`)
+
+// ClaimV4 claims the IP for the offer of the given transaction.
+// It returns false if the IP is quarantined, or already offered or leased.
+func (r Redis) ClaimV4(info *v4.ClientInfoV4, xid, ip string) (bool, error) {
+	key := keyIP(4, ip)
+	ttl := info.Timeouts.Reservation / time.Millisecond
+
+	claimed, err := claimScript.Run(r.Client, []string{key, keyQuarantine(4, ip)}, keyXID(4, xid), int64(ttl)).Int64()
+	if err != nil {
+		log.Printf("Can't claim '%s' for transaction '%s': %s", key, xid, err)
+		return false, err
+	}
+
+	return claimed == 1, nil
 }
 
-func (r Redis) acknowledgeV4(info *v4.ClientInfoV4, xid, leaseKey string) error {
+// QuarantineV4 prevents the IP from being handed out for the given duration.
+func (r Redis) QuarantineV4(ip string, duration time.Duration, reason string) error {
+	key := keyQuarantine(4, ip)
+
+	log.Printf("Quarantining '%s' for %s: %s", ip, duration, reason)
+
+	result := r.Client.Set(key, reason, duration)
+	if result.Err() != nil {
+		log.Printf("Can't quarantine '%s': %s", ip, result.Err())
+		return result.Err()
+	}
+
+	return nil
+}
+
+func (r Redis) IsQuarantinedV4(ip string) (bool, error) {
+	key := keyQuarantine(4, ip)
+
+	result := r.Client.Exists(key)
+	if result.Err() != nil {
+		log.Printf("Can't check whether '%s' is quarantined: %s", ip, result.Err())
+		return false, result.Err()
+	}
+
+	return result.Val() > 0, nil
+}
+
+func (r Redis) LookupV4ByMAC(info *v4.ClientInfoV4, mac string) error {
+	return r.loadInfo(info, keyMAC(4, mac))
+}
+
+func (r Redis) LookupV4ByID(info *v4.ClientInfoV4, duid, iaid string) error {
+	return r.loadInfo(info, keyClientID(4, duid, iaid))
+}
+
+// LeaseQueryV4ByIP returns the lease of the IP.
+// It returns ErrNoRecord if the IP is neither offered nor leased, and ErrNotLeased if it is only offered.
+func (r Redis) LeaseQueryV4ByIP(lease *v4.LeaseV4, ip string) error {
+	ipKey := keyIP(4, ip)
+
+	result := r.Client.Get(ipKey)
+	if result.Err() == redis.Nil {
+		return ErrNoRecord
+	} else if result.Err() != nil {
+		log.Printf("Can't receive '%s': %s", ipKey, result.Err())
+		return result.Err()
+	}
+
+	if isOfferKey(result.Val()) {
+		return ErrNotLeased
+	}
+
+	return r.loadLease(lease, result.Val())
+}
+
+func (r Redis) LeaseQueryV4ByMAC(lease *v4.LeaseV4, mac string) error {
+	return r.loadLease(lease, keyMAC(4, mac))
+}
+
+func (r Redis) LeaseQueryV4ByID(lease *v4.LeaseV4, duid, iaid string) error {
+	return r.loadLease(lease, keyClientID(4, duid, iaid))
+}
+
+// ActiveLeasesV4 calls each for every lease, until it returns false.
+// The leases are found through the v4;ip;{ip} keys.
+func (r Redis) ActiveLeasesV4(each func(lease *v4.LeaseV4) bool) error {
+	iterator := r.Client.Scan(0, keyIP(4, "*"), 100).Iterator()
+	for iterator.Next() {
+		leaseKey, err := r.Client.Get(iterator.Val()).Result()
+		if err != nil || isOfferKey(leaseKey) {
+			continue
+		}
+
+		var lease v4.LeaseV4
+		if err := r.loadLease(&lease, leaseKey); err != nil {
+			continue
+		}
+
+		if !each(&lease) {
+			return nil
+		}
+	}
+
+	if err := iterator.Err(); err != nil {
+		log.Printf("Can't iterate over the leases: %s", err)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateLeaseV4 replaces the info of the lease of its IP, keeping the time until it expires.
+func (r Redis) UpdateLeaseV4(lease *v4.LeaseV4) error {
+	ipKey := keyIP(4, lease.Info.IPAddr.String())
+
+	leaseKey, err := r.Client.Get(ipKey).Result()
+	if err == redis.Nil {
+		return ErrNoRecord
+	} else if err != nil {
+		log.Printf("Can't receive '%s': %s", ipKey, err)
+		return err
+	} else if isOfferKey(leaseKey) {
+		return ErrNotLeased
+	}
+
+	infoAsJson, err := json.Marshal(lease.Info)
+	if err != nil {
+		log.Printf("Can't convert payload for '%s': %s", leaseKey, err)
+		return err
+	}
+
+	// A TTL of 0 keeps the lease forever.
+	ttl := lease.Remaining
+	if ttl == v4.InfiniteLease {
+		ttl = 0
+	}
+
+	// XX only updates the lease if it still exists.
+	if result := r.Client.SetXX(leaseKey, infoAsJson, ttl); result.Err() != nil {
+		log.Printf("Can't update '%s': %s", leaseKey, result.Err())
+		return result.Err()
+	} else if !result.Val() {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// loadLease loads the info stored at the lease key and the time until the lease expires.
+func (r Redis) loadLease(lease *v4.LeaseV4, leaseKey string) error {
+	err := r.loadInfo(&lease.Info, leaseKey)
+	if err != nil {
+		return err
+	}
+
+	result := r.Client.TTL(leaseKey)
+	if result.Err() != nil {
+		log.Printf("Can't receive the TTL of '%s': %s", leaseKey, result.Err())
+		return result.Err()
+	}
+
+	lease.Remaining = result.Val()
+	if lease.Remaining < 0 {
+		lease.Remaining = v4.InfiniteLease
+	}
+
+	return nil
+}
+
+func (r Redis) acknowledgeV4(info *v4.ClientInfoV4, requestInfo *v4.RequestInfoV4, xid, ip, leaseKey string) error {
 	keyXID := keyXID(4, xid)
 
+	var err error
 	if result := r.Client.Get(keyXID); result.Err() == nil {
 		log.Printf("Persisting the offer with transaction id '%s'", xid)
-		return r.persistOffer(info, xid, leaseKey)
+		err = r.persistOffer(info, requestInfo.RequestedLeaseTime, xid, ip, leaseKey)
+	} else {
+		log.Printf("No offer for transaction '%s' found. Now looking for lease '%s'.", xid, leaseKey)
+		err = r.extendLease(info, requestInfo.RequestedLeaseTime, ip, leaseKey)
+	}
+
+	if err != nil {
+		return err
 	}
 
-	log.Printf("No offer for transaction '%s' found. Now looking for lease '%s'.", xid, leaseKey)
-	return r.extendLease(info, xid, leaseKey)
+	return r.indexIP(info, leaseKey, leaseTTL(info))
 }
 
-func (r Redis) persistOffer(info *v4.ClientInfoV4, xid, leaseKey string) error {
+func (r Redis) persistOffer(info *v4.ClientInfoV4, requested time.Duration, xid, ip, leaseKey string) error {
 	keyXID := keyXID(4, xid)
 
-	err := r.extendLease(info, xid, keyXID)
+	err := r.extendLease(info, requested, ip, keyXID)
 	if err != nil {
 		log.Printf("Unable to extend the offer for transaction '%s' and turning it into a lease.", xid)
 		return err
@@ -75,37 +318,124 @@ func (r Redis) persistOffer(info *v4.ClientInfoV4, xid, leaseKey string) error {
 	return nil
 }
 
-func (r Redis) extendLease(info *v4.ClientInfoV4, _, leaseKey string) error {
-	log.Printf("Receiving info about '%s' from cache.", leaseKey)
+// extendLease loads the info stored at leaseKey and resets its TTL.
+// If ip is not empty, the stored info must be for that ip.
+// If the client requested a lease time, the lease is granted for that long, within the bounds of the info.
+func (r Redis) extendLease(info *v4.ClientInfoV4, requested time.Duration, ip, leaseKey string) error {
+	err := r.loadInfo(info, leaseKey)
+	if err != nil {
+		return err
+	}
 
-	result := r.Client.Get(leaseKey)
-	if result.Err() != nil {
-		log.Printf("Unable to receive info about '%s': %s", leaseKey, result.Err())
+	if ip != "" && !info.IPAddr.Equal(net.ParseIP(ip)) {
+		log.Printf("The IP '%s' was requested, but '%s' holds the IP '%s'.", ip, leaseKey, info.IPAddr)
+		return ErrAddressMismatch
+	}
+
+	// RFC2131, Section 4.3.2: The client may ask for another lease time when it renews.
+	granted := info.Timeouts.Lease
+	info.ApplyRequestedLease(requested)
+	if info.Timeouts.Lease != granted {
+		return r.updateInfo(info, leaseKey)
+	}
+
+	var expireResult *redis.BoolCmd
+	if ttl := leaseTTL(info); ttl > 0 {
+		expireResult = r.Client.Expire(leaseKey, ttl)
+	} else {
+		expireResult = r.Client.Persist(leaseKey)
+	}
+	if expireResult.Err() != nil {
+		log.Printf("Unable to extend TTL on '%s': %s", leaseKey, expireResult.Err())
+		return expireResult.Err()
+	}
+
+	return nil
+}
+
+// updateInfo replaces the info stored at key, if it still exists, and resets its TTL.
+func (r Redis) updateInfo(info *v4.ClientInfoV4, key string) error {
+	infoAsJson, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Can't convert payload for '%s': %s", key, err)
+		return err
+	}
+
+	if result := r.Client.SetXX(key, infoAsJson, leaseTTL(info)); result.Err() != nil {
+		log.Printf("Unable to update '%s': %s", key, result.Err())
+		return result.Err()
+	} else if !result.Val() {
+		return ErrNoRecord
+	}
+
+	return nil
+}
+
+// leaseTTL returns the TTL of the lease, or 0 if the lease never expires.
+func leaseTTL(info *v4.ClientInfoV4) time.Duration {
+	if info.Timeouts.Lease == v4.InfiniteLease {
+		return 0
+	}
+	return info.Timeouts.Lease
+}
+
+// loadInfo loads the info stored at key.
+func (r Redis) loadInfo(info *v4.ClientInfoV4, key string) error {
+	log.Printf("Receiving info about '%s' from cache.", key)
+
+	result := r.Client.Get(key)
+	if result.Err() == redis.Nil {
+		log.Printf("No info about '%s' in the cache.", key)
+		return ErrNoRecord
+	} else if result.Err() != nil {
+		log.Printf("Unable to receive info about '%s': %s", key, result.Err())
 		return result.Err()
 	}
 
 	rawInfo, err := result.Bytes()
 	if err != nil {
-		log.Printf("Unable to extract info from '%s': %s", leaseKey, err)
+		log.Printf("Unable to extract info from '%s': %s", key, err)
 		return err
 	}
 
 	err = json.Unmarshal(rawInfo, info)
 	if err != nil {
-		log.Printf("Unable to reconstruct info from '%s': %s", leaseKey, err)
+		log.Printf("Unable to reconstruct info from '%s': %s", key, err)
 		return err
 	}
 
-	expireResult := r.Client.Expire(leaseKey, info.Timeouts.Lease)
-	if expireResult.Err() != nil {
-		log.Printf("Unable to extend TTL on '%s': %s", leaseKey, expireResult.Err())
-		return expireResult.Err()
+	return nil
+}
+
+// indexIP points the IP of the info to the given key.
+func (r Redis) indexIP(info *v4.ClientInfoV4, key string, ttl time.Duration) error {
+	ipKey := keyIP(4, info.IPAddr.String())
+
+	result := r.Client.Set(ipKey, key, ttl)
+	if result.Err() != nil {
+		log.Printf("Can't point '%s' to '%s': %s", ipKey, key, result.Err())
+		return result.Err()
 	}
 
 	return nil
 }
 
-func (r Redis) removeLease(key string) error {
+// unindexScript removes an IP key, but only if it still points to the given key.
+var unindexScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+func (r Redis) removeLease(key, ip string) error {
+	if ip != "" {
+		ipKey := keyIP(4, ip)
+		if err := unindexScript.Run(r.Client, []string{ipKey}, key).Err(); err != nil {
+			log.Printf("Error while removing '%s' from cache: %s", ipKey, err)
+		}
+	}
+
 	log.Printf("Releasing '%s' from cache.", key)
 
 	result := r.Client.Del(key)
@@ -138,13 +468,139 @@ func (r Redis) storeOffer(info *v4.ClientInfoV4, xid string) error {
 		return status.Err()
 	}
 
+	// Does not overwrite the key if the IP is already claimed or leased (e.g. by the same client).
+	ipKey := keyIP(4, info.IPAddr.String())
+	if result := r.Client.SetNX(ipKey, key, info.Timeouts.Reservation); result.Err() != nil {
+		log.Printf("Can't point '%s' to '%s': %s", ipKey, key, result.Err())
+		return result.Err()
+	}
+
 	log.Printf("Wrote info about '%s' to the cache.", key)
 
 	return nil
 }
 
+func (r Redis) storeLease(info *v4.ClientInfoV4, xid, leaseKey string) error {
+	infoAsJson, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Can't convert payload for transaction '%s': %s", xid, err)
+		return err
+	}
+
+	log.Printf("Writing info about '%s' to the cache.", leaseKey)
+
+	// A TTL of 0 keeps the lease forever.
+	status := r.Client.Set(leaseKey, infoAsJson, leaseTTL(info))
+	if status.Err() != nil {
+		log.Printf("Can't add info for '%s' to the cache: %s", leaseKey, status.Err())
+		return status.Err()
+	}
+
+	return r.indexIP(info, leaseKey, leaseTTL(info))
+}
+
+// TrackDNS remembers the DNS records of a lease until it expires.
+func (r Redis) TrackDNS(record ddns.Record, expiry time.Time) error {
+	recordAsJson, err := json.Marshal(record)
+	if err != nil {
+		log.Printf("Can't convert the DNS records of '%s': %s", record.IP, err)
+		return err
+	}
+
+	key := keyDNS(record.IP)
+
+	if result := r.Client.Set(key, recordAsJson, 0); result.Err() != nil {
+		log.Printf("Can't add the DNS records of '%s' to the cache: %s", record.IP, result.Err())
+		return result.Err()
+	}
+
+	if result := r.Client.ZAdd(keyDNSExpiries, redis.Z{Score: float64(expiry.Unix()), Member: key}); result.Err() != nil {
+		log.Printf("Can't set the expiry of the DNS records of '%s': %s", record.IP, result.Err())
+		return result.Err()
+	}
+
+	return nil
+}
+
+// LookupDNS returns the DNS records that were added for the lease of the IP, or nil if there are none.
+func (r Redis) LookupDNS(ip net.IP) (*ddns.Record, error) {
+	key := keyDNS(ip)
+
+	result := r.Client.Get(key)
+	if result.Err() == redis.Nil {
+		return nil, nil
+	} else if result.Err() != nil {
+		log.Printf("Can't receive the DNS records of '%s': %s", ip, result.Err())
+		return nil, result.Err()
+	}
+
+	var record ddns.Record
+	if err := json.Unmarshal([]byte(result.Val()), &record); err != nil {
+		log.Printf("Unable to reconstruct the DNS records of '%s': %s", ip, err)
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+// UntrackDNS forgets the DNS records of the IP.
+func (r Redis) UntrackDNS(ip net.IP) error {
+	key := keyDNS(ip)
+
+	if result := r.Client.ZRem(keyDNSExpiries, key); result.Err() != nil {
+		log.Printf("Can't remove the expiry of the DNS records of '%s': %s", ip, result.Err())
+		return result.Err()
+	}
+
+	if result := r.Client.Del(key); result.Err() != nil {
+		log.Printf("Can't remove the DNS records of '%s' from the cache: %s", ip, result.Err())
+		return result.Err()
+	}
+
+	return nil
+}
+
+// ExpiredDNS returns the DNS records of the leases that expired before now.
+func (r Redis) ExpiredDNS(now time.Time) ([]ddns.Record, error) {
+	result := r.Client.ZRangeByScore(keyDNSExpiries, redis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.Unix(), 10),
+	})
+	if result.Err() != nil {
+		log.Printf("Can't receive the expired DNS records: %s", result.Err())
+		return nil, result.Err()
+	}
+
+	records := make([]ddns.Record, 0, len(result.Val()))
+	for _, key := range result.Val() {
+		rawRecord, err := r.Client.Get(key).Bytes()
+		if err == redis.Nil {
+			r.Client.ZRem(keyDNSExpiries, key)
+			continue
+		} else if err != nil {
+			log.Printf("Can't receive the DNS records '%s': %s", key, err)
+			continue
+		}
+
+		var record ddns.Record
+		if err := json.Unmarshal(rawRecord, &record); err != nil {
+			log.Printf("Unable to reconstruct the DNS records '%s': %s", key, err)
+			continue
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// isOfferKey returns true if the key is the key of an offer, i.e. 'v4;offer;{xid}'.
+func isOfferKey(key string) bool {
+	return strings.HasPrefix(key, keyXID(4, ""))
+}
+
 func keyXID(family uint8, xid string) string {
-	return fmt.Sprintf("v%d;%s", family, xid)
+	return fmt.Sprintf("v%d;offer;%s", family, xid)
 }
 
 func keyMAC(family uint8, mac string) string {
@@ -154,3 +610,20 @@ func keyMAC(family uint8, mac string) string {
 func keyClientID(family uint8, duid, iaid string) string {
 	return fmt.Sprintf("v%d;%s;%s", family, duid, iaid)
 }
+
+func keyIP(family uint8, ip string) string {
+	return fmt.Sprintf("v%d;ip;%s", family, ip)
+}
+
+func keyQuarantine(family uint8, ip string) string {
+	return fmt.Sprintf("v%d;quarantine;%s", family, ip)
+}
+
+const keyDNSExpiries = "dns;expiries"
+
+func keyDNS(ip net.IP) string {
+	if ip.To4() != nil {
+		return fmt.Sprintf("v4;dns;%s", ip)
+	}
+	return fmt.Sprintf("v6;dns;%s", ip)
+}
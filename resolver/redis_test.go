@@ -0,0 +1,23 @@
+package resolver
+
+import "testing"
+
+func TestIsOfferKey(t *testing.T) {
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: keyXID(4, "0x12345678"), want: true},
+		{key: keyXID(4, "12345678"), want: true},
+		{key: keyMAC(4, "00:11:22:33:44:55")},
+		{key: keyClientID(4, "00:01:00:01:c7:92:bc:aa", "0")},
+		{key: keyClientID(4, "000100012345", "1")},
+		{key: keyIP(4, "10.0.0.1")},
+	}
+
+	for _, tt := range tests {
+		if got := isOfferKey(tt.key); got != tt.want {
+			t.Errorf("isOfferKey('%s') = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cimnine/netbox-dhcp/netbox"
+	"github.com/cimnine/netbox-dhcp/util"
+)
+
+// SiteCacheTTL is how long the information about a Site is remembered.
+const SiteCacheTTL = 5 * time.Minute
+
+// siteInfo is what the clients of a Site are told about it.
+type siteInfo struct {
+	timeZone string
+	// ntpServers are the NTP servers of the config contexts assigned to the Site.
+	ntpServers []net.IP
+	expires    time.Time
+}
+
+// SiteCache remembers the information about Sites for a while,
+// so that not every DHCPOFFER and DHCPACK has to ask Netbox for it.
+// A nil SiteCache asks Netbox every time.
+type SiteCache struct {
+	ttl   time.Duration
+	mutex sync.Mutex
+	sites map[uint64]siteInfo
+}
+
+func NewSiteCache(ttl time.Duration) *SiteCache {
+	return &SiteCache{ttl: ttl, sites: make(map[uint64]siteInfo)}
+}
+
+// get returns the information about the Site, which is fetched if it's not cached or expired.
+// Failed fetches are not remembered.
+func (c *SiteCache) get(siteID uint64, fetch func(siteID uint64) (siteInfo, error)) (siteInfo, error) {
+	if c == nil {
+		return fetch(siteID)
+	}
+
+	c.mutex.Lock()
+	site, ok := c.sites[siteID]
+	c.mutex.Unlock()
+
+	if ok && time.Now().Before(site.expires) {
+		return site, nil
+	}
+
+	site, err := fetch(siteID)
+	if err != nil {
+		return siteInfo{}, err
+	}
+
+	site.expires = time.Now().Add(c.ttl)
+
+	c.mutex.Lock()
+	c.sites[siteID] = site
+	c.mutex.Unlock()
+
+	return site, nil
+}
+
+// fetchSiteInfo asks Netbox for the time zone of the Site and the NTP servers of the config contexts assigned to it.
+func fetchSiteInfo(client *netbox.Client, siteID uint64) (siteInfo, error) {
+	site, err := client.GetSiteByID(siteID)
+	if err != nil {
+		return siteInfo{}, fmt.Errorf("can't receive the Site '%d': %s", siteID, err)
+	}
+
+	configContexts, err := client.FindConfigContextsBySite(siteID)
+	if err != nil {
+		return siteInfo{}, fmt.Errorf("can't receive the config contexts of the Site '%d': %s", siteID, err)
+	}
+
+	// Like Netbox, the config context with the highest weight wins.
+	sort.SliceStable(configContexts, func(i, j int) bool {
+		return configContexts[i].Weight < configContexts[j].Weight
+	})
+
+	info := siteInfo{timeZone: site.TimeZone}
+	for _, configContext := range configContexts {
+		ntpServers := util.ParseIP4s(configContext.Data.DHCP.NTPServers)
+		if len(ntpServers) > 0 {
+			info.ntpServers = ntpServers
+		}
+	}
+
+	return info, nil
+}
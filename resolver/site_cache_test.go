@@ -0,0 +1,54 @@
+package resolver
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSiteCache(t *testing.T) {
+	fetches := 0
+	fetch := func(siteID uint64) (siteInfo, error) {
+		fetches++
+		if siteID == 2 {
+			return siteInfo{}, errors.New("Netbox is unavailable")
+		}
+		return siteInfo{timeZone: "Europe/Zurich"}, nil
+	}
+
+	c := NewSiteCache(time.Hour)
+	for i := 0; i < 3; i++ {
+		if site, err := c.get(1, fetch); err != nil || site.timeZone != "Europe/Zurich" {
+			t.Errorf("got '%s' (%v), want 'Europe/Zurich'", site.timeZone, err)
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("expected the Site to be fetched once, got %d fetches", fetches)
+	}
+
+	fetches = 0
+	for i := 0; i < 2; i++ {
+		if _, err := c.get(2, fetch); err == nil {
+			t.Error("expected the error of the fetch")
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("expected failed fetches not to be remembered, got %d fetches", fetches)
+	}
+
+	fetches = 0
+	expiring := NewSiteCache(0)
+	expiring.get(1, fetch)
+	expiring.get(1, fetch)
+	if fetches != 2 {
+		t.Errorf("expected expired Sites to be fetched again, got %d fetches", fetches)
+	}
+
+	fetches = 0
+	var uncached *SiteCache
+	uncached.get(1, fetch)
+	uncached.get(1, fetch)
+	if fetches != 2 {
+		t.Errorf("expected a nil cache to fetch every time, got %d fetches", fetches)
+	}
+}
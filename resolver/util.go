@@ -30,6 +30,16 @@ func NewClientInfoV4(dhcpConfig *config.DHCPConfig) *v4.ClientInfoV4 {
 		info.Timeouts.Lease = d
 	}
 
+	d, err = time.ParseDuration(dhcpConfig.MinLeaseDuration)
+	if err == nil {
+		info.Timeouts.MinLease = d
+	}
+
+	d, err = time.ParseDuration(dhcpConfig.MaxLeaseDuration)
+	if err == nil {
+		info.Timeouts.MaxLease = d
+	}
+
 	d, err = time.ParseDuration(dhcpConfig.T2Duration)
 	if err != nil {
 		info.Timeouts.T2RebindingTime = info.Timeouts.Lease / 2
@@ -45,35 +55,49 @@ func NewClientInfoV4(dhcpConfig *config.DHCPConfig) *v4.ClientInfoV4 {
 	}
 
 	info.Options.DomainName = dhcpConfig.DefaultOptions.DomainName
+	info.Options.DomainSearch = dhcpConfig.DefaultOptions.DomainSearch
 	info.Options.DomainNameServers = util.ParseIP4s(dhcpConfig.DefaultOptions.DomainNameServers)
 	info.Options.NTPServers = util.ParseIP4s(dhcpConfig.DefaultOptions.NTPServers)
 	info.Options.Routers = util.ParseIP4s(dhcpConfig.DefaultOptions.Routers)
+	info.Options.TimeZone = dhcpConfig.DefaultOptions.TimeZone
+
+	info.Options.Custom = dhcpConfig.DefaultCustomOptions()
+
+	for key, boot := range dhcpConfig.DefaultOptions.Boot {
+		if info.BootMap == nil {
+			info.BootMap = make(map[string]v4.BootEntry)
+		}
+		info.BootMap[key] = newBootEntry(boot.BootFileName, boot.NextServer, boot.HTTPURL)
+	}
 
 	return &info
 }
 
+func newBootEntry(bootFileName, nextServer, httpURL string) v4.BootEntry {
+	return v4.BootEntry{
+		BootFileName: bootFileName,
+		NextServer:   net.ParseIP(nextServer).To4(),
+		HTTPURL:      httpURL,
+	}
+}
+
 func NewClientInfoV6(dhcpConfig *config.DHCPConfig) v6.ClientInfoV6 {
 	info := v6.ClientInfoV6{
 		//NextServer:   net.ParseIP(dhcpConfig.DefaultOptions.NextServer),
 		//BootFileName: dhcpConfig.DefaultOptions.BootFileName,
 	}
 
-	// TODO figure the correct default values out
-	d, err := time.ParseDuration(dhcpConfig.ReservationDuration)
-	if err != nil {
-		info.Timeouts.PreferredLifetime = 1 * time.Minute
-	} else {
-		info.Timeouts.PreferredLifetime = d
-	}
-
-	// TODO figure the correct default values out
-	d, err = time.ParseDuration(dhcpConfig.LeaseDuration)
+	d, err := time.ParseDuration(dhcpConfig.LeaseDuration)
 	if err != nil {
 		info.Timeouts.ValidLifetime = 6 * time.Hour
 	} else {
 		info.Timeouts.ValidLifetime = d
 	}
 
+	// The addresses remain preferred for as long as they are leased,
+	// as T1 and T2 must not be longer than the preferred lifetime, see RFC8415 Section 21.4.
+	info.Timeouts.PreferredLifetime = info.Timeouts.ValidLifetime
+
 	d, err = time.ParseDuration(dhcpConfig.T2Duration)
 	if err != nil {
 		info.Timeouts.T2RebindingTime = info.Timeouts.ValidLifetime / 2
@@ -88,5 +112,8 @@ func NewClientInfoV6(dhcpConfig *config.DHCPConfig) v6.ClientInfoV6 {
 		info.Timeouts.T1RenewalTime = d
 	}
 
+	info.Options.DomainName = dhcpConfig.DefaultOptions.DomainName
+	info.Options.DomainSearch = dhcpConfig.DefaultOptions.DomainSearch
+
 	return info
 }